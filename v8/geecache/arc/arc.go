@@ -0,0 +1,260 @@
+package arc
+
+import (
+	"container/list"
+	"time"
+	"v8/geecache/policy"
+)
+
+// Cache is an ARC (Adaptive Replacement Cache). It is not safe for concurrent access.
+//
+// ARC 同时维护最近访问过一次的 t1 和访问过多次的 t2 两个 LRU 链表，
+// 以及记录最近从它们中淘汰的 key 的幽灵链表 b1、b2。
+// 命中幽灵链表说明对应的链表分到的空间太小，以此自适应地调整 t1 的目标大小 p。
+// 这里的容量按字节计算，而不是论文中的条目个数。
+type Cache struct {
+	maxBytes  int64                    // 允许使用的最大内存（字节），0 表示不限制
+	p         int64                    // t1 的目标字节数
+	t1, t2    *segment                 // 实际保存数据的两个链表
+	b1, b2    *segment                 // 幽灵链表，只记录 key 和大小
+	cache     map[string]*list.Element // 哈希表，值是条目在 t1/t2 中的节点
+	ghosts    map[string]*list.Element // 哈希表，值是幽灵条目在 b1/b2 中的节点
+	OnEvicted policy.OnEvicted         // 当数据被移除时的回调函数
+
+	now func() time.Time // 获取当前时间，测试时可替换
+}
+
+// segment 是一个记录了总字节数的 LRU 链表，头部是最近访问的
+type segment struct {
+	ll     *list.List
+	nbytes int64
+}
+
+func newSegment() *segment {
+	return &segment{ll: list.New()}
+}
+
+type entry struct {
+	key    string
+	value  Value // 幽灵条目没有 value
+	size   int64 // key 和 value 一共占用的字节数
+	expire time.Time
+	seg    *segment // 所在的链表
+}
+
+// Value use Len to count how many bytes it takes
+type Value = policy.Value
+
+var _ policy.Policy = (*Cache)(nil)
+
+// New is the Constructor of Cache
+func New(maxBytes int64, onEvicted policy.OnEvicted) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		t1:        newSegment(),
+		t2:        newSegment(),
+		b1:        newSegment(),
+		b2:        newSegment(),
+		cache:     make(map[string]*list.Element),
+		ghosts:    make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+		now:       time.Now,
+	}
+}
+
+func (c *Cache) pushFront(seg *segment, kv *entry) *list.Element {
+	kv.seg = seg
+	seg.nbytes += kv.size
+	return seg.ll.PushFront(kv)
+}
+
+func (c *Cache) unlink(ele *list.Element) *entry {
+	kv := ele.Value.(*entry)
+	kv.seg.ll.Remove(ele)
+	kv.seg.nbytes -= kv.size
+	return kv
+}
+
+// Get look ups a key's value
+// 命中后条目会被移动到 t2 的头部
+func (c *Cache) Get(key string) (value Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return
+	}
+	kv := ele.Value.(*entry)
+	if policy.Expired(kv.expire, c.now()) {
+		c.removeElement(ele, policy.EvictExpired)
+		return nil, false
+	}
+	c.unlink(ele)
+	c.cache[key] = c.pushFront(c.t2, kv)
+	return kv.value, true
+}
+
+// Peek 查找 key 但不改变它的访问记录，已过期的条目视为不存在
+func (c *Cache) Peek(key string) (value Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return
+	}
+	kv := ele.Value.(*entry)
+	if policy.Expired(kv.expire, c.now()) {
+		return nil, false
+	}
+	return kv.value, true
+}
+
+// Remove removes the provided key from the cache.
+func (c *Cache) Remove(key string) bool {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, policy.EvictRemoved)
+		c.trimGhosts(0)
+		return true
+	}
+	return false
+}
+
+// Clear purges all stored items from the cache.
+func (c *Cache) Clear() {
+	for _, ele := range c.cache {
+		c.removeElement(ele, policy.EvictRemoved)
+	}
+	// 幽灵记录也一并清空，重新开始自适应
+	c.b1, c.b2 = newSegment(), newSegment()
+	c.ghosts = make(map[string]*list.Element)
+	c.p = 0
+}
+
+// RemoveExpired 扫描整个缓存，删除所有已过期的条目，返回删除的个数
+func (c *Cache) RemoveExpired() int {
+	now := c.now()
+	n := 0
+	for _, ele := range c.cache {
+		if policy.Expired(ele.Value.(*entry).expire, now) {
+			c.removeElement(ele, policy.EvictExpired)
+			n++
+		}
+	}
+	c.trimGhosts(0)
+	return n
+}
+
+// removeElement 彻底删除一个条目，不会留下幽灵记录
+func (c *Cache) removeElement(ele *list.Element, reason policy.EvictReason) {
+	kv := c.unlink(ele)
+	delete(c.cache, kv.key)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value, reason)
+	}
+}
+
+// Add adds a value to the cache.
+func (c *Cache) Add(key string, value Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire adds a value that expires at the given time,
+// the zero time means never expire.
+func (c *Cache) AddWithExpire(key string, value Value, expire time.Time) {
+	size := int64(len(key)) + int64(value.Len())
+
+	// 已经在缓存中，更新后视为一次命中
+	if ele, ok := c.cache[key]; ok {
+		kv := c.unlink(ele)
+		kv.value, kv.size, kv.expire = value, size, expire
+		c.cache[key] = c.pushFront(c.t2, kv)
+		c.replace(false)
+		return
+	}
+
+	kv := &entry{key: key, value: value, size: size, expire: expire}
+	if ghost, ok := c.ghosts[key]; ok {
+		// 命中幽灵链表：说明对应的链表太小了，调整 p 之后放入 t2
+		g := c.unlink(ghost)
+		delete(c.ghosts, key)
+		inB2 := g.seg == c.b2
+		if !inB2 {
+			c.p = min(c.maxBytes, c.p+max(ratio(c.b2.nbytes, c.b1.nbytes), 1)*size)
+		} else {
+			c.p = max(0, c.p-max(ratio(c.b1.nbytes, c.b2.nbytes), 1)*size)
+		}
+		c.cache[key] = c.pushFront(c.t2, kv)
+		c.replace(inB2)
+		return
+	}
+
+	// 全新的 key 放入 t1，放入之前先给它腾出幽灵链表的空间
+	c.trimGhosts(size)
+	c.cache[key] = c.pushFront(c.t1, kv)
+	c.replace(false)
+}
+
+func ratio(a, b int64) int64 {
+	if b == 0 {
+		return 1
+	}
+	return a / b
+}
+
+// replace 在超出容量时，根据 p 从 t1 或 t2 的尾部淘汰条目，并记录到对应的幽灵链表
+func (c *Cache) replace(inB2 bool) {
+	for c.maxBytes > 0 && c.t1.nbytes+c.t2.nbytes > c.maxBytes {
+		var ele *list.Element
+		var ghosts *segment
+		if c.t1.ll.Len() > 0 && (c.t1.nbytes > c.p || (inB2 && c.t1.nbytes == c.p) || c.t2.ll.Len() == 0) {
+			ele, ghosts = c.t1.ll.Back(), c.b1
+		} else {
+			ele, ghosts = c.t2.ll.Back(), c.b2
+		}
+		kv := c.unlink(ele)
+		delete(c.cache, kv.key)
+		if c.OnEvicted != nil {
+			c.OnEvicted(kv.key, kv.value, policy.EvictCapacity)
+		}
+		c.ghosts[kv.key] = c.pushFront(ghosts, &entry{key: kv.key, size: kv.size})
+	}
+	// 更新、命中幽灵记录和淘汰都会改变链表的大小，每次之后都重新限制幽灵链表
+	c.trimGhosts(0)
+}
+
+// trimGhosts 限制幽灵链表的大小，为即将放入的大小为 size 的新条目预留空间：
+// t1+b1 不超过 maxBytes，四个链表合计不超过 2*maxBytes
+func (c *Cache) trimGhosts(size int64) {
+	if c.maxBytes <= 0 {
+		return
+	}
+	for c.b1.ll.Len() > 0 && c.t1.nbytes+c.b1.nbytes+size > c.maxBytes {
+		delete(c.ghosts, c.unlink(c.b1.ll.Back()).key)
+	}
+	for c.b2.ll.Len() > 0 && c.t1.nbytes+c.t2.nbytes+c.b1.nbytes+c.b2.nbytes+size > 2*c.maxBytes {
+		delete(c.ghosts, c.unlink(c.b2.ll.Back()).key)
+	}
+}
+
+// Range calls fn for each unexpired entry until fn returns false.
+// 访问过多次的 t2 中的条目先于 t1 被遍历
+func (c *Cache) Range(fn func(key string, value Value) bool) {
+	now := c.now()
+	for _, seg := range []*segment{c.t2, c.t1} {
+		for ele := seg.ll.Front(); ele != nil; ele = ele.Next() {
+			kv := ele.Value.(*entry)
+			if policy.Expired(kv.expire, now) {
+				continue
+			}
+			if !fn(kv.key, kv.value) {
+				return
+			}
+		}
+	}
+}
+
+// Len the number of cache entries
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+// Bytes the number of bytes taken by cache entries, 幽灵条目不占用 value 的内存，不计算在内
+func (c *Cache) Bytes() int64 {
+	return c.t1.nbytes + c.t2.nbytes
+}
@@ -0,0 +1,269 @@
+package arc
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"v8/geecache/policy"
+	"v8/geecache/policy/policytest"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+func TestGet(t *testing.T) {
+	arc := New(int64(0), nil)
+	arc.Add("key1", String("1234"))
+	if v, ok := arc.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+
+	if _, ok := arc.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+func TestReplace(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "k3"
+	v1, v2, v3 := "value1", "value2", "v3"
+	cap := len(k1 + k2 + v1 + v2)
+	arc := New(int64(cap), nil)
+	arc.Add(k1, String(v1))
+	arc.Add(k2, String(v2))
+	arc.Get(k2) // key2 进入 t2，t1 只有 key1
+	arc.Add(k3, String(v3))
+
+	if _, ok := arc.Peek("key1"); ok || arc.Len() != 2 {
+		t.Fatalf("replace key1 failed")
+	}
+	if _, ok := arc.ghosts["key1"]; !ok {
+		t.Fatalf("evicted key1 should be remembered in b1")
+	}
+
+	// t1 占满了整个缓存时，t1+b1 放不下被淘汰的条目，不留下幽灵记录
+	arc = New(int64(cap), nil)
+	arc.Add(k1, String(v1))
+	arc.Add(k2, String(v2))
+	arc.Add(k3, String(v3))
+	if _, ok := arc.ghosts["key1"]; ok || arc.t1.nbytes+arc.b1.nbytes > int64(cap) {
+		t.Fatalf("t1+b1 should not exceed maxBytes")
+	}
+}
+
+func TestPeek(t *testing.T) {
+	arc := New(int64(0), nil)
+	arc.Add("key1", String("1234"))
+	// Peek 不会把只访问过一次的条目晋升到 t2
+	if v, ok := arc.Peek("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("peek key1 failed")
+	}
+	if arc.t1.ll.Len() != 1 || arc.t2.ll.Len() != 0 {
+		t.Fatalf("peek should not move key1 to t2")
+	}
+}
+
+func TestOnEvicted(t *testing.T) {
+	keys := make([]string, 0)
+	callback := func(key string, value Value, reason policy.EvictReason) {
+		keys = append(keys, key)
+	}
+	arc := New(int64(10), callback)
+	arc.Add("key1", String("123456"))
+	arc.Add("k2", String("k2"))
+	arc.Add("k3", String("k3"))
+	arc.Add("k4", String("k4"))
+
+	expect := []string{"key1", "k2"}
+
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s, got %s", expect, keys)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	arc := New(int64(0), nil)
+	arc.Add("key", String("1"))
+	arc.Add("key", String("111"))
+
+	if n := arc.Bytes(); n != int64(len("key")+len("111")) {
+		t.Fatal("expected 6 but got", n)
+	}
+	if arc.t2.ll.Len() != 1 {
+		t.Fatal("updating key should move it to t2")
+	}
+}
+
+func TestGhostHit(t *testing.T) {
+	arc := New(int64(12), nil)
+	arc.Add("k1", String("v1"))
+	arc.Add("k2", String("v2"))
+	arc.Get("k2") // k2 进入 t2
+	arc.Add("k3", String("v3"))
+	arc.Add("k4", String("v4")) // k1 被淘汰进 b1
+
+	if _, ok := arc.Get("k1"); ok {
+		t.Fatalf("k1 should be evicted")
+	}
+	arc.Add("k1", String("v1"))
+	if arc.p == 0 {
+		t.Fatalf("hit in b1 should grow the target size of t1")
+	}
+	if ele, ok := arc.cache["k1"]; !ok || ele.Value.(*entry).seg != arc.t2 {
+		t.Fatalf("ghost hit k1 should be put into t2")
+	}
+}
+
+func TestGhostBounded(t *testing.T) {
+	const maxBytes = 40
+	arc := New(int64(maxBytes), nil)
+	check := func(op string) {
+		t.Helper()
+		t1b1 := arc.t1.nbytes + arc.b1.nbytes
+		total := t1b1 + arc.t2.nbytes + arc.b2.nbytes
+		if t1b1 > maxBytes || total > 2*maxBytes {
+			t.Fatalf("after %s: t1+b1=%d, total=%d exceed the limits", op, t1b1, total)
+		}
+	}
+	// 随机混合新增、更新、命中幽灵记录、访问和删除，每一步之后都检查幽灵链表的大小
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		key := "k" + strconv.Itoa(r.Intn(12))
+		switch r.Intn(3) {
+		case 0:
+			arc.Add(key, String(strings.Repeat("v", 1+r.Intn(8))))
+		case 1:
+			arc.Get(key)
+		case 2:
+			arc.Remove(key)
+		}
+		check(key)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	now := time.Now()
+	reasons := make(map[string]policy.EvictReason)
+	arc := New(int64(0), func(key string, value Value, reason policy.EvictReason) {
+		reasons[key] = reason
+	})
+	arc.now = func() time.Time { return now }
+	arc.AddWithExpire("key1", String("1234"), now.Add(time.Second))
+	arc.Add("key2", String("5678"))
+
+	if _, ok := arc.Get("key1"); !ok {
+		t.Fatalf("cache hit key1 before expire failed")
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, ok := arc.Get("key1"); ok {
+		t.Fatalf("key1 should be expired")
+	}
+	if _, ok := arc.Get("key2"); !ok {
+		t.Fatalf("key2 without ttl should never expire")
+	}
+	if arc.Len() != 1 || arc.t1.nbytes+arc.t2.nbytes != int64(len("key2")+len("5678")) {
+		t.Fatalf("expired key1 should be removed, len=%d", arc.Len())
+	}
+	if reasons["key1"] != policy.EvictExpired {
+		t.Fatalf("expect key1 evicted by %s, got %s", policy.EvictExpired, reasons["key1"])
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	now := time.Now()
+	arc := New(int64(0), nil)
+	arc.now = func() time.Time { return now }
+	arc.AddWithExpire("k1", String("v1"), now.Add(time.Second))
+	arc.AddWithExpire("k2", String("v2"), now.Add(time.Minute))
+	arc.Add("k3", String("v3"))
+
+	now = now.Add(2 * time.Second)
+	if n := arc.RemoveExpired(); n != 1 || arc.Len() != 2 {
+		t.Fatalf("expect k1 swept, got n=%d len=%d", n, arc.Len())
+	}
+	now = now.Add(time.Hour)
+	if n := arc.RemoveExpired(); n != 1 || arc.Len() != 1 {
+		t.Fatalf("expect k2 swept, got n=%d len=%d", n, arc.Len())
+	}
+}
+
+func TestRemove(t *testing.T) {
+	reasons := make(map[string]policy.EvictReason)
+	arc := New(int64(0), func(key string, value Value, reason policy.EvictReason) {
+		reasons[key] = reason
+	})
+	arc.Add("key1", String("1234"))
+	arc.Add("key2", String("5678"))
+
+	if !arc.Remove("key1") || arc.Remove("key1") {
+		t.Fatalf("Remove key1 should report existence")
+	}
+	if _, ok := arc.Get("key1"); ok || arc.Len() != 1 {
+		t.Fatalf("key1 should be removed")
+	}
+	if reasons["key1"] != policy.EvictRemoved {
+		t.Fatalf("expect key1 evicted by %s, got %s", policy.EvictRemoved, reasons["key1"])
+	}
+}
+
+func TestClear(t *testing.T) {
+	arc := New(int64(0), nil)
+	arc.Add("key1", String("1234"))
+	arc.Add("key2", String("5678"))
+	arc.Clear()
+
+	if arc.Len() != 0 {
+		t.Fatalf("Clear should remove all entries, got %d", arc.Len())
+	}
+	if _, ok := arc.Get("key2"); ok {
+		t.Fatalf("key2 should be cleared")
+	}
+}
+
+func BenchmarkZipf(b *testing.B) {
+	policytest.BenchmarkZipf(b, func(maxBytes int64) policy.Policy {
+		return New(maxBytes, nil)
+	})
+}
+
+func BenchmarkZipfScan(b *testing.B) {
+	policytest.BenchmarkZipfScan(b, func(maxBytes int64) policy.Policy {
+		return New(maxBytes, nil)
+	})
+}
+
+func TestRange(t *testing.T) {
+	now := time.Now()
+	c := New(int64(0), nil)
+	c.now = func() time.Time { return now }
+	c.Add("k1", String("v1"))
+	c.AddWithExpire("k2", String("v2"), now.Add(time.Second))
+	c.Add("k3", String("v3"))
+	c.Get("k1")
+	c.Get("k1")
+	now = now.Add(2 * time.Second)
+
+	var keys []string
+	c.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 2 || keys[0] != "k1" {
+		t.Fatalf("expect 2 unexpired keys starting with k1, got %v", keys)
+	}
+
+	keys = keys[:0]
+	c.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return false
+	})
+	if len(keys) != 1 {
+		t.Fatalf("Range should stop when fn returns false, got %v", keys)
+	}
+}
@@ -0,0 +1,74 @@
+package geecache
+
+import (
+	"log"
+	"v8/geecache/consistenthash"
+)
+
+// WithBoundedLoad 开启有界负载的一致性哈希：读取 key 时每个节点正在处理的请求数
+// 不超过平均值的 (1+epsilon) 倍（按权重折算），owner 过载时请求顺着哈希环溢出到下一个节点。
+// 负载是本节点发出的请求和收到的其他节点的请求，写操作始终发给 owner
+func WithBoundedLoad(epsilon float64) ServerOption {
+	if epsilon <= 0 {
+		panic("epsilon must be positive")
+	}
+	return func(o *serverOptions) {
+		o.epsilon = epsilon
+	}
+}
+
+// PickLoaded 选出读取 key 的节点，没有开启有界负载时和 PickPeer 相同。
+// 选中的节点（包括本节点）的负载在返回前加一，done 必须在请求结束后调用
+func (s *Server) PickLoaded(key string) (peer Fetcher, ok bool, done func()) {
+	// 同一次选择中的所有查询使用同一个快照
+	placement := s.route(key)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var peerAddr string
+	if s.loadEpsilon > 0 {
+		peerAddr = consistenthash.GetBounded(placement, key, s.inflight, s.loadEpsilon)
+	} else {
+		peerAddr = placement.Get(key)
+	}
+	if peerAddr == "" {
+		return nil, false, func() {}
+	}
+	client, ok := s.clients[peerAddr]
+	if peerAddr != s.addr && !ok {
+		return nil, false, func() {}
+	}
+	s.inflight[peerAddr]++
+	done = func() { s.release(peerAddr) }
+	if peerAddr == s.addr {
+		return nil, false, done
+	}
+	log.Printf("[cache %s] pick remote peer: %s\n", s.addr, peerAddr)
+	return client, true, done
+}
+
+// Loads 返回每个节点正在处理的请求数
+func (s *Server) Loads() map[string]int64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	loads := make(map[string]int64, len(s.inflight))
+	for addr, n := range s.inflight {
+		loads[addr] = n
+	}
+	return loads
+}
+
+func (s *Server) acquire(addr string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.inflight[addr]++
+}
+
+func (s *Server) release(addr string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.inflight[addr]--; s.inflight[addr] <= 0 {
+		delete(s.inflight, addr)
+	}
+}
+
+var _ LoadPicker = (*Server)(nil)
@@ -0,0 +1,72 @@
+package geecache
+
+import (
+	"math"
+	"testing"
+	"v8/geecache/registry"
+)
+
+func TestServerPickLoaded(t *testing.T) {
+	const epsilon = 0.25
+	svr, err := NewServer("127.0.0.1:9001", WithDiscovery(registry.NewStatic()), WithBoundedLoad(epsilon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	addrs := []string{"127.0.0.1:9001", "127.0.0.1:9002", "127.0.0.1:9003"}
+	svr.SetPeers(addrs...)
+
+	// 同一个热点 key 的并发请求不会全部打到 owner 上
+	const requests = 30
+	var dones []func()
+	picked := make(map[string]int)
+	for i := 0; i < requests; i++ {
+		peer, ok, done := svr.PickLoaded("hot")
+		dones = append(dones, done)
+		if !ok {
+			picked[svr.addr]++
+			continue
+		}
+		picked[peer.(*Client).addr]++
+	}
+	limit := int(math.Ceil((1 + epsilon) * requests / float64(len(addrs))))
+	for _, addr := range addrs {
+		if picked[addr] == 0 || picked[addr] > limit {
+			t.Fatalf("expect every node to take at most %d requests, got %v", limit, picked)
+		}
+	}
+	if loads := svr.Loads(); loads[addrs[1]] != int64(picked[addrs[1]]) {
+		t.Fatalf("loads %v should match picks %v", loads, picked)
+	}
+
+	for _, done := range dones {
+		done()
+	}
+	if loads := svr.Loads(); len(loads) != 0 {
+		t.Fatalf("loads should be released, got %v", loads)
+	}
+	// 负载释放之后又回到 owner
+	owner := svr.placement.Get("hot")
+	peer, ok, done := svr.PickLoaded("hot")
+	defer done()
+	if (owner == svr.addr) == ok || (ok && peer.(*Client).addr != owner) {
+		t.Fatalf("expect owner %s after release", owner)
+	}
+}
+
+func TestServerPickLoadedUnbounded(t *testing.T) {
+	svr, err := NewServer("127.0.0.1:9001", WithDiscovery(registry.NewStatic()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	svr.SetPeers("127.0.0.1:9001", "127.0.0.1:9002", "127.0.0.1:9003")
+
+	owner := svr.placement.Get("hot")
+	for i := 0; i < 10; i++ {
+		peer, ok, _ := svr.PickLoaded("hot")
+		if (owner == svr.addr) == ok || (ok && peer.(*Client).addr != owner) {
+			t.Fatalf("without bounded load every request should go to owner %s", owner)
+		}
+	}
+}
@@ -1,8 +1,23 @@
 package geecache
 
+import "time"
+
 // A ByteView holds an immutable view of bytes.
 type ByteView struct {
-	b []byte
+	b    []byte
+	e    time.Time         // 过期时间，零值表示永不过期
+	meta map[string]string // Retriever 附加的元数据，创建后不再修改
+}
+
+// Expire returns the view's expire time, the zero time means never expire.
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
+// Metadata returns the metadata value attached to key by the Retriever.
+func (v ByteView) Metadata(key string) (string, bool) {
+	value, ok := v.meta[key]
+	return value, ok
 }
 
 // Len returns the view's length
@@ -1,35 +1,252 @@
 package geecache
 
 import (
+	"fmt"
 	"sync"
+	"time"
+	"v8/geecache/arc"
+	"v8/geecache/lfu"
 	"v8/geecache/lru"
+	"v8/geecache/policy"
+	"v8/geecache/tinylfu"
 )
 
+// 可选的缓存淘汰策略
+const (
+	PolicyLRU     = "lru"
+	PolicyLFU     = "lfu"
+	PolicyARC     = "arc"
+	PolicyTinyLFU = "tinylfu"
+)
+
+// 惰性清理过期条目的最小间隔
+var defaultSweepInterval = time.Minute
+
+// newPolicy 根据名称创建淘汰策略，名称为空时使用 LRU
+func newPolicy(name string, maxBytes int64, onEvicted policy.OnEvicted) (policy.Policy, error) {
+	switch name {
+	case "", PolicyLRU:
+		return lru.New(maxBytes, onEvicted), nil
+	case PolicyLFU:
+		return lfu.New(maxBytes, onEvicted), nil
+	case PolicyARC:
+		return arc.New(maxBytes, onEvicted), nil
+	case PolicyTinyLFU:
+		return tinylfu.New(maxBytes, onEvicted), nil
+	}
+	return nil, fmt.Errorf("unknown eviction policy %q", name)
+}
+
+// cache 是按 key 哈希分片的并发缓存，每个分片有独立的锁和淘汰策略，
+// 不同分片上的读写互不阻塞
 type cache struct {
+	shards    []*shard
+	evictions AtomicInt // 超出容量被淘汰的条目数
+	expired   AtomicInt // 过期被清理的条目数
+}
+
+// newCache 创建一个有 shards 个分片的缓存，cacheBytes 平均分给各个分片，除不尽的部分分给前面的分片。
+// 每个分片至少分到 1 字节，cacheBytes 小于 shards 时减少分片数，
+// 否则分片的容量变成 0，也就是不限制容量
+func newCache(cacheBytes int64, shards int, policyName string) *cache {
+	if shards < 1 {
+		shards = 1
+	}
+	if cacheBytes > 0 && cacheBytes < int64(shards) {
+		shards = int(cacheBytes)
+	}
+	c := &cache{shards: make([]*shard, shards)}
+	per, rem := cacheBytes/int64(shards), cacheBytes%int64(shards)
+	for i := range c.shards {
+		bytes := per
+		if int64(i) < rem {
+			bytes++
+		}
+		c.shards[i] = &shard{policy: policyName, cacheBytes: bytes, onEvicted: c.onEvicted}
+	}
+	return c
+}
+
+// onEvicted 统计被淘汰和过期的条目，在分片的锁内调用
+func (c *cache) onEvicted(key string, value policy.Value, reason policy.EvictReason) {
+	switch reason {
+	case policy.EvictCapacity:
+		c.evictions.Add(1)
+	case policy.EvictExpired:
+		c.expired.Add(1)
+	}
+}
+
+// stats 汇总所有分片的统计
+func (c *cache) stats() CacheStats {
+	st := CacheStats{Evictions: c.evictions.Get(), Expired: c.expired.Get()}
+	for _, s := range c.shards {
+		bytes, items := s.size()
+		st.Bytes += bytes
+		st.Items += items
+	}
+	return st
+}
+
+// shardFor 用 FNV-1a 哈希选出 key 所在的分片
+func (c *cache) shardFor(key string) *shard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return c.shards[h%uint32(len(c.shards))]
+}
+
+func (c *cache) add(key string, value ByteView) {
+	c.shardFor(key).add(key, value)
+}
+
+func (c *cache) get(key string) (value ByteView, ok bool) {
+	return c.shardFor(key).get(key)
+}
+
+// peek 查找 key 但不改变它在淘汰策略中的访问记录
+func (c *cache) peek(key string) (value ByteView, ok bool) {
+	return c.shardFor(key).peek(key)
+}
+
+func (c *cache) remove(key string) bool {
+	return c.shardFor(key).remove(key)
+}
+
+// removeIf 在 key 当前的值满足 match 时删除 key，查找和删除在同一次加锁内完成
+func (c *cache) removeIf(key string, match func(value ByteView) bool) bool {
+	return c.shardFor(key).removeIf(key, match)
+}
+
+func (c *cache) clear() {
+	for _, s := range c.shards {
+		s.clear()
+	}
+}
+
+// rangeEntries 依次对每个分片中未过期的条目调用 fn，fn 返回 false 时停止遍历。
+// 每个分片先在锁内拷贝出条目再调用 fn，fn 中可以访问缓存
+func (c *cache) rangeEntries(fn func(key string, value ByteView) bool) {
+	for _, s := range c.shards {
+		for _, e := range s.entries() {
+			if !fn(e.key, e.value) {
+				return
+			}
+		}
+	}
+}
+
+type cacheEntry struct {
+	key   string
+	value ByteView
+}
+
+type shard struct {
 	mux        sync.Mutex
-	lru        *lru.Cache
+	policy     string        // 淘汰策略名称，为空时使用 LRU
+	store      policy.Policy // 实际存储数据的淘汰策略
 	cacheBytes int64
+	lastSweep  time.Time        // 上一次清理过期条目的时间
+	onEvicted  policy.OnEvicted // 条目被移除时的回调，用于统计
 }
 
 // 延迟绑定，需要的时候才创建，可以减少内存，比较灵活
-func (c *cache) add(key string, value ByteView) {
+func (c *shard) add(key string, value ByteView) {
 	c.mux.Lock()
 	defer c.mux.Unlock()
-	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+	if c.store == nil {
+		// 策略名称在 NewGroup 时已经校验过
+		c.store, _ = newPolicy(c.policy, c.cacheBytes, c.onEvicted)
 	}
 
-	c.lru.Add(key, value)
+	c.store.AddWithExpire(key, value, value.e)
+
+	// 写入时顺带清理一次过期数据，避免过期但不再被访问的 key 一直占着内存
+	if now := time.Now(); now.Sub(c.lastSweep) >= defaultSweepInterval {
+		c.lastSweep = now
+		c.store.RemoveExpired()
+	}
 }
 
-func (c *cache) get(key string) (value ByteView, ok bool) {
+func (c *shard) get(key string) (value ByteView, ok bool) {
 	c.mux.Lock()
 	defer c.mux.Unlock()
-	if c.lru == nil {
+	if c.store == nil {
 		return
 	}
-	if value, ok := c.lru.Get(key); ok {
+	if value, ok := c.store.Get(key); ok {
 		return value.(ByteView), true
 	}
 	return
 }
+
+func (c *shard) peek(key string) (value ByteView, ok bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.store == nil {
+		return
+	}
+	if value, ok := c.store.Peek(key); ok {
+		return value.(ByteView), true
+	}
+	return
+}
+
+func (c *shard) remove(key string) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.store == nil {
+		return false
+	}
+	return c.store.Remove(key)
+}
+
+func (c *shard) removeIf(key string, match func(value ByteView) bool) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.store == nil {
+		return false
+	}
+	if value, ok := c.store.Peek(key); !ok || !match(value.(ByteView)) {
+		return false
+	}
+	return c.store.Remove(key)
+}
+
+// entries 返回分片中所有未过期条目的拷贝
+func (c *shard) entries() []cacheEntry {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.store == nil {
+		return nil
+	}
+	entries := make([]cacheEntry, 0, c.store.Len())
+	c.store.Range(func(key string, value policy.Value) bool {
+		entries = append(entries, cacheEntry{key: key, value: value.(ByteView)})
+		return true
+	})
+	return entries
+}
+
+// size 返回分片中条目占用的字节数和条目个数
+func (c *shard) size() (bytes, items int64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.store == nil {
+		return 0, 0
+	}
+	return c.store.Bytes(), int64(c.store.Len())
+}
+
+func (c *shard) clear() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.store != nil {
+		c.store.Clear()
+	}
+}
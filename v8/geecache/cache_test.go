@@ -0,0 +1,92 @@
+package geecache
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestCacheShards(t *testing.T) {
+	c := newCache(1<<20, 8, PolicyLRU)
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		c.add(key, ByteView{b: []byte(key)})
+	}
+	used := 0
+	for _, s := range c.shards {
+		if s.store != nil && s.store.Len() > 0 {
+			used++
+		}
+		if s.cacheBytes != 1<<20/8 {
+			t.Fatalf("each shard should get 1/8 of cacheBytes, got %d", s.cacheBytes)
+		}
+	}
+	if used != len(c.shards) {
+		t.Fatalf("keys should spread over all shards, only %d used", used)
+	}
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if v, ok := c.get(key); !ok || v.String() != key {
+			t.Fatalf("failed to get %s", key)
+		}
+	}
+}
+
+func TestCacheSmallBudget(t *testing.T) {
+	// 容量小于分片数时每个分片仍然有上限，而不是变成不限制容量
+	c := newCache(3, 8, PolicyLRU)
+	if len(c.shards) != 3 {
+		t.Fatalf("expect shards clamped to 3, got %d", len(c.shards))
+	}
+	for i := 0; i < 100; i++ {
+		c.add(strconv.Itoa(i), ByteView{})
+	}
+	if st := c.stats(); st.Bytes > 3 {
+		t.Fatalf("cache should stay within 3 bytes, got %d", st.Bytes)
+	}
+
+	// 除不尽的部分分给前面的分片，总容量不变
+	c = newCache(10, 4, PolicyLRU)
+	var total int64
+	for _, s := range c.shards {
+		total += s.cacheBytes
+	}
+	if total != 10 || c.shards[0].cacheBytes != 3 || c.shards[3].cacheBytes != 2 {
+		t.Fatalf("unexpected shard budgets, total %d", total)
+	}
+
+	g := NewGroup("small-budget", 64, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithShards(16))
+	for i := 0; i < 100; i++ {
+		g.populateCache(strconv.Itoa(i), ByteView{b: []byte("v")})
+		g.hotCache.add(strconv.Itoa(i), ByteView{b: []byte("v")})
+	}
+	hotBytes := int64(64 * defaultHotCachePercent / 100)
+	if main, hot := g.mainCache.stats().Bytes, g.hotCache.stats().Bytes; main > 64-hotBytes || hot > hotBytes {
+		t.Fatalf("main %d and hot %d bytes should be bounded by their budgets", main, hot)
+	}
+}
+
+// 用 -cpu 1,2,4,8 运行可以看到分片数越多，吞吐随 GOMAXPROCS 增长得越好
+func BenchmarkCacheGet(b *testing.B) {
+	const keys = 1 << 14
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			c := newCache(1<<30, shards, PolicyLRU)
+			names := make([]string, keys)
+			for i := range names {
+				names[i] = strconv.Itoa(i)
+				c.add(names[i], ByteView{b: []byte(names[i])})
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					c.get(names[i&(keys-1)])
+					i++
+				}
+			})
+		})
+	}
+}
@@ -2,8 +2,11 @@ package geecache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"sync"
 	"time"
 	"v8/geecache/geecachepb"
 )
@@ -11,6 +14,10 @@ import (
 type Client struct {
 	name string // 服务名称 geecache/ip:addr
 	addr string //就是记录 ip 加上端口的 形式  ip:port
+
+	mux    sync.Mutex
+	conn   *grpc.ClientConn // 与 peer 的长连接，第一次调用时创建，之后所有调用共用
+	closed bool
 }
 
 func NewClient(name, addr string) *Client {
@@ -20,36 +27,144 @@ func NewClient(name, addr string) *Client {
 // 判断是否实现了 PeerGetter
 var _ Fetcher = (*Client)(nil)
 
-func (c *Client) Fetch(in *geecachepb.Request, out *geecachepb.Response) error {
-	// 创建一个etcd client
-	//log.Println("1111111111111111")
-	//cli, err := clientv3.New(defaultEtcdConfig)
-	//if err != nil {
-	//	return err
-	//}
-	//defer cli.Close()
-
-	// 发现服务 取得与服务的连接
-	conn, err := grpc.NewClient(
-		c.addr,
-		grpc.WithInsecure(),
-		grpc.WithBlock(),
-	)
+// 判断是否实现了 Updater
+var _ Updater = (*Client)(nil)
+
+// 判断是否实现了 Migrator
+var _ Migrator = (*Client)(nil)
+
+// ErrClientClosed 表示 Client 已经被关闭，通常是对应的 peer 已经下线
+var ErrClientClosed = errors.New("geecache: client is closed")
+
+// 调用方没有设置 deadline 时使用的默认超时时间
+var defaultRPCTimeout = 10 * time.Second
+
+// getConn 返回与 peer 的长连接，没有连接或连接已经关闭时重新创建
+func (c *Client) getConn() (*grpc.ClientConn, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	if c.conn != nil {
+		switch c.conn.GetState() {
+		case connectivity.Shutdown:
+			// 连接已经被关闭，下面重新创建
+		case connectivity.TransientFailure:
+			// grpc 会按退避时间自动重连，这里让它立刻重试，避免 peer 恢复后还要等待
+			c.conn.ResetConnectBackoff()
+			return c.conn, nil
+		default:
+			return c.conn, nil
+		}
+	}
+	// grpc.NewClient 不会立刻建立连接，第一次 rpc 时才会连接
+	conn, err := grpc.NewClient(c.addr, grpc.WithInsecure(),
+		grpc.WithChainUnaryInterceptor(unaryClientTrace), grpc.WithChainStreamInterceptor(streamClientTrace))
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// Close 关闭与 peer 的连接，之后的调用都会返回 ErrClientClosed
+func (c *Client) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// call 取得与 peer 的连接，并执行一次 rpc 调用
+// ctx 的 deadline 会通过 grpc 传递给远端，没有 deadline 时使用 defaultRPCTimeout
+func (c *Client) call(ctx context.Context, fn func(ctx context.Context, client geecachepb.GroupCacheClient) error) error {
+	conn, err := c.getConn()
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
 
-	grpcClient := geecachepb.NewGroupCacheClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultRPCTimeout)
+		defer cancel()
+	}
+	return fn(ctx, geecachepb.NewGroupCacheClient(conn))
+}
+
+func (c *Client) Fetch(ctx context.Context, in *geecachepb.Request, out *geecachepb.Response) error {
+	return c.call(ctx, func(ctx context.Context, client geecachepb.GroupCacheClient) error {
+		resp, err := client.Get(ctx, in)
+		if err != nil {
+			return fmt.Errorf("could not get %s/%s from peer %s,err is %s", in.GetGroup(), in.GetKey(), c.name, err.Error())
+		}
+		// 不能直接 *out = *resp，protobuf 的消息内部带锁，不允许值拷贝
+		out.Value = resp.GetValue()
+		out.Expire = resp.GetExpire()
+		return nil
+	})
+}
+
+func (c *Client) Set(ctx context.Context, in *geecachepb.SetRequest) error {
+	return c.call(ctx, func(ctx context.Context, client geecachepb.GroupCacheClient) error {
+		if _, err := client.Set(ctx, in); err != nil {
+			return fmt.Errorf("could not set %s/%s to peer %s,err is %s", in.GetGroup(), in.GetKey(), c.name, err.Error())
+		}
+		return nil
+	})
+}
+
+func (c *Client) Remove(ctx context.Context, in *geecachepb.Request) error {
+	return c.call(ctx, func(ctx context.Context, client geecachepb.GroupCacheClient) error {
+		if _, err := client.Remove(ctx, in); err != nil {
+			return fmt.Errorf("could not remove %s/%s from peer %s,err is %s", in.GetGroup(), in.GetKey(), c.name, err.Error())
+		}
+		return nil
+	})
+}
 
-	resp, err := grpcClient.Get(ctx, in)
+func (c *Client) Purge(ctx context.Context, in *geecachepb.PurgeRequest) error {
+	return c.call(ctx, func(ctx context.Context, client geecachepb.GroupCacheClient) error {
+		if _, err := client.Purge(ctx, in); err != nil {
+			return fmt.Errorf("could not purge %s from peer %s,err is %s", in.GetGroup(), c.name, err.Error())
+		}
+		return nil
+	})
+}
 
+func (c *Client) Invalidate(ctx context.Context, in *geecachepb.InvalidateRequest) error {
+	return c.call(ctx, func(ctx context.Context, client geecachepb.GroupCacheClient) error {
+		if _, err := client.Invalidate(ctx, in); err != nil {
+			return fmt.Errorf("could not invalidate %s/%s on peer %s,err is %w", in.GetGroup(), in.GetKey(), c.name, err)
+		}
+		return nil
+	})
+}
+
+// Migrate 迁移的条目可能很多，不使用 defaultRPCTimeout，由 ctx 控制何时结束
+func (c *Client) Migrate(ctx context.Context, entries <-chan *geecachepb.SetRequest) (int64, error) {
+	conn, err := c.getConn()
+	if err != nil {
+		return 0, err
+	}
+	stream, err := geecachepb.NewGroupCacheClient(conn).Migrate(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not migrate to peer %s,err is %s", c.name, err.Error())
+	}
+	for in := range entries {
+		if err := stream.Send(in); err != nil {
+			return 0, fmt.Errorf("could not migrate %s/%s to peer %s,err is %s", in.GetGroup(), in.GetKey(), c.name, err.Error())
+		}
+	}
+	resp, err := stream.CloseAndRecv()
 	if err != nil {
-		return fmt.Errorf("could not get %s/%s from peer %s,err is %s", in.GetGroup(), in.GetKey(), c.name, err.Error())
+		return 0, fmt.Errorf("could not migrate to peer %s,err is %s", c.name, err.Error())
 	}
-	*out = *resp // ✅ 把 resp 的值 copy 到外部传入的 out 指针
-	resp = nil   //置空不再使用的变量,可以让 GC 更早地知道 resp 不再被用到了
-	return nil
+	return resp.GetReceived(), nil
 }
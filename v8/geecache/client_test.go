@@ -0,0 +1,126 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"v8/geecache/geecachepb"
+)
+
+// startClientTestPeer 启动一个带有 client-test group 的远端节点
+func startClientTestPeer(t testing.TB) string {
+	if GetGroup("client-test") == nil {
+		NewGroup("client-test", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}))
+	}
+	svr, err := NewServer("127.0.0.1:9004")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return startTestPeer(t, svr)
+}
+
+func TestClientReuseConn(t *testing.T) {
+	client := NewClient("geecache/peer", startClientTestPeer(t))
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out := &geecachepb.Response{}
+			errs <- client.Fetch(context.Background(), &geecachepb.Request{Group: "client-test", Key: "Tom"}, out)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn := client.conn
+	out := &geecachepb.Response{}
+	if err := client.Fetch(context.Background(), &geecachepb.Request{Group: "client-test", Key: "Jack"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if client.conn != conn {
+		t.Fatalf("Fetch should reuse the existing connection")
+	}
+	if string(out.GetValue()) != "db-Jack" {
+		t.Fatalf("expect db-Jack, got %s", out.GetValue())
+	}
+
+	// 连接被意外关闭后会重新创建
+	conn.Close()
+	if err := client.Fetch(context.Background(), &geecachepb.Request{Group: "client-test", Key: "Jack"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if client.conn == conn {
+		t.Fatalf("a shutdown connection should be replaced")
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	client := NewClient("geecache/peer", startClientTestPeer(t))
+	out := &geecachepb.Response{}
+	if err := client.Fetch(context.Background(), &geecachepb.Request{Group: "client-test", Key: "Tom"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	err := client.Fetch(context.Background(), &geecachepb.Request{Group: "client-test", Key: "Tom"}, out)
+	if !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("expect ErrClientClosed, got %v", err)
+	}
+}
+
+func TestSetPeersClosesRemoved(t *testing.T) {
+	svr, err := NewServer("127.0.0.1:9005")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr.SetPeers("127.0.0.1:9006", "127.0.0.1:9007")
+	kept, removed := svr.clients["127.0.0.1:9006"], svr.clients["127.0.0.1:9007"]
+
+	svr.SetPeers("127.0.0.1:9006", "127.0.0.1:9008")
+	if svr.clients["127.0.0.1:9006"] != kept {
+		t.Fatalf("client of a remaining peer should be reused")
+	}
+	if !removed.closed || kept.closed {
+		t.Fatalf("only the client of the removed peer should be closed")
+	}
+}
+
+// BenchmarkFetch 比较复用长连接和每次调用都重新建立连接时，一次远端命中的耗时
+func BenchmarkFetch(b *testing.B) {
+	addr := startClientTestPeer(b)
+	req := &geecachepb.Request{Group: "client-test", Key: "Tom"}
+
+	b.Run("pooled", func(b *testing.B) {
+		client := NewClient("geecache/peer", addr)
+		defer client.Close()
+		out := &geecachepb.Response{}
+		for i := 0; i < b.N; i++ {
+			if err := client.Fetch(context.Background(), req, out); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("dial-per-call", func(b *testing.B) {
+		out := &geecachepb.Response{}
+		for i := 0; i < b.N; i++ {
+			client := NewClient("geecache/peer", addr)
+			if err := client.Fetch(context.Background(), req, out); err != nil {
+				b.Fatal(err)
+			}
+			client.Close()
+		}
+	})
+}
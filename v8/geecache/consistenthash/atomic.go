@@ -0,0 +1,147 @@
+package consistenthash
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Atomic 是并发安全的 Placement。
+// 每次修改节点时都用 newPlacement 按新的节点列表重新建一个 Placement，
+// 建好之后原子地替换掉旧的快照（copy-on-write），快照建好之后不再修改，
+// 所以 Get、GetN 等读操作不需要加锁，也不会看到修改到一半的哈希环
+type Atomic struct {
+	mu           sync.Mutex // 串行化写操作
+	newPlacement func() Placement
+	snapshot     atomic.Pointer[snapshot]
+}
+
+// snapshot 是某一时刻的节点列表和按它建好的 Placement，两者都不再修改
+type snapshot struct {
+	placement Placement
+	weights   map[string]int
+}
+
+var _ Placement = (*Atomic)(nil)
+
+// NewAtomic creates an Atomic instance, newPlacement 用于创建每个快照
+func NewAtomic(newPlacement func() Placement) *Atomic {
+	a := &Atomic{newPlacement: newPlacement}
+	a.snapshot.Store(&snapshot{placement: newPlacement(), weights: map[string]int{}})
+	return a
+}
+
+// Snapshot 返回当前的 Placement，多次查询需要看到同一份节点列表时使用。
+// 返回值不能被修改
+func (a *Atomic) Snapshot() Placement {
+	return a.snapshot.Load().placement
+}
+
+// Set 把节点列表整体替换为 weights，只产生一个新快照
+func (a *Atomic) Set(weights map[string]int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	next := make(map[string]int, len(weights))
+	for node, weight := range weights {
+		next[node] = weight
+	}
+	a.store(next)
+}
+
+// RegisterWeighted 以 weight 为权重加入节点，weight 不大于 0 时视为 1
+func (a *Atomic) RegisterWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	a.update(func(weights map[string]int) bool {
+		if weights[node] == weight {
+			return false
+		}
+		weights[node] = weight
+		return true
+	})
+}
+
+// Destroy 删除节点
+func (a *Atomic) Destroy(nodes ...string) {
+	a.update(func(weights map[string]int) bool {
+		changed := false
+		for _, node := range nodes {
+			if _, ok := weights[node]; ok {
+				delete(weights, node)
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// update 复制当前的节点列表交给 fn 修改，fn 返回 true 时生成新快照
+func (a *Atomic) update(fn func(weights map[string]int) bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cur := a.snapshot.Load().weights
+	next := make(map[string]int, len(cur)+1)
+	for node, weight := range cur {
+		next[node] = weight
+	}
+	if fn(next) {
+		a.store(next)
+	}
+}
+
+// store 按 weights 建一个新的 Placement 并发布，调用方持有 a.mu
+func (a *Atomic) store(weights map[string]int) {
+	a.snapshot.Store(&snapshot{placement: a.Build(weights), weights: weights})
+}
+
+// Build 用和快照相同的算法按 weights 建一个新的 Placement，但不发布它，
+// 用于预估节点变化的影响
+func (a *Atomic) Build(weights map[string]int) Placement {
+	cur := a.snapshot.Load()
+	if c, ok := cur.placement.(cloner); ok {
+		// Jump 这类依赖历史的算法在当前快照的基础上只修改变化的节点，
+		// 没有变化的节点保持原来的桶
+		p := c.clone()
+		for node := range cur.weights {
+			if _, ok := weights[node]; !ok {
+				p.Destroy(node)
+			}
+		}
+		for _, node := range sortedNodes(weights) {
+			if cur.weights[node] != weights[node] {
+				p.RegisterWeighted(node, weights[node])
+			}
+		}
+		return p
+	}
+	p := a.newPlacement()
+	if b, ok := p.(batchRegisterer); ok {
+		b.registerAll(weights)
+		return p
+	}
+	// 按名称顺序加入，结果和 weights 的遍历顺序无关
+	for _, node := range sortedNodes(weights) {
+		p.RegisterWeighted(node, weights[node])
+	}
+	return p
+}
+
+// Get 返回 key 的 owner
+func (a *Atomic) Get(key string) string {
+	return a.Snapshot().Get(key)
+}
+
+// GetN 按优先级返回 key 的 n 个不同节点
+func (a *Atomic) GetN(key string, n int) []string {
+	return a.Snapshot().GetN(key, n)
+}
+
+// Weight 返回节点的权重，节点不存在时返回 0
+func (a *Atomic) Weight(node string) int {
+	return a.snapshot.Load().weights[node]
+}
+
+// Nodes 返回所有节点，按名称排序
+func (a *Atomic) Nodes() []string {
+	return sortedNodes(a.snapshot.Load().weights)
+}
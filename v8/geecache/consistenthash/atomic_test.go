@@ -0,0 +1,114 @@
+package consistenthash
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestAtomic(t *testing.T) {
+	a := NewAtomic(func() Placement { return New(50, nil) })
+	if a.Get("key") != "" || len(a.Nodes()) != 0 {
+		t.Fatalf("empty Atomic should return nothing")
+	}
+	a.RegisterWeighted("a", 1)
+	a.RegisterWeighted("b", 2)
+	a.RegisterWeighted("c", 1)
+
+	expect := New(50, nil)
+	expect.RegisterWeighted("a", 1)
+	expect.RegisterWeighted("b", 2)
+	expect.RegisterWeighted("c", 1)
+	for i := 0; i < 1000; i++ {
+		key := "key-" + strconv.Itoa(i)
+		if a.Get(key) != expect.Get(key) {
+			t.Fatalf("Atomic should place %s like the ring it wraps", key)
+		}
+	}
+
+	// 旧快照不受之后修改的影响
+	old := a.Snapshot()
+	a.Destroy("b")
+	if old.Weight("b") != 2 || a.Weight("b") != 0 {
+		t.Fatalf("snapshot should be immutable, old weight %d new weight %d", old.Weight("b"), a.Weight("b"))
+	}
+	if !reflect.DeepEqual(a.Nodes(), []string{"a", "c"}) {
+		t.Fatalf("unexpected nodes %v", a.Nodes())
+	}
+
+	a.Set(map[string]int{"x": 1, "y": 3})
+	if !reflect.DeepEqual(a.Nodes(), []string{"x", "y"}) || a.Weight("y") != 3 {
+		t.Fatalf("Set should replace all nodes, got %v", a.Nodes())
+	}
+	// 没有变化时不生成新快照
+	cur := a.Snapshot()
+	a.RegisterWeighted("y", 3)
+	a.Destroy("unknown")
+	if a.Snapshot() != cur {
+		t.Fatalf("unchanged membership should keep the snapshot")
+	}
+}
+
+// TestAtomicConcurrent 在 -race 下检查并发的增删节点和查询
+func TestAtomicConcurrent(t *testing.T) {
+	// Maglev 使用小一些的查找表，-race 下重建查找表很慢
+	news := map[string]func() Placement{
+		"ring":       func() Placement { return New(50, nil) },
+		"rendezvous": func() Placement { return NewRendezvous(nil) },
+		"jump":       func() Placement { return NewJump(nil) },
+		"maglev":     func() Placement { return NewMaglev(1021, nil) },
+	}
+	for name, newFn := range news {
+		a := NewAtomic(newFn)
+		for i := 0; i < 5; i++ {
+			a.RegisterWeighted("node-"+strconv.Itoa(i), 1)
+		}
+
+		var writers, readers sync.WaitGroup
+		stop := make(chan struct{})
+		for w := 0; w < 2; w++ {
+			writers.Add(1)
+			go func(w int) {
+				defer writers.Done()
+				for i := 0; i < 20; i++ {
+					node := "extra-" + strconv.Itoa(w) + "-" + strconv.Itoa(i%5)
+					a.RegisterWeighted(node, 1+i%3)
+					a.Destroy(node)
+				}
+			}(w)
+		}
+		errs := make(chan string, 4)
+		for r := 0; r < 4; r++ {
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					key := "key-" + strconv.Itoa(i)
+					// 同一个快照上的查询结果是一致的
+					p := a.Snapshot()
+					nodes := p.GetN(key, 3)
+					if len(nodes) != 3 || nodes[0] != p.Get(key) || a.Get(key) == "" {
+						errs <- key
+						return
+					}
+				}
+			}()
+		}
+		writers.Wait()
+		close(stop)
+		readers.Wait()
+		close(errs)
+		for key := range errs {
+			t.Errorf("%s: inconsistent lookup of %s", name, key)
+		}
+		if !reflect.DeepEqual(a.Nodes(), []string{"node-0", "node-1", "node-2", "node-3", "node-4"}) {
+			t.Errorf("%s: unexpected nodes %v", name, a.Nodes())
+		}
+	}
+}
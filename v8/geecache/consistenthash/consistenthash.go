@@ -6,9 +6,6 @@ import (
 	"strconv"
 )
 
-// Hash maps bytes to uint32
-type Hash func(data []byte) uint32
-
 // Map 是一致性哈希算法的主数据结构
 // Map constains all hashed keys
 type Consistency struct {
@@ -16,6 +13,7 @@ type Consistency struct {
 	replicas int            //虚拟节点倍数 replicas
 	ring     []int          // uint32哈希环
 	hashMap  map[int]string //虚拟节点与真实节点的映射表 hashMap，键是虚拟节点的哈希值，值是真实节点的名称。
+	weights  map[string]int // 真实节点的权重，虚拟节点个数为 replicas*weight
 }
 
 // New creates a Map instance
@@ -24,6 +22,7 @@ func New(replicas int, fn Hash) *Consistency {
 		hash:     fn,
 		replicas: replicas,
 		hashMap:  make(map[int]string),
+		weights:  make(map[string]int),
 	}
 	if fn == nil {
 		m.hash = crc32.ChecksumIEEE
@@ -33,22 +32,51 @@ func New(replicas int, fn Hash) *Consistency {
 
 // Add adds some keys to the hash.
 // 添加真实节点/机器的 Add() 方法,注册更好些
-// Register 将各个peer注册到哈希环上
+// Register 将各个peer注册到哈希环上，权重都为 1
 func (m *Consistency) Register(keys ...string) {
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ { //分别求key对应的每个虚拟节点的，然后插入到哈希环上
-			hash := int(m.hash([]byte(strconv.Itoa(i) + key))) //求哈希值
-			m.ring = append(m.ring, hash)                      //把虚拟机节点 存入到 哈希环的 m.keys上
-			m.hashMap[hash] = key                              //虚拟节点对应的节点key是多少
-		}
+		m.addVirtualNodes(key, 1)
 	}
 	sort.Ints(m.ring)
 }
 
+// RegisterWeighted 以 weight 为权重把 key 注册到哈希环上，
+// key 分到的虚拟节点个数、也就是负责的 key 的比例和 weight 成正比。
+// weight 不大于 0 时视为 1，已经注册过的 key 会按新的权重重新注册
+func (m *Consistency) RegisterWeighted(key string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	if _, ok := m.weights[key]; ok {
+		m.Destroy(key)
+	}
+	m.addVirtualNodes(key, weight)
+	sort.Ints(m.ring)
+}
+
+// Weight 返回 key 的权重，key 没有注册时返回 0
+func (m *Consistency) Weight(key string) int {
+	return m.weights[key]
+}
+
+func (m *Consistency) addVirtualNodes(key string, weight int) {
+	m.weights[key] = weight
+	for i := 0; i < m.replicas*weight; i++ { //分别求key对应的每个虚拟节点的，然后插入到哈希环上
+		hash := int(m.hash([]byte(strconv.Itoa(i) + key))) //求哈希值
+		m.ring = append(m.ring, hash)                      //把虚拟机节点 存入到 哈希环的 m.keys上
+		m.hashMap[hash] = key                              //虚拟节点对应的节点key是多少
+	}
+}
+
 // Destroy 将各个peer注销到哈希环上
 func (m *Consistency) Destroy(keys ...string) {
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ { //分别求key对应的每个虚拟节点的，然后插入到哈希环上
+		weight, ok := m.weights[key]
+		if !ok {
+			continue
+		}
+		delete(m.weights, key)
+		for i := 0; i < m.replicas*weight; i++ { //分别求key对应的每个虚拟节点的，然后插入到哈希环上
 			hash := int(m.hash([]byte(strconv.Itoa(i) + key))) //求哈希值
 			index := sort.SearchInts(m.ring, hash)             //把虚拟机节点 存入到 哈希环的 m.keys上
 			if index < len(m.ring) && m.ring[index] == hash {
@@ -73,3 +101,35 @@ func (m *Consistency) Get(key string) string {
 	//如果 idx == len(m.keys)，说明应选择 m.keys[0]，因为 m.keys 是一个环状结构，所以用取余数的方式来处理这种情况。
 	return m.hashMap[m.ring[idx%len(m.ring)]]
 }
+
+// GetN 从 key 所在的位置开始顺时针查找，返回 n 个不同的真实节点，
+// 第一个就是 Get 返回的节点。真实节点不足 n 个时返回所有节点
+func (m *Consistency) GetN(key string, n int) []string {
+	if len(m.ring) == 0 || n <= 0 {
+		return nil
+	}
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.ring), func(i int) bool { return m.ring[i] >= hash })
+
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	// 最多绕环一圈
+	for i := 0; i < len(m.ring) && len(nodes) < n; i++ {
+		node := m.hashMap[m.ring[(idx+i)%len(m.ring)]]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// GetBounded 实现有界负载的一致性哈希，见 GetBounded 函数
+func (m *Consistency) GetBounded(key string, loads map[string]int64, epsilon float64) string {
+	return GetBounded(m, key, loads, epsilon)
+}
+
+// Nodes 返回所有真实节点，按名称排序
+func (m *Consistency) Nodes() []string {
+	return sortedNodes(m.weights)
+}
@@ -1,6 +1,8 @@
 package consistenthash
 
 import (
+	"math"
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -14,7 +16,7 @@ func TestConsistCache(t *testing.T) {
 	// Given the above hash function, this will give replicas with "hashes":
 	// 2, 4, 6, 12, 14, 16, 22, 24, 26
 	//哈希环分别对应的映射节点是 2 ：2 12 22 、  4：4 14 24    、 6：6 16 26
-	hash.Add("6", "4", "2")
+	hash.Register("6", "4", "2")
 
 	//模拟进来的值是否落入到正确的对应的虚拟节点 映射节点上
 	testCases := map[string]string{
@@ -32,7 +34,7 @@ func TestConsistCache(t *testing.T) {
 
 	//	更改一下，假如加入一个 8
 	// Adds 8, 18, 28
-	hash.Add("8")
+	hash.Register("8")
 
 	// 27 should now map to 8.
 	testCases["27"] = "8"
@@ -44,3 +46,103 @@ func TestConsistCache(t *testing.T) {
 	}
 
 }
+
+func TestGetN(t *testing.T) {
+	hash := New(3, func(data []byte) uint32 {
+		t, _ := strconv.Atoi(string(data))
+		return uint32(t)
+	})
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Register("6", "4", "2")
+
+	testCases := map[string][]string{
+		"2":  {"2", "4"},
+		"15": {"6", "2"},
+		"27": {"2", "4"},
+	}
+	for k, v := range testCases {
+		if got := hash.GetN(k, 2); !reflect.DeepEqual(got, v) {
+			t.Errorf("GetN(%s, 2) expect %v, got %v", k, v, got)
+		}
+	}
+
+	if got := hash.GetN("11", 5); !reflect.DeepEqual(got, []string{"2", "4", "6"}) {
+		t.Errorf("GetN should return all nodes when n is too large, got %v", got)
+	}
+	if got := hash.GetN("11", 1); !reflect.DeepEqual(got, []string{hash.Get("11")}) {
+		t.Errorf("GetN(key, 1) should equal Get, got %v", got)
+	}
+	if got := New(3, nil).GetN("11", 2); len(got) != 0 {
+		t.Errorf("empty ring should return nothing, got %v", got)
+	}
+}
+
+func TestRegisterWeighted(t *testing.T) {
+	hash := New(50, nil)
+	hash.RegisterWeighted("small", 1)
+	hash.RegisterWeighted("large", 8)
+	if hash.Weight("large") != 8 || len(hash.ring) != 50*9 {
+		t.Fatalf("expect 450 virtual nodes, got %d", len(hash.ring))
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 90000; i++ {
+		counts[hash.Get(strconv.Itoa(i))]++
+	}
+	// 大节点的权重是小节点的 8 倍，负责的 key 也应该接近 8 倍
+	if ratio := float64(counts["large"]) / float64(counts["small"]); ratio < 5 || ratio > 12 {
+		t.Fatalf("expect load ratio close to 8, got %.2f (%v)", ratio, counts)
+	}
+
+	// 修改权重后按新的权重重新注册
+	hash.RegisterWeighted("large", 2)
+	if hash.Weight("large") != 2 || len(hash.ring) != 50*3 {
+		t.Fatalf("expect 150 virtual nodes after reweight, got %d", len(hash.ring))
+	}
+	hash.Destroy("large", "unknown")
+	if hash.Weight("large") != 0 || len(hash.ring) != 50 || len(hash.hashMap) != 50 {
+		t.Fatalf("expect only small left, got %d virtual nodes", len(hash.ring))
+	}
+}
+
+func TestGetBounded(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Register("6", "4", "2")
+
+	if node := hash.GetBounded("11", nil, 0.25); node != "2" {
+		t.Fatalf("expect owner 2 without load, got %s", node)
+	}
+	// 上限为 ceil(1.25 * 3 / 3) = 2，节点 2 已经满了，溢出到顺时针的下一个节点 4
+	loads := map[string]int64{"2": 2}
+	if node := hash.GetBounded("11", loads, 0.25); node != "4" {
+		t.Fatalf("expect spill to 4, got %s", node)
+	}
+	// 上限为 ceil(1.25 * 7 / 3) = 3，节点 2 和 4 都满了
+	loads = map[string]int64{"2": 3, "4": 3}
+	if node := hash.GetBounded("11", loads, 0.25); node != "6" {
+		t.Fatalf("expect spill to 6, got %s", node)
+	}
+}
+
+func TestGetBoundedMaxLoad(t *testing.T) {
+	hash := New(50, nil)
+	nodes := []string{"a", "b", "c", "d", "e"}
+	hash.Register(nodes...)
+
+	// 所有请求都落在同一个 key 上，模拟一个热点 key
+	const epsilon, requests = 0.25, 1000
+	loads := make(map[string]int64)
+	for i := 0; i < requests; i++ {
+		loads[hash.GetBounded("hot", loads, epsilon)]++
+	}
+	limit := int64(math.Ceil((1 + epsilon) * requests / float64(len(nodes))))
+	for _, node := range nodes {
+		if loads[node] > limit {
+			t.Fatalf("node %s has %d in-flight requests, limit %d", node, loads[node], limit)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package consistenthash
+
+import (
+	"sort"
+	"strconv"
+)
+
+// hashSpace 是 32 位哈希空间的大小
+const hashSpace = 1 << 32
+
+// defaultDiffSamples 是 Diff 对非哈希环的 Placement 采样的 key 的个数
+const defaultDiffSamples = 100000
+
+// Move 表示有 Fraction 比例的哈希空间从节点 From 移动到节点 To。
+// From 为空表示这部分原来没有节点负责，To 为空表示之后没有节点负责
+type Move struct {
+	From     string  `json:"from"`
+	To       string  `json:"to"`
+	Fraction float64 `json:"fraction"`
+}
+
+// Diff 比较 a 和 b，返回每一对节点之间改变归属的哈希空间比例，按比例从大到小排序。
+// 两个都是哈希环时使用 DiffRings 精确计算，否则使用 DiffSampled 采样估算
+func Diff(a, b Placement) []Move {
+	ra, okA := a.(*Consistency)
+	rb, okB := b.(*Consistency)
+	if okA && okB {
+		return DiffRings(ra, rb)
+	}
+	return DiffSampled(a, b, defaultDiffSamples)
+}
+
+// DiffRings 精确计算两个哈希环之间改变归属的哈希空间。
+// 把两个环上的虚拟节点合在一起，相邻两点之间的区间在两个环上各自只属于一个节点，
+// 逐个区间比较即可。两个环必须使用相同的哈希函数
+func DiffRings(a, b *Consistency) []Move {
+	points := make([]int, 0, len(a.ring)+len(b.ring))
+	points = append(points, a.ring...)
+	points = append(points, b.ring...)
+	if len(points) == 0 {
+		return nil
+	}
+	sort.Ints(points)
+
+	moved := make(map[[2]string]int64)
+	prev := points[len(points)-1] - hashSpace // 第一个区间从最后一个点绕回来
+	for i, p := range points {
+		if i > 0 && p == prev {
+			continue
+		}
+		// 区间 (prev, p] 中的哈希值都属于 p 所在的节点
+		from, to := a.ownerOfHash(p), b.ownerOfHash(p)
+		if from != to {
+			moved[[2]string{from, to}] += int64(p - prev)
+		}
+		prev = p
+	}
+
+	moves := make([]Move, 0, len(moved))
+	for pair, n := range moved {
+		moves = append(moves, Move{From: pair[0], To: pair[1], Fraction: float64(n) / hashSpace})
+	}
+	sortMoves(moves)
+	return moves
+}
+
+// ownerOfHash 返回负责哈希值 hash 的节点
+func (m *Consistency) ownerOfHash(hash int) string {
+	if len(m.ring) == 0 {
+		return ""
+	}
+	idx := sort.SearchInts(m.ring, hash)
+	return m.hashMap[m.ring[idx%len(m.ring)]]
+}
+
+// DiffSampled 用 samples 个 key 估算 a 和 b 之间改变归属的 key 的比例，
+// 适用于任意的 Placement
+func DiffSampled(a, b Placement, samples int) []Move {
+	if samples <= 0 {
+		return nil
+	}
+	moved := make(map[[2]string]int)
+	for i := 0; i < samples; i++ {
+		key := "diff-sample-" + strconv.Itoa(i)
+		if from, to := a.Get(key), b.Get(key); from != to {
+			moved[[2]string{from, to}]++
+		}
+	}
+	moves := make([]Move, 0, len(moved))
+	for pair, n := range moved {
+		moves = append(moves, Move{From: pair[0], To: pair[1], Fraction: float64(n) / float64(samples)})
+	}
+	sortMoves(moves)
+	return moves
+}
+
+// MovedFraction 返回 moves 中所有改变归属的比例之和
+func MovedFraction(moves []Move) float64 {
+	total := 0.0
+	for _, m := range moves {
+		total += m.Fraction
+	}
+	return total
+}
+
+func sortMoves(moves []Move) {
+	sort.Slice(moves, func(i, j int) bool {
+		if moves[i].Fraction != moves[j].Fraction {
+			return moves[i].Fraction > moves[j].Fraction
+		}
+		if moves[i].From != moves[j].From {
+			return moves[i].From < moves[j].From
+		}
+		return moves[i].To < moves[j].To
+	})
+}
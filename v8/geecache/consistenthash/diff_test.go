@@ -0,0 +1,77 @@
+package consistenthash
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestDiffRings(t *testing.T) {
+	numeric := func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	}
+	a := New(3, numeric)
+	a.Register("6", "4", "2") // 2, 4, 6, 12, 14, 16, 22, 24, 26
+	b := New(3, numeric)
+	b.Register("8", "6", "4", "2") // 多了 8, 18, 28
+
+	if moves := DiffRings(a, a); len(moves) != 0 {
+		t.Fatalf("identical rings should not move, got %v", moves)
+	}
+	// (6,8]、(16,18]、(26,28] 原来属于 2，现在属于 8
+	expect := []Move{{From: "2", To: "8", Fraction: 6.0 / hashSpace}}
+	if moves := DiffRings(a, b); !reflect.DeepEqual(moves, expect) {
+		t.Fatalf("expect %v, got %v", expect, moves)
+	}
+	// 反过来就是 8 的部分回到 2
+	expect = []Move{{From: "8", To: "2", Fraction: 6.0 / hashSpace}}
+	if moves := DiffRings(b, a); !reflect.DeepEqual(moves, expect) {
+		t.Fatalf("expect %v, got %v", expect, moves)
+	}
+
+	// 从空环开始时整个哈希空间都是新分配的
+	moves := DiffRings(New(3, numeric), a)
+	if math.Abs(MovedFraction(moves)-1) > 1e-9 || moves[0].From != "" {
+		t.Fatalf("expect the whole space to move from nobody, got %v", moves)
+	}
+}
+
+func TestDiffMatchesSampling(t *testing.T) {
+	a := newPlacement(func() Placement { return New(50, nil) }, placementNodes)
+	b := newPlacement(func() Placement { return New(50, nil) }, placementNodes)
+	b.RegisterWeighted("node-new", 1)
+	b.Destroy("node-3")
+
+	exact := Diff(a, b)
+	sampled := DiffSampled(a, b, defaultDiffSamples)
+	if math.Abs(MovedFraction(exact)-MovedFraction(sampled)) > 0.01 {
+		t.Fatalf("exact %.4f and sampled %.4f should agree", MovedFraction(exact), MovedFraction(sampled))
+	}
+	// 所有的移动要么来自 node-3，要么去往 node-new
+	for _, m := range exact {
+		if m.From != "node-3" && m.To != "node-new" {
+			t.Fatalf("unexpected move %+v", m)
+		}
+	}
+	for i := 1; i < len(exact); i++ {
+		if exact[i].Fraction > exact[i-1].Fraction {
+			t.Fatalf("moves should be sorted by fraction, got %v", exact)
+		}
+	}
+}
+
+func TestDiffPlacements(t *testing.T) {
+	for name, c := range placements {
+		a := newPlacement(c.new, placementNodes)
+		b := newPlacement(c.new, placementNodes)
+		b.RegisterWeighted("node-new", 1)
+		moves := Diff(a, b)
+		total := MovedFraction(moves)
+		t.Logf("%s: adding a node moves %.3f", name, total)
+		if math.Abs(total-1.0/11) > 0.03 {
+			t.Errorf("%s: expect about 1/11 to move, got %.3f", name, total)
+		}
+	}
+}
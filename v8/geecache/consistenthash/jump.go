@@ -0,0 +1,165 @@
+package consistenthash
+
+import "hash/crc32"
+
+// Jump 实现 jump consistent hash（Lamping & Veach）。
+// 它把 key 映射到 [0, n) 中的一个桶，不需要额外的内存，分布也非常均匀，
+// 但只能在桶的末尾增加：桶数从 n 变成 n+1 时只有 1/(n+1) 的 key 移动到新桶。
+//
+// 每个节点占用的桶一旦分配就不再改变，权重为 w 的节点占用 w 个桶。
+// 新节点优先占用已删除节点留下的空桶，没有空桶时追加在末尾；
+// 删除节点时只把它的桶标记为空（末尾的空桶直接去掉），落在空桶上的 key 换一个哈希值重新选桶，
+// 所以增删节点时只有新节点和被删除节点的 key 会移动。
+//
+// 桶的分配取决于节点加入和删除的顺序，同一批加入的节点按名称顺序分配。
+// 通过 Atomic 使用时每次重建都在上一个快照的基础上修改，桶的分配保持不变，
+// 但是以不同顺序观察到节点变化的两个进程可能得到不同的分配，
+// 所以 Jump 适合节点列表固定、或者所有节点以相同顺序变化的集群
+type Jump struct {
+	hash    Hash
+	buckets []string // 第 i 个桶对应的节点，空字符串表示已删除节点留下的空桶
+	weights map[string]int
+}
+
+// NewJump creates a Jump instance, fn 为空时使用 crc32
+func NewJump(fn Hash) *Jump {
+	j := &Jump{
+		hash:    fn,
+		weights: make(map[string]int),
+	}
+	if fn == nil {
+		j.hash = crc32.ChecksumIEEE
+	}
+	return j
+}
+
+// RegisterWeighted 以 weight 为权重加入节点，weight 不大于 0 时视为 1。
+// 已经存在的节点保留原来的桶，权重变大时再分配新的桶，变小时释放最后分配的桶
+func (j *Jump) RegisterWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	old := j.weights[node]
+	j.weights[node] = weight
+	for i := len(j.buckets) - 1; i >= 0 && old > weight; i-- {
+		if j.buckets[i] == node {
+			j.buckets[i] = ""
+			old--
+		}
+	}
+	for i := 0; i < len(j.buckets) && old < weight; i++ {
+		if j.buckets[i] == "" {
+			j.buckets[i] = node
+			old++
+		}
+	}
+	for ; old < weight; old++ {
+		j.buckets = append(j.buckets, node)
+	}
+	j.trim()
+}
+
+// Destroy 删除节点，节点占用的桶变成空桶，其他节点的桶不变
+func (j *Jump) Destroy(nodes ...string) {
+	for _, node := range nodes {
+		if _, ok := j.weights[node]; !ok {
+			continue
+		}
+		delete(j.weights, node)
+		for i, b := range j.buckets {
+			if b == node {
+				j.buckets[i] = ""
+			}
+		}
+	}
+	j.trim()
+}
+
+// trim 去掉末尾的空桶，桶数减少时只有落在被删除的桶上的 key 移动，
+// 删除最后加入的节点会让其他 key 回到加入之前的桶
+func (j *Jump) trim() {
+	n := len(j.buckets)
+	for n > 0 && j.buckets[n-1] == "" {
+		n--
+	}
+	j.buckets = j.buckets[:n]
+}
+
+// clone 返回一个桶分配完全相同的副本
+func (j *Jump) clone() Placement {
+	c := &Jump{hash: j.hash, buckets: append([]string(nil), j.buckets...), weights: make(map[string]int, len(j.weights))}
+	for node, weight := range j.weights {
+		c.weights[node] = weight
+	}
+	return c
+}
+
+// bucket 返回 hash 对应的非空桶中的节点，落在空桶上时用下一个哈希值重新选桶。
+// 调用方保证至少有一个节点
+func (j *Jump) bucket(hash uint64) string {
+	for {
+		hash = mix64(hash)
+		if node := j.buckets[jumpHash(hash, len(j.buckets))]; node != "" {
+			return node
+		}
+	}
+}
+
+// jumpHash 是论文中的算法，返回 key 所在的桶
+func jumpHash(key uint64, buckets int) int {
+	var b, i int64 = -1, 0
+	for i < int64(buckets) {
+		b = i
+		key = key*2862933555777941757 + 1
+		i = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Get 返回 key 所在桶的节点
+func (j *Jump) Get(key string) string {
+	if len(j.weights) == 0 {
+		return ""
+	}
+	return j.bucket(uint64(j.hash([]byte(key))))
+}
+
+// GetN 依次用 key 的不同变体计算桶，跳过已经选中的节点。
+// 变体的个数有上限，剩下的节点按名称顺序补齐
+func (j *Jump) GetN(key string, n int) []string {
+	if len(j.weights) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(j.weights) {
+		n = len(j.weights)
+	}
+	keyHash := uint64(j.hash([]byte(key)))
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i := uint64(0); i < uint64(4*len(j.buckets)) && len(nodes) < n; i++ {
+		node := j.bucket(keyHash + i)
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	for _, node := range sortedNodes(j.weights) {
+		if len(nodes) == n {
+			break
+		}
+		if !seen[node] {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// Weight 返回节点的权重，节点不存在时返回 0
+func (j *Jump) Weight(node string) int {
+	return j.weights[node]
+}
+
+// Nodes 返回所有节点，按名称排序
+func (j *Jump) Nodes() []string {
+	return sortedNodes(j.weights)
+}
@@ -0,0 +1,153 @@
+package consistenthash
+
+import (
+	"fmt"
+	"hash/crc32"
+)
+
+// defaultMaglevSize 是查找表的默认大小，需要是质数并且远大于节点数
+const defaultMaglevSize = 65537
+
+// Maglev 实现 Google Maglev 负载均衡器中的一致性哈希。
+// 每个节点按自己的排列依次抢占查找表中的空位，填满后 key 直接按哈希值查表，
+// 查找是 O(1) 的，各节点分到的位置数几乎完全相同。
+// 增删节点时需要重建查找表，移动的 key 比哈希环略多
+type Maglev struct {
+	hash    Hash
+	size    int // 查找表大小，质数
+	weights map[string]int
+	table   []string // 查找表，每个位置对应一个节点
+}
+
+// NewMaglev creates a Maglev instance, size 是查找表大小，必须是质数，
+// 不大于 0 时使用 65537。fn 为空时使用 crc32。
+// size 不是质数时 skip 可能和 size 有公因数，节点的排列覆盖不了整个查找表，所以直接 panic
+func NewMaglev(size int, fn Hash) *Maglev {
+	if size <= 0 {
+		size = defaultMaglevSize
+	}
+	if !isPrime(size) {
+		panic(fmt.Sprintf("maglev table size %d is not a prime", size))
+	}
+	m := &Maglev{
+		hash:    fn,
+		size:    size,
+		weights: make(map[string]int),
+	}
+	if fn == nil {
+		m.hash = crc32.ChecksumIEEE
+	}
+	return m
+}
+
+// RegisterWeighted 以 weight 为权重加入节点并重建查找表，weight 不大于 0 时视为 1
+func (m *Maglev) RegisterWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	m.weights[node] = weight
+	m.populate()
+}
+
+// registerAll 加入 weights 中的所有节点，只重建一次查找表
+func (m *Maglev) registerAll(weights map[string]int) {
+	for node, weight := range weights {
+		m.weights[node] = max(weight, 1)
+	}
+	m.populate()
+}
+
+// Destroy 删除节点并重建查找表
+func (m *Maglev) Destroy(nodes ...string) {
+	for _, node := range nodes {
+		delete(m.weights, node)
+	}
+	m.populate()
+}
+
+// populate 按论文中的算法填充查找表：每一轮中每个节点按自己的排列
+// offset, offset+skip, offset+2*skip, ... 找到第一个空位占下来，
+// 权重为 w 的节点每轮占 w 个位置
+func (m *Maglev) populate() {
+	if len(m.weights) == 0 {
+		m.table = nil
+		return
+	}
+	nodes := sortedNodes(m.weights) // 固定顺序，保证每个节点算出的查找表相同
+	size := uint64(m.size)
+	offsets := make([]uint64, len(nodes))
+	skips := make([]uint64, len(nodes))
+	next := make([]uint64, len(nodes))
+	for i, node := range nodes {
+		h := mix64(uint64(m.hash([]byte(node))))
+		offsets[i] = (h >> 32) % size
+		skips[i] = (h&0xffffffff)%(size-1) + 1
+	}
+
+	table := make([]string, m.size)
+	filled := 0
+	for filled < m.size {
+		for i, node := range nodes {
+			for w := 0; w < m.weights[node] && filled < m.size; w++ {
+				for {
+					c := (offsets[i] + next[i]*skips[i]) % size
+					next[i]++
+					if table[c] == "" {
+						table[c] = node
+						filled++
+						break
+					}
+				}
+			}
+		}
+	}
+	m.table = table
+}
+
+// Get 返回 key 在查找表中对应的节点
+func (m *Maglev) Get(key string) string {
+	if len(m.table) == 0 {
+		return ""
+	}
+	return m.table[mix64(uint64(m.hash([]byte(key))))%uint64(m.size)]
+}
+
+// GetN 从 key 在查找表中的位置开始向后查找，返回 n 个不同的节点
+func (m *Maglev) GetN(key string, n int) []string {
+	if len(m.table) == 0 || n <= 0 {
+		return nil
+	}
+	idx := int(mix64(uint64(m.hash([]byte(key)))) % uint64(m.size))
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i := 0; i < m.size && len(nodes) < n; i++ {
+		node := m.table[(idx+i)%m.size]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// Weight 返回节点的权重，节点不存在时返回 0
+func (m *Maglev) Weight(node string) int {
+	return m.weights[node]
+}
+
+// Nodes 返回所有节点，按名称排序
+func (m *Maglev) Nodes() []string {
+	return sortedNodes(m.weights)
+}
+
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for i := 2; i*i <= n; i++ {
+		if n%i == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,24 @@
+package consistenthash
+
+import "testing"
+
+func TestMaglevSize(t *testing.T) {
+	for _, size := range []int{1, 4, 65536} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("size %d is not a prime and should panic", size)
+				}
+			}()
+			NewMaglev(size, nil)
+		}()
+	}
+	for _, size := range []int{0, 2, 1021} {
+		m := NewMaglev(size, nil)
+		m.RegisterWeighted("a", 1)
+		m.RegisterWeighted("b", 1)
+		if m.Get("key") == "" || len(m.table) != m.size {
+			t.Errorf("size %d: expect a full table", size)
+		}
+	}
+}
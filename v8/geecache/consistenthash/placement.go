@@ -0,0 +1,91 @@
+package consistenthash
+
+import (
+	"math"
+	"sort"
+)
+
+// Hash maps bytes to uint32
+type Hash func(data []byte) uint32
+
+// Placement 决定每个 key 由哪些节点负责。
+// Consistency（哈希环）、Rendezvous、Jump 和 Maglev 都实现了这个接口，
+// 它们都不是并发安全的，由调用方加锁，或者用 Atomic 包装
+type Placement interface {
+	// RegisterWeighted 以 weight 为权重加入节点，已经存在的节点按新的权重重新加入
+	RegisterWeighted(node string, weight int)
+	// Destroy 删除节点，不存在的节点会被忽略
+	Destroy(nodes ...string)
+	// Get 返回 key 的 owner，没有节点时返回空字符串
+	Get(key string) string
+	// GetN 按优先级返回 key 的 n 个不同节点，第一个就是 Get 返回的节点
+	GetN(key string, n int) []string
+	// Weight 返回节点的权重，节点不存在时返回 0
+	Weight(node string) int
+	// Nodes 返回所有节点，按名称排序
+	Nodes() []string
+}
+
+var (
+	_ Placement = (*Consistency)(nil)
+	_ Placement = (*Rendezvous)(nil)
+	_ Placement = (*Jump)(nil)
+	_ Placement = (*Maglev)(nil)
+)
+
+// batchRegisterer 是可以一次加入多个节点的 Placement，
+// 像 Maglev 这种每次修改都要重建查找表的实现，一次加入所有节点只需要重建一次
+type batchRegisterer interface {
+	registerAll(weights map[string]int)
+}
+
+// cloner 是结果依赖节点加入和删除历史的 Placement，
+// Atomic 在上一个快照的副本上增删节点，而不是从空的 Placement 重新建
+type cloner interface {
+	clone() Placement
+}
+
+// GetBounded 实现有界负载的一致性哈希（Consistent Hashing with Bounded Loads）。
+// loads 是每个节点正在处理的请求数，每个节点的容量上限为
+// ceil((1+epsilon) * (总请求数+1) * 权重 / 总权重)，
+// 按 key 的节点优先级依次查找，返回第一个还没有达到上限的节点。
+// 上限按加入这次请求之后的总数计算，所以总能找到一个节点
+func GetBounded(p Placement, key string, loads map[string]int64, epsilon float64) string {
+	nodes := p.Nodes()
+	if len(nodes) == 0 {
+		return ""
+	}
+	var total int64
+	totalWeight := 0
+	for _, node := range nodes {
+		total += loads[node]
+		totalWeight += p.Weight(node)
+	}
+	candidates := p.GetN(key, len(nodes))
+	for _, node := range candidates {
+		limit := math.Ceil((1 + epsilon) * float64(total+1) * float64(p.Weight(node)) / float64(totalWeight))
+		if float64(loads[node]+1) <= limit {
+			return node
+		}
+	}
+	// epsilon 为负数时可能所有节点都超出上限，退化为不限制负载
+	return candidates[0]
+}
+
+// mix64 是 splitmix64 的混淆函数，把相近的输入打散成均匀分布的 64 位整数。
+// crc32 是线性的，直接拿来比较或取模时不同节点的结果之间有很强的相关性
+func mix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+func sortedNodes(weights map[string]int) []string {
+	nodes := make([]string, 0, len(weights))
+	for node := range weights {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
@@ -0,0 +1,213 @@
+package consistenthash
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// placements 是所有需要测试的 Placement 实现
+var placements = map[string]struct {
+	new func() Placement
+	// 分布均匀程度和 key 移动比例的上限，测试会打印实际的值便于比较
+	maxDeviation float64 // 节点分到的 key 数与平均值的最大偏差比例
+	maxAddMove   float64 // 加入第 11 个节点时移动的 key 的比例，理想值是 1/11
+	maxRemove    float64 // 删除 10 个节点中的一个时移动的 key 的比例，理想值是 1/10
+}{
+	"ring":       {func() Placement { return New(50, nil) }, 0.35, 0.15, 0.15},
+	"rendezvous": {func() Placement { return NewRendezvous(nil) }, 0.05, 0.1, 0.11},
+	"jump":       {func() Placement { return NewJump(nil) }, 0.05, 0.1, 0.11},
+	"maglev":     {func() Placement { return NewMaglev(0, nil) }, 0.05, 0.12, 0.13},
+}
+
+const (
+	placementNodes = 10
+	placementKeys  = 100000
+)
+
+func newPlacement(newFn func() Placement, nodes int) Placement {
+	p := newFn()
+	for i := 0; i < nodes; i++ {
+		p.RegisterWeighted("node-"+strconv.Itoa(i), 1)
+	}
+	return p
+}
+
+// owners 返回每个 key 的 owner
+func owners(p Placement) []string {
+	result := make([]string, placementKeys)
+	for i := range result {
+		result[i] = p.Get("key-" + strconv.Itoa(i))
+	}
+	return result
+}
+
+// moved 返回 owner 发生变化的 key 的比例
+func moved(before, after []string) float64 {
+	n := 0
+	for i := range before {
+		if before[i] != after[i] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(before))
+}
+
+func TestPlacementUniformity(t *testing.T) {
+	for name, c := range placements {
+		p := newPlacement(c.new, placementNodes)
+		counts := make(map[string]int)
+		for _, owner := range owners(p) {
+			counts[owner]++
+		}
+		avg := float64(placementKeys) / placementNodes
+		deviation := 0.0
+		for _, node := range p.Nodes() {
+			deviation = math.Max(deviation, math.Abs(float64(counts[node])-avg)/avg)
+		}
+		t.Logf("%s: max deviation from average %.3f", name, deviation)
+		if len(counts) != placementNodes || deviation > c.maxDeviation {
+			t.Errorf("%s: expect deviation <= %.2f, got %.3f, counts %v", name, c.maxDeviation, deviation, counts)
+		}
+	}
+}
+
+func TestPlacementMovement(t *testing.T) {
+	for name, c := range placements {
+		p := newPlacement(c.new, placementNodes)
+		before := owners(p)
+
+		p.RegisterWeighted("node-new", 1)
+		added := owners(p)
+		addMove := moved(before, added)
+		// 加入节点时只应该有 key 移动到新节点上
+		wrong := 0
+		for i := range before {
+			if before[i] != added[i] && added[i] != "node-new" {
+				wrong++
+			}
+		}
+
+		p.Destroy("node-new")
+		if back := moved(before, owners(p)); back != 0 {
+			t.Errorf("%s: removing the added node should restore all owners, %.3f moved", name, back)
+		}
+
+		p.Destroy("node-3")
+		removeMove := moved(before, owners(p))
+		t.Logf("%s: add moved %.3f (%d not to the new node), remove moved %.3f", name, addMove, wrong, removeMove)
+		// Maglev 重建查找表时会有少量 key 在旧节点之间移动
+		if wrong > placementKeys/100 {
+			t.Errorf("%s: %d keys moved between old nodes", name, wrong)
+		}
+		if addMove > c.maxAddMove || removeMove > c.maxRemove {
+			t.Errorf("%s: expect add <= %.2f and remove <= %.2f, got %.3f and %.3f",
+				name, c.maxAddMove, c.maxRemove, addMove, removeMove)
+		}
+	}
+}
+
+// TestPlacementAtomicMovement 通过 Atomic 重建快照，新节点按名称排在中间，
+// 不依赖新节点加在最后
+func TestPlacementAtomicMovement(t *testing.T) {
+	for name, c := range placements {
+		a := NewAtomic(c.new)
+		for i := 0; i < placementNodes; i++ {
+			a.RegisterWeighted("node-"+strconv.Itoa(i*10), 1)
+		}
+		before := owners(a)
+
+		a.RegisterWeighted("node-35", 1)
+		added := owners(a)
+		wrong := 0
+		for i := range before {
+			if before[i] != added[i] && added[i] != "node-35" {
+				wrong++
+			}
+		}
+		addMove := moved(before, added)
+
+		a.Destroy("node-35")
+		back := moved(before, owners(a))
+		t.Logf("%s: add moved %.3f (%d not to the new node), remove moved back %.3f", name, addMove, wrong, back)
+		if wrong > placementKeys/100 || addMove > c.maxAddMove {
+			t.Errorf("%s: expect add <= %.2f with few keys between old nodes, got %.3f and %d",
+				name, c.maxAddMove, addMove, wrong)
+		}
+		if back > 0.01 {
+			t.Errorf("%s: removing the added node should restore owners, %.3f moved", name, back)
+		}
+	}
+}
+
+func TestPlacementWeighted(t *testing.T) {
+	for name, c := range placements {
+		p := c.new()
+		p.RegisterWeighted("small", 1)
+		p.RegisterWeighted("large", 3)
+		counts := make(map[string]int)
+		for _, owner := range owners(p) {
+			counts[owner]++
+		}
+		share := float64(counts["large"]) / placementKeys
+		t.Logf("%s: weight 3 of 4 got share %.3f", name, share)
+		if math.Abs(share-0.75) > 0.05 {
+			t.Errorf("%s: expect share of large node about 0.75, got %.3f", name, share)
+		}
+		if p.Weight("large") != 3 || p.Weight("unknown") != 0 {
+			t.Errorf("%s: unexpected weights", name)
+		}
+	}
+}
+
+func TestPlacementGetN(t *testing.T) {
+	for name, c := range placements {
+		p := c.new()
+		if p.Get("key") != "" || p.GetN("key", 2) != nil {
+			t.Errorf("%s: empty placement should return nothing", name)
+		}
+		p = newPlacement(c.new, 5)
+		for i := 0; i < 100; i++ {
+			key := "key-" + strconv.Itoa(i)
+			nodes := p.GetN(key, 3)
+			seen := make(map[string]bool)
+			for _, node := range nodes {
+				seen[node] = true
+			}
+			if len(nodes) != 3 || len(seen) != 3 || nodes[0] != p.Get(key) {
+				t.Fatalf("%s: GetN(%s) should return 3 distinct nodes starting with the owner, got %v", name, key, nodes)
+			}
+		}
+		if nodes := p.GetN("key", 10); len(nodes) != 5 {
+			t.Errorf("%s: expect all 5 nodes, got %v", name, nodes)
+		}
+	}
+}
+
+func TestPlacementBounded(t *testing.T) {
+	for name, c := range placements {
+		p := newPlacement(c.new, 5)
+		const epsilon, requests = 0.25, 1000
+		loads := make(map[string]int64)
+		for i := 0; i < requests; i++ {
+			loads[GetBounded(p, "hot", loads, epsilon)]++
+		}
+		limit := int64(math.Ceil((1 + epsilon) * requests / 5))
+		for _, node := range p.Nodes() {
+			if loads[node] > limit {
+				t.Errorf("%s: node %s has %d in-flight requests, limit %d", name, node, loads[node], limit)
+			}
+		}
+	}
+}
+
+func BenchmarkPlacementGet(b *testing.B) {
+	for name, c := range placements {
+		p := newPlacement(c.new, placementNodes)
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p.Get("key-" + strconv.Itoa(i))
+			}
+		})
+	}
+}
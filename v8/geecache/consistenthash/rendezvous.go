@@ -0,0 +1,97 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"math"
+	"sort"
+)
+
+// Rendezvous 实现 rendezvous hashing（最高随机权重，HRW）。
+// 每个 key 对每个节点算一个分数，分数最高的节点就是 owner。
+// 增删节点时只有分数最高的节点发生变化的 key 会移动，不需要虚拟节点，
+// 代价是每次查找都要计算所有节点的分数
+type Rendezvous struct {
+	hash    Hash
+	weights map[string]int
+	hashes  map[string]uint64 // 节点名称的哈希值，避免每次查找都重新计算
+}
+
+// NewRendezvous creates a Rendezvous instance, fn 为空时使用 crc32
+func NewRendezvous(fn Hash) *Rendezvous {
+	r := &Rendezvous{
+		hash:    fn,
+		weights: make(map[string]int),
+		hashes:  make(map[string]uint64),
+	}
+	if fn == nil {
+		r.hash = crc32.ChecksumIEEE
+	}
+	return r
+}
+
+// RegisterWeighted 以 weight 为权重加入节点，weight 不大于 0 时视为 1
+func (r *Rendezvous) RegisterWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	r.weights[node] = weight
+	r.hashes[node] = mix64(uint64(r.hash([]byte(node))))
+}
+
+// Destroy 删除节点
+func (r *Rendezvous) Destroy(nodes ...string) {
+	for _, node := range nodes {
+		delete(r.weights, node)
+		delete(r.hashes, node)
+	}
+}
+
+// score 使用 weighted rendezvous hashing 的公式 -weight/ln(u)，
+// u 是 (0,1) 上均匀分布的随机数，这样每个节点成为 owner 的概率和权重成正比
+func (r *Rendezvous) score(node string, keyHash uint64) float64 {
+	h := mix64(keyHash ^ r.hashes[node])
+	u := (float64(h>>11) + 0.5) / (1 << 53)
+	return -float64(r.weights[node]) / math.Log(u)
+}
+
+// Get 返回分数最高的节点
+func (r *Rendezvous) Get(key string) string {
+	keyHash := uint64(r.hash([]byte(key)))
+	best, bestScore := "", 0.0
+	for node := range r.weights {
+		score := r.score(node, keyHash)
+		// 分数相同时按名称比较，保证结果和 map 的遍历顺序无关
+		if best == "" || score > bestScore || (score == bestScore && node < best) {
+			best, bestScore = node, score
+		}
+	}
+	return best
+}
+
+// GetN 按分数从高到低返回 n 个节点
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if len(r.weights) == 0 || n <= 0 {
+		return nil
+	}
+	keyHash := uint64(r.hash([]byte(key)))
+	nodes := sortedNodes(r.weights)
+	scores := make(map[string]float64, len(nodes))
+	for _, node := range nodes {
+		scores[node] = r.score(node, keyHash)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return scores[nodes[i]] > scores[nodes[j]] })
+	if n < len(nodes) {
+		nodes = nodes[:n]
+	}
+	return nodes
+}
+
+// Weight 返回节点的权重，节点不存在时返回 0
+func (r *Rendezvous) Weight(node string) int {
+	return r.weights[node]
+}
+
+// Nodes 返回所有节点，按名称排序
+func (r *Rendezvous) Nodes() []string {
+	return sortedNodes(r.weights)
+}
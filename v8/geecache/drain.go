@@ -0,0 +1,66 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// defaultDrainDelay 是 Drain 注销之后等待其他节点观察到本节点下线的默认时间
+const defaultDrainDelay = 2 * time.Second
+
+// Drain 让节点平滑下线，依次：
+//  1. 从 discovery 注销本节点，并从本节点的哈希环上删除自己，之后本节点的请求也转发给新的 owner
+//  2. 等待 drainDelay，让其他节点观察到本节点下线，不再把请求发过来
+//  3. 把缓存中的 key 迁移给新的 owner，按淘汰策略的顺序发送，热点 key 最先发送
+//  4. GracefulStop grpc 服务，等待正在处理的请求结束
+//  5. 停止服务发现和后台协程，关闭所有客户端
+//
+// ctx 的 deadline 限制整个过程，超时后跳过剩余的迁移并强制停止 grpc 服务，返回 ctx 的错误。
+// server 没有运行时返回错误
+func (s *Server) Drain(ctx context.Context) error {
+	s.mux.Lock()
+	if !s.status || s.draining {
+		s.mux.Unlock()
+		return fmt.Errorf("server %s is not running", s.addr)
+	}
+	s.draining = true
+	grpcServer := s.grpcServer
+	s.mux.Unlock()
+
+	log.Printf("[geecache_svr %s] draining", s.addr)
+	s.unregister()
+	s.placement.Destroy(s.addr)
+	if s.zoneRing != nil {
+		s.zoneRing.Destroy(s.addr)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(s.drainDelay):
+	}
+	if ctx.Err() == nil {
+		s.rebalance(ctx)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Printf("[geecache_svr %s] drain deadline exceeded, stop grpc server", s.addr)
+		grpcServer.Stop()
+		<-stopped
+	}
+
+	s.mux.Lock()
+	s.status = false
+	s.mux.Unlock()
+	s.shutdown()
+	log.Printf("[geecache_svr %s] drained", s.addr)
+	return ctx.Err()
+}
@@ -0,0 +1,175 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+	"v8/geecache/geecachepb"
+	"v8/geecache/registry"
+)
+
+// newListenServer 创建一个在随机端口上监听、使用静态服务发现的 Server
+func newListenServer(t *testing.T, opts ...ServerOption) *Server {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]ServerOption{WithListener(lis), WithDiscovery(registry.NewStatic())}, opts...)
+	svr, err := NewServer("", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svr
+}
+
+// serveServer 在后台运行 svr.Serve，等到开始接受请求后返回，channel 中是 Serve 的返回值
+func serveServer(t *testing.T, svr *Server) <-chan error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- svr.Serve() }()
+	select {
+	case <-svr.Ready():
+	case err := <-done:
+		t.Fatalf("serve failed: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatalf("server %s did not start", svr.addr)
+	}
+	return done
+}
+
+func TestServerDrain(t *testing.T) {
+	gee := NewGroup("drain", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}))
+	peer := &migratePeer{keys: make(map[string]string)}
+	peerAddr := startTestPeer(t, peer)
+
+	svr := newListenServer(t, WithDrainDelay(10*time.Millisecond))
+	addr := svr.Addr()
+	svr.SetPeers(addr)
+	for i := 0; i < 20; i++ {
+		gee.Get(context.Background(), fmt.Sprintf("key%d", i))
+	}
+	svr.SetPeers(addr, peerAddr)
+	done := serveServer(t, svr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := svr.Drain(ctx); err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Serve should return nil after drain, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Serve should return after drain")
+	}
+
+	// 本节点的 key 都交给了剩下的节点
+	peer.mux.Lock()
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if peer.keys["drain/"+key] != "db-"+key {
+			t.Errorf("%s should be handed off", key)
+		}
+	}
+	peer.mux.Unlock()
+	if svr.ctx.Err() == nil {
+		t.Fatalf("server context should be canceled after drain")
+	}
+	if err := svr.Drain(ctx); err == nil {
+		t.Fatalf("drain a stopped server should fail")
+	}
+	// 停止之后选择节点不会 panic，也不会再加回节点
+	svr.Stop()
+	if _, ok := svr.PickPeer("key1"); ok {
+		t.Fatalf("stopped server should not pick peers")
+	}
+	svr.addPeer(svr.Endpoint())
+	if _, ok := svr.PickPeer("key1"); ok {
+		t.Fatalf("drained server should not add itself back")
+	}
+}
+
+func TestServerDrainDeadline(t *testing.T) {
+	svr := newListenServer(t, WithDrainDelay(time.Hour))
+	svr.SetPeers(svr.Addr())
+	done := serveServer(t, svr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := svr.Drain(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Serve should return nil after drain, got %v", err)
+	}
+}
+
+func TestServerStopBeforeStart(t *testing.T) {
+	svr, err := NewServer("127.0.0.1:9001", WithDiscovery(registry.NewStatic()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	svr.Stop()
+	svr.SetPeers("127.0.0.1:9001", "127.0.0.1:9002")
+	svr.PickPeer("key")
+}
+
+func TestServerStart(t *testing.T) {
+	gee := NewGroup("server-start", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}))
+	svr := newListenServer(t)
+	defer svr.Stop()
+	svr.SetPeers(svr.Addr())
+	gee.RegisterPeers(svr)
+
+	// Start 返回时已经可以接受请求
+	if err := svr.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-svr.Ready():
+	default:
+		t.Fatalf("server should be ready after Start")
+	}
+	client := NewClient("geecache/"+svr.Addr(), svr.Addr())
+	defer client.Close()
+	out := &geecachepb.Response{}
+	if err := client.Fetch(context.Background(), &geecachepb.Request{Group: gee.name, Key: "Tom"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if string(out.GetValue()) != "db-Tom" {
+		t.Fatalf("expect db-Tom, got %s", out.GetValue())
+	}
+	if err := svr.Start(); err == nil {
+		t.Fatalf("start twice should fail")
+	}
+}
+
+func TestServerListenError(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	// 端口已经被占用
+	svr, err := NewServer(lis.Addr().String(), WithDiscovery(registry.NewStatic()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	if err := svr.Start(); err == nil {
+		t.Fatalf("listen on a used port should fail")
+	}
+	// 失败之后不会一直持有锁
+	svr.SetPeers(svr.addr)
+	svr.Stop()
+}
@@ -1,24 +1,79 @@
 package geecache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"sync"
+	"time"
 	"v8/geecache/geecachepb"
 	"v8/geecache/singleflight"
+	"v8/geecache/trace"
 )
 
 // Retriever 要求对象实现从数据源获取数据的能力
+// 缓存未命中时 Group 调用 Retrieve，实现把取到的数据写入 dest。
+// ctx 携带了调用方的 deadline 和取消信号，实现应当尊重它
 type Retriever interface {
-	retrieve(key string) ([]byte, error)
+	Retrieve(ctx context.Context, key string, dest Sink) error
 }
 
+// RetrieverFunc 通过实现 Retrieve 方法，使得任意匿名函数func
+// 通过被RetrieverFunc(func)类型强制转换后，实现了 Retriever 接口的能力
+// RetrieverFunc 不关心 ctx
 type RetrieverFunc func(key string) ([]byte, error)
 
-// RetrieverFunc 通过实现retrieve方法，使得任意匿名函数func
-// 通过被RetrieverFunc(func)类型强制转换后，实现了 Retriever 接口的能力
-func (f RetrieverFunc) retrieve(key string) ([]byte, error) {
-	return f(key)
+func (f RetrieverFunc) Retrieve(_ context.Context, key string, dest Sink) error {
+	bytes, err := f(key)
+	if err != nil {
+		return err
+	}
+	return dest.SetBytes(bytes)
+}
+
+// RetrieverCtxFunc 和 RetrieverFunc 类似，但回调可以拿到调用方的 ctx
+type RetrieverCtxFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (f RetrieverCtxFunc) Retrieve(ctx context.Context, key string, dest Sink) error {
+	bytes, err := f(ctx, key)
+	if err != nil {
+		return err
+	}
+	return dest.SetBytes(bytes)
+}
+
+// RetrieverWithExpireFunc 和 RetrieverFunc 类似，但回调可以额外返回数据的过期时间，
+// 返回零值 time.Time 表示永不过期
+type RetrieverWithExpireFunc func(key string) ([]byte, time.Time, error)
+
+func (f RetrieverWithExpireFunc) Retrieve(_ context.Context, key string, dest Sink) error {
+	bytes, expire, err := f(key)
+	if err != nil {
+		return err
+	}
+	dest.SetExpire(expire)
+	return dest.SetBytes(bytes)
+}
+
+// RetrieverWithTTL 把一个 Retriever 包装成所有数据都在 ttl 后过期的 Retriever，
+// r 自己设置的过期时间会被覆盖
+func RetrieverWithTTL(r Retriever, ttl time.Duration) Retriever {
+	return ttlRetriever{r: r, ttl: ttl}
+}
+
+type ttlRetriever struct {
+	r   Retriever
+	ttl time.Duration
+}
+
+func (t ttlRetriever) Retrieve(ctx context.Context, key string, dest Sink) error {
+	if err := t.r.Retrieve(ctx, key, dest); err != nil {
+		return err
+	}
+	dest.SetExpire(time.Now().Add(t.ttl))
+	return nil
 }
 
 // A Group is a cache namespace and associated data loaded spread over
@@ -27,11 +82,18 @@ type Group struct {
 	name      string    //每个 Group 拥有一个唯一的名称 name
 	retriever Retriever //第二个属性是 retriever Retriever，即缓存都不存在时获取源数据的回调(callback)。
 	mainCache *cache    //第三个属性是 mainCache cache，即一开始实现的并发缓存。
+	// hotCache 保存本节点不负责、但被频繁访问的远端 key，
+	// 避免一个热点 key 的所有请求都打到它所在的节点上。可能为 nil
+	hotCache *cache
 
-	peers PeerPicker //节点  就是 HTTPPool类型
+	peers    PeerPicker //节点  就是 HTTPPool类型
+	replicas int        // 每个 key 保存在几个节点上
 	// use singleflight.Group to make sure that
 	// each key is only fetched once
 	loader *singleflight.Flight
+
+	// Stats are statistics on the group.
+	Stats Stats
 }
 
 var (
@@ -39,19 +101,88 @@ var (
 	groups = make(map[string]*Group)
 )
 
+var (
+	defaultHotCachePercent = 12 // 默认从 cacheBytes 中划给 hotCache 的百分比，约 1/8
+	hotCacheSampleRate     = 10 // 从远端获取的值有 1/hotCacheSampleRate 的概率放入 hotCache
+)
+
+// groupOptions 保存 NewGroup 的可选配置
+type groupOptions struct {
+	policy     string // 缓存淘汰策略
+	shards     int    // mainCache 的分片数
+	hotPercent int    // 划给 hotCache 的百分比
+	replicas   int    // 副本数
+}
+
+// GroupOption 用于在 NewGroup 时定制 Group
+type GroupOption func(*groupOptions)
+
+// WithPolicy 指定 Group 使用的缓存淘汰策略，
+// 可选 PolicyLRU（默认）、PolicyLFU、PolicyARC、PolicyTinyLFU
+func WithPolicy(name string) GroupOption {
+	if _, err := newPolicy(name, 0, nil); err != nil {
+		panic(err)
+	}
+	return func(o *groupOptions) {
+		o.policy = name
+	}
+}
+
+// WithShards 把 mainCache 按 key 哈希拆成 n 个分片，每个分片有独立的锁，
+// cacheBytes 平均分给各个分片。默认只有 1 个分片
+func WithShards(n int) GroupOption {
+	if n < 1 {
+		panic("shards must be positive")
+	}
+	return func(o *groupOptions) {
+		o.shards = n
+	}
+}
+
+// WithHotCache 指定从 cacheBytes 中划给 hotCache 的百分比，0 表示不使用 hotCache
+func WithHotCache(percent int) GroupOption {
+	if percent < 0 || percent >= 100 {
+		panic("hot cache percent must be in [0, 100)")
+	}
+	return func(o *groupOptions) {
+		o.hotPercent = percent
+	}
+}
+
+// WithReplication 让每个 key 保存在哈希环上顺时针的 n 个节点上，默认只有 1 个。
+// 主副本所在节点下线后，读请求会依次尝试其余的副本，都失败后才回源。
+// 需要 RegisterPeers 注册的 PeerPicker 同时实现 ReplicaPicker
+func WithReplication(n int) GroupOption {
+	if n < 1 {
+		panic("replication factor must be positive")
+	}
+	return func(o *groupOptions) {
+		o.replicas = n
+	}
+}
+
 // NewGroup create a new instance of Group
-func NewGroup(name string, cacheBytes int64, retriever Retriever) *Group {
+func NewGroup(name string, cacheBytes int64, retriever Retriever, opts ...GroupOption) *Group {
 	if retriever == nil {
 		panic("getter is nil")
 	}
-	mux.Lock()
-	defer mux.Unlock()
+	o := groupOptions{shards: 1, hotPercent: defaultHotCachePercent, replicas: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	hotBytes := cacheBytes * int64(o.hotPercent) / 100
 	g := &Group{
 		name:      name,
 		retriever: retriever,
-		mainCache: &cache{cacheBytes: cacheBytes},
+		mainCache: newCache(cacheBytes-hotBytes, o.shards, o.policy),
+		replicas:  o.replicas,
 		loader:    &singleflight.Flight{},
 	}
+	if hotBytes > 0 {
+		g.hotCache = newCache(hotBytes, o.shards, o.policy)
+	}
+	mux.Lock()
+	defer mux.Unlock()
 	groups[name] = g
 	return g
 }
@@ -65,6 +196,17 @@ func GetGroup(name string) *Group {
 	return g
 }
 
+// allGroups 返回所有的 Group
+func allGroups() []*Group {
+	mux.RLock()
+	defer mux.RUnlock()
+	gs := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		gs = append(gs, g)
+	}
+	return gs
+}
+
 // 假设某一个组下线了
 func DestroyGroup(name string) {
 	g := GetGroup(name)
@@ -76,38 +218,113 @@ func DestroyGroup(name string) {
 	}
 }
 
+// Invalidate 删除本节点上 group/key 的缓存，并异步广播给集群中的所有节点，
+// 每个节点会一直重试直到成功处理或者离开集群；某个节点积压的消息过多时
+// 这条消息不会发给它，返回 ErrInvalidateQueueFull
+func Invalidate(group, key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	g := GetGroup(group)
+	if g == nil {
+		return fmt.Errorf("group %s not found", group)
+	}
+	g.removeLocally(key)
+	if inv, ok := g.peers.(Invalidator); ok {
+		return inv.BroadcastInvalidate(group, key)
+	}
+	return nil
+}
+
 // Get value for a key from cache
 // 流程 ⑴ ：从 mainCache 中查找缓存，如果存在则返回缓存值。
 // 流程 ⑶ ：缓存不存在，则调用 load 方法，
 // load 调用 getLocally（分布式场景下会调用 getFromPeer 从其他节点获取），
 // getLocally 调用用户回调函数 g.getter.Get() 获取源数据，
 // 并且将源数据添加到缓存 mainCache 中（通过 populateCache 方法）
-func (g *Group) Get(key string) (ByteView, error) {
+func (g *Group) Get(ctx context.Context, key string) (value ByteView, err error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
+	ctx, span := startSpan(ctx, "geecache.Get", trace.String("group", g.name), trace.String("key", key))
+	defer func() { endSpan(span, err) }()
 
-	if v, ok := g.mainCache.get(key); ok {
+	g.Stats.Gets.Add(1)
+	_, lookup := startSpan(ctx, "geecache.lookup")
+	v, ok := g.lookupCache(key)
+	lookup.End()
+	span.SetAttributes(trace.Bool("hit", ok))
+	if ok {
+		g.Stats.CacheHits.Add(1)
 		log.Println("[GeeCache] hit")
 		return v, nil
 	}
-	return g.load(key)
+	return g.load(ctx, key)
+}
+
+// CacheStats 返回 Group 中 which 缓存的统计，没有 hotCache 时返回零值
+func (g *Group) CacheStats(which CacheType) CacheStats {
+	switch which {
+	case MainCache:
+		return g.mainCache.stats()
+	case HotCache:
+		if g.hotCache != nil {
+			return g.hotCache.stats()
+		}
+	}
+	return CacheStats{}
+}
+
+// lookupCache 依次在 mainCache 和 hotCache 中查找
+func (g *Group) lookupCache(key string) (value ByteView, ok bool) {
+	if value, ok = g.mainCache.get(key); ok {
+		return
+	}
+	if g.hotCache == nil {
+		return
+	}
+	if value, ok = g.hotCache.get(key); ok {
+		g.Stats.HotCacheHits.Add(1)
+	}
+	return
 }
 
-func (g *Group) load(key string) (value ByteView, err error) {
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
 	// each key is only fetched once (either locally or remotely)
 	// regardless of the number of concurrent callers.
-
+	g.Stats.Loads.Add(1)
+	ctx, span := startSpan(ctx, "geecache.load")
+	executed := false // 为 false 说明是在等待其他调用方的结果
+	defer func() {
+		span.SetAttributes(trace.Bool("deduped", !executed))
+		endSpan(span, err)
+	}()
 	viewi, err := g.loader.Do(key, func() (interface{}, error) {
-		if g.peers != nil {
-			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err = g.getFromPeer(peer, key); err == nil {
+		executed = true
+		g.Stats.LoadsDeduped.Add(1)
+		if peers, self, ok := g.pickReplicas(key); ok {
+			return g.loadReplicated(ctx, key, peers, self)
+		}
+		// 其他节点发来的请求不再转发，这样负载溢出到本节点的 key 由本节点加载
+		if g.peers != nil && !isPeerRequest(ctx) {
+			peer, ok, done := g.pickPeer(key)
+			defer done()
+			if ok {
+				if value, err = g.getFromPeer(ctx, peer, key); err == nil {
 					return value, nil
 				}
 				log.Println("[GeeCache] Failed to get from peer", err)
+				if picker, isFallback := g.peers.(FallbackPicker); isFallback {
+					if peer, ok := picker.PickFallback(key, peer); ok {
+						if value, err = g.getFromPeer(ctx, peer, key); err == nil {
+							return value, nil
+						}
+						log.Println("[GeeCache] Failed to get from fallback peer", err)
+					}
+				}
 			}
 		}
-		return g.getLocally(key)
+		return g.getLocally(ctx, key)
 	})
 	if err == nil {
 		return viewi.(ByteView), nil
@@ -115,25 +332,64 @@ func (g *Group) load(key string) (value ByteView, err error) {
 	return
 }
 
-func (g *Group) getFromPeer(peer Fetcher, key string) (ByteView, error) {
+// pickPeer 选出读取 key 的节点，peers 实现了 LoadPicker 时按负载选择
+func (g *Group) pickPeer(key string) (peer Fetcher, ok bool, done func()) {
+	if picker, isLoad := g.peers.(LoadPicker); isLoad {
+		return picker.PickLoaded(key)
+	}
+	peer, ok = g.peers.PickPeer(key)
+	return peer, ok, func() {}
+}
+
+func (g *Group) getFromPeer(ctx context.Context, peer Fetcher, key string) (ByteView, error) {
+	view, err := g.fetchFromPeer(ctx, peer, key)
+	if err != nil {
+		return ByteView{}, err
+	}
+	// 只抽样一部分放入 hotCache，真正的热点 key 很快就会被抽中，
+	// 而偶尔访问一次的 key 不会把 hotCache 挤满
+	if g.hotCache != nil && rand.Intn(hotCacheSampleRate) == 0 {
+		g.hotCache.add(key, view)
+		g.Stats.HotCacheAdds.Add(1)
+	}
+	return view, nil
+}
+
+// fetchFromPeer 从远端节点获取 key，不放入任何缓存
+func (g *Group) fetchFromPeer(ctx context.Context, peer Fetcher, key string) (_ ByteView, err error) {
+	ctx, span := startSpan(ctx, "geecache.fetch")
+	defer func() { endSpan(span, err) }()
+	if client, ok := peer.(*Client); ok {
+		span.SetAttributes(trace.String("peer", client.addr))
+	}
 	req := &geecachepb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	res := &geecachepb.Response{}
-	err := peer.Fetch(req, res)
+	err = peer.Fetch(ctx, req, res)
 	if err != nil {
+		g.Stats.PeerErrors.Add(1)
 		return ByteView{}, err
 	}
-	return ByteView{b: res.Value}, nil
+	view := ByteView{b: res.Value}
+	if res.Expire != 0 {
+		view.e = time.Unix(0, res.Expire)
+	}
+	g.Stats.PeerLoads.Add(1)
+	return view, nil
 }
 
-func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.retriever.retrieve(key)
-	if err != nil {
+func (g *Group) getLocally(ctx context.Context, key string) (_ ByteView, err error) {
+	ctx, span := startSpan(ctx, "geecache.retrieve")
+	defer func() { endSpan(span, err) }()
+	var dest viewSink
+	if err := g.retriever.Retrieve(ctx, key, &dest); err != nil {
+		g.Stats.LocalLoadErrs.Add(1)
 		return ByteView{}, err
 	}
-	value := ByteView{b: cloneBytes(bytes)}
+	g.Stats.LocalLoads.Add(1)
+	value := dest.view()
 	g.populateCache(key, value)
 	return value, nil
 }
@@ -142,6 +398,114 @@ func (g *Group) populateCache(key string, value ByteView) {
 	g.mainCache.add(key, value)
 }
 
+// Set 写入 key 对应的值，零值 expire 表示永不过期
+// 如果 key 属于远端节点，则通过 rpc 写到该节点上
+func (g *Group) Set(ctx context.Context, key string, value []byte) error {
+	return g.SetWithExpire(ctx, key, value, time.Time{})
+}
+
+// SetWithExpire 写入一个在 expire 时刻过期的值
+func (g *Group) SetWithExpire(ctx context.Context, key string, value []byte, expire time.Time) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if peers, self, ok := g.pickReplicas(key); ok {
+		return g.setReplicated(ctx, key, ByteView{b: cloneBytes(value), e: expire}, peers, self)
+	}
+	if updater, ok, err := g.pickUpdater(key); ok {
+		if err != nil {
+			return err
+		}
+		req := &geecachepb.SetRequest{Group: g.name, Key: key, Value: value}
+		if !expire.IsZero() {
+			req.Expire = expire.UnixNano()
+		}
+		// 本地 hotCache 里的副本已经过时了
+		g.removeHot(key)
+		return updater.Set(ctx, req)
+	}
+	g.setLocally(key, ByteView{b: cloneBytes(value), e: expire})
+	return nil
+}
+
+// Remove 删除 key 在其所属节点上的缓存
+func (g *Group) Remove(ctx context.Context, key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	// 本地可能也有旧数据，一并删掉
+	g.removeLocally(key)
+	if peers, _, ok := g.pickReplicas(key); ok {
+		return g.removeReplicated(ctx, key, peers)
+	}
+	if updater, ok, err := g.pickUpdater(key); ok {
+		if err != nil {
+			return err
+		}
+		return updater.Remove(ctx, &geecachepb.Request{Group: g.name, Key: key})
+	}
+	return nil
+}
+
+// Purge 清空本节点以及所有远端节点上这个 Group 的缓存
+func (g *Group) Purge(ctx context.Context) error {
+	g.purgeLocally()
+	lister, ok := g.peers.(PeerLister)
+	if !ok {
+		return nil
+	}
+	var errs []error
+	for _, peer := range lister.ListPeers() {
+		updater, ok := peer.(Updater)
+		if !ok {
+			errs = append(errs, fmt.Errorf("peer %v does not support purge", peer))
+			continue
+		}
+		if err := updater.Purge(ctx, &geecachepb.PurgeRequest{Group: g.name}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pickUpdater 找到 key 所属的远端节点，ok 为 false 表示 key 属于本节点
+func (g *Group) pickUpdater(key string) (updater Updater, ok bool, err error) {
+	if g.peers == nil {
+		return nil, false, nil
+	}
+	peer, ok := g.peers.PickPeer(key)
+	if !ok {
+		return nil, false, nil
+	}
+	updater, isUpdater := peer.(Updater)
+	if !isUpdater {
+		return nil, true, fmt.Errorf("peer %v does not support update", peer)
+	}
+	return updater, true, nil
+}
+
+func (g *Group) setLocally(key string, value ByteView) {
+	g.populateCache(key, value)
+}
+
+func (g *Group) removeLocally(key string) {
+	g.mainCache.remove(key)
+	g.removeHot(key)
+}
+
+func (g *Group) removeHot(key string) {
+	if g.hotCache != nil {
+		g.hotCache.remove(key)
+	}
+}
+
+func (g *Group) purgeLocally() {
+	g.mainCache.clear()
+	if g.hotCache != nil {
+		g.hotCache.clear()
+	}
+}
+
 // RegisterPeers registers a PeerPicker for choosing remote peer
 func (g *Group) RegisterPeers(peers PeerPicker) {
 	if g.peers != nil {
@@ -1,19 +1,85 @@
 package geecache
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"reflect"
 	"testing"
+	"time"
+	"v8/geecache/geecachepb"
 )
 
 func TestGetter(t *testing.T) {
 	var f Retriever = RetrieverFunc(func(key string) ([]byte, error) {
 		return []byte(key), nil
 	})
+	var dest viewSink
 	expect := []byte("key")
-	if v, _ := f.retrieve("key"); !reflect.DeepEqual(expect, v) {
-		t.Errorf("expect:%v,actual:%v", expect, v)
+	if err := f.Retrieve(context.Background(), "key", &dest); err != nil || !reflect.DeepEqual(expect, dest.view().ByteSlice()) {
+		t.Errorf("expect:%v,actual:%v", expect, dest.view().ByteSlice())
+	}
+}
+
+// versionRetriever 是一个带状态的 Retriever，记录加载次数并给每个值附加版本号
+type versionRetriever struct {
+	loads int
+	ttl   time.Duration
+}
+
+func (r *versionRetriever) Retrieve(ctx context.Context, key string, dest Sink) error {
+	r.loads++
+	if v, ok := db[key]; ok {
+		dest.SetExpire(time.Now().Add(r.ttl))
+		dest.SetMetadata("version", fmt.Sprint(r.loads))
+		return dest.SetString(v)
+	}
+	return fmt.Errorf("%s not exist", key)
+}
+
+func TestRetrieverStruct(t *testing.T) {
+	r := &versionRetriever{ttl: time.Minute}
+	gee := NewGroup("struct-retriever", 2<<10, r)
+
+	view, err := gee.Get(context.Background(), "Tom")
+	if err != nil || view.String() != db["Tom"] {
+		t.Fatalf("failed to get value of Tom, got %s err=%v", view, err)
+	}
+	if view.Expire().IsZero() || time.Until(view.Expire()) > time.Minute {
+		t.Fatalf("expect Tom expires within a minute, got %v", view.Expire())
+	}
+	if version, ok := view.Metadata("version"); !ok || version != "1" {
+		t.Fatalf("expect version 1, got %q", version)
+	}
+	if _, err := gee.Get(context.Background(), "Tom"); err != nil || r.loads != 1 {
+		t.Fatalf("second Get should hit cache, loads=%d err=%v", r.loads, err)
+	}
+	if _, err := gee.Get(context.Background(), "unknown"); err == nil {
+		t.Fatalf("the value of unknown should be empty, but got error nil")
+	}
+}
+
+func TestGetContext(t *testing.T) {
+	var deadline time.Time
+	gee := NewGroup("context", 2<<10, RetrieverCtxFunc(func(ctx context.Context, key string) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		deadline, _ = ctx.Deadline()
+		return []byte(key), nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	want, _ := ctx.Deadline()
+	if _, err := gee.Get(ctx, "Tom"); err != nil || !deadline.Equal(want) {
+		t.Fatalf("retriever should see caller deadline %v, got %v err=%v", want, deadline, err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := gee.Get(cancelled, "Jack"); err == nil {
+		t.Fatalf("Get with cancelled context should fail")
 	}
 }
 
@@ -37,15 +103,242 @@ func TestGet(t *testing.T) {
 		return nil, fmt.Errorf("%s not found", key)
 	}))
 	for k, v := range db {
-		if view, err := gee.Get(k); err != nil || view.String() != v {
+		if view, err := gee.Get(context.Background(), k); err != nil || view.String() != v {
 			t.Fatal("failed to get value of Tom")
 		}
-		if _, err := gee.Get(k); err != nil || loadCounts[k] > 1 {
+		if _, err := gee.Get(context.Background(), k); err != nil || loadCounts[k] > 1 {
 			t.Fatalf("cache %s miss", k)
 		}
 	}
 
-	if view, err := gee.Get("unknown"); err == nil {
+	if view, err := gee.Get(context.Background(), "unknown"); err == nil {
 		t.Fatalf("the value of unknow should be empty, but %s got", view)
 	}
 }
+
+func TestGetExpire(t *testing.T) {
+	// Tom 一小时后过期，Jack 加载出来就已经过期，不需要等待时间流逝
+	expires := map[string]time.Time{"Tom": time.Now().Add(time.Hour), "Jack": time.Now().Add(-time.Second)}
+	loads := make(map[string]int)
+	gee := NewGroup("expire", 2<<10, RetrieverWithExpireFunc(func(key string) ([]byte, time.Time, error) {
+		loads[key]++
+		return []byte(key), expires[key], nil
+	}))
+
+	for _, key := range []string{"Tom", "Jack"} {
+		view, err := gee.Get(context.Background(), key)
+		if err != nil || view.String() != key || !view.Expire().Equal(expires[key]) {
+			t.Fatalf("failed to get %s with expire, view=%v err=%v", key, view, err)
+		}
+		if _, err := gee.Get(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loads["Tom"] != 1 {
+		t.Fatalf("Tom should be cached before expire, loads=%d", loads["Tom"])
+	}
+	if loads["Jack"] != 2 {
+		t.Fatalf("Jack should be reloaded after expire, loads=%d", loads["Jack"])
+	}
+}
+
+func TestGetWithPolicy(t *testing.T) {
+	for _, name := range []string{PolicyLRU, PolicyLFU, PolicyARC, PolicyTinyLFU} {
+		loads := 0
+		gee := NewGroup("policy-"+name, 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+			loads++
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not found", key)
+		}), WithPolicy(name))
+
+		for k, v := range db {
+			if view, err := gee.Get(context.Background(), k); err != nil || view.String() != v {
+				t.Fatalf("[%s] failed to get value of %s", name, k)
+			}
+			if _, err := gee.Get(context.Background(), k); err != nil {
+				t.Fatalf("[%s] failed to get cached value of %s", name, k)
+			}
+		}
+		if loads != len(db) {
+			t.Fatalf("[%s] expect %d loads, got %d", name, len(db), loads)
+		}
+	}
+}
+
+func TestUnknownPolicy(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatalf("unknown policy should panic")
+		}
+	}()
+	NewGroup("unknown-policy", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithPolicy("fifo"))
+}
+
+func TestSetRemovePurge(t *testing.T) {
+	loads := 0
+	gee := NewGroup("set", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("db-" + key), nil
+	}))
+
+	if err := gee.Set(context.Background(), "Tom", []byte("630")); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := gee.Get(context.Background(), "Tom"); err != nil || view.String() != "630" || loads != 0 {
+		t.Fatalf("Set value should be served from cache, got %v loads=%d", view, loads)
+	}
+
+	if err := gee.Remove(context.Background(), "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := gee.Get(context.Background(), "Tom"); err != nil || view.String() != "db-Tom" || loads != 1 {
+		t.Fatalf("removed key should be reloaded, got %v loads=%d", view, loads)
+	}
+
+	if err := gee.Purge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gee.Get(context.Background(), "Tom"); err != nil || loads != 2 {
+		t.Fatalf("purged key should be reloaded, loads=%d", loads)
+	}
+}
+
+// fakePeer 从 values 中返回数据，并记录收到的请求
+type fakePeer struct {
+	values  map[string]string
+	fetches int
+	sets    []*geecachepb.SetRequest
+	removes []*geecachepb.Request
+	purges  []*geecachepb.PurgeRequest
+}
+
+func (p *fakePeer) Fetch(ctx context.Context, in *geecachepb.Request, out *geecachepb.Response) error {
+	p.fetches++
+	v, ok := p.values[in.GetKey()]
+	if !ok {
+		return fmt.Errorf("%s not found", in.GetKey())
+	}
+	out.Value = []byte(v)
+	return nil
+}
+
+func (p *fakePeer) Set(ctx context.Context, in *geecachepb.SetRequest) error {
+	p.sets = append(p.sets, in)
+	return nil
+}
+
+func (p *fakePeer) Remove(ctx context.Context, in *geecachepb.Request) error {
+	p.removes = append(p.removes, in)
+	return nil
+}
+
+func (p *fakePeer) Purge(ctx context.Context, in *geecachepb.PurgeRequest) error {
+	p.purges = append(p.purges, in)
+	return nil
+}
+
+func (p *fakePeer) Invalidate(ctx context.Context, in *geecachepb.InvalidateRequest) error {
+	return nil
+}
+
+// fakePicker 把 remote 里的 key 交给 peer，其余的属于本节点
+type fakePicker struct {
+	peer   *fakePeer
+	remote map[string]bool
+}
+
+func (p *fakePicker) PickPeer(key string) (Fetcher, bool) {
+	if p.remote[key] {
+		return p.peer, true
+	}
+	return nil, false
+}
+
+func (p *fakePicker) ListPeers() []Fetcher {
+	return []Fetcher{p.peer}
+}
+
+func TestSetRemoteOwner(t *testing.T) {
+	gee := NewGroup("set-remote", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	peer := &fakePeer{}
+	gee.RegisterPeers(&fakePicker{peer: peer, remote: map[string]bool{"Jack": true}})
+
+	expire := time.Now().Add(time.Minute)
+	if err := gee.SetWithExpire(context.Background(), "Jack", []byte("589"), expire); err != nil {
+		t.Fatal(err)
+	}
+	if err := gee.Set(context.Background(), "Tom", []byte("630")); err != nil {
+		t.Fatal(err)
+	}
+	if len(peer.sets) != 1 || peer.sets[0].GetKey() != "Jack" || peer.sets[0].GetExpire() != expire.UnixNano() {
+		t.Fatalf("Set Jack should go to the owner peer, got %v", peer.sets)
+	}
+	if _, ok := gee.mainCache.get("Jack"); ok {
+		t.Fatalf("remote key Jack should not be cached locally")
+	}
+	if _, ok := gee.mainCache.get("Tom"); !ok {
+		t.Fatalf("local key Tom should be cached locally")
+	}
+
+	if err := gee.Remove(context.Background(), "Jack"); err != nil || len(peer.removes) != 1 {
+		t.Fatalf("Remove Jack should go to the owner peer, err=%v", err)
+	}
+	if err := gee.Purge(context.Background()); err != nil || len(peer.purges) != 1 {
+		t.Fatalf("Purge should be sent to every peer, err=%v", err)
+	}
+	if _, ok := gee.mainCache.get("Tom"); ok {
+		t.Fatalf("Purge should clear local cache")
+	}
+}
+
+func TestHotCache(t *testing.T) {
+	old := hotCacheSampleRate
+	hotCacheSampleRate = 1
+	defer func() { hotCacheSampleRate = old }()
+
+	gee := NewGroup("hot", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s should be loaded from peer", key)
+	}))
+	peer := &fakePeer{values: map[string]string{"Jack": "589"}}
+	gee.RegisterPeers(&fakePicker{peer: peer, remote: map[string]bool{"Jack": true}})
+
+	for i := 0; i < 3; i++ {
+		if view, err := gee.Get(context.Background(), "Jack"); err != nil || view.String() != "589" {
+			t.Fatalf("failed to get Jack from peer, err=%v", err)
+		}
+	}
+	if peer.fetches != 1 {
+		t.Fatalf("hot cache should absorb repeated peer fetches, got %d fetches", peer.fetches)
+	}
+	if _, ok := gee.mainCache.get("Jack"); ok {
+		t.Fatalf("remote key should not be stored in mainCache")
+	}
+	if gee.Stats.PeerLoads.Get() != 1 || gee.Stats.HotCacheAdds.Get() != 1 || gee.Stats.HotCacheHits.Get() != 2 {
+		t.Fatalf("unexpected stats peerLoads=%s hotAdds=%s hotHits=%s",
+			&gee.Stats.PeerLoads, &gee.Stats.HotCacheAdds, &gee.Stats.HotCacheHits)
+	}
+
+	// 远端 key 被修改后，本地的热点副本应该失效
+	gee.Set(context.Background(), "Jack", []byte("590"))
+	if _, ok := gee.hotCache.get("Jack"); ok {
+		t.Fatalf("Set should drop stale hot copy")
+	}
+}
+
+func TestHotCacheDisabled(t *testing.T) {
+	gee := NewGroup("hot-disabled", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithHotCache(0))
+	if gee.hotCache != nil {
+		t.Fatalf("hot cache should be disabled")
+	}
+	if gee.mainCache.shards[0].cacheBytes != 2<<10 {
+		t.Fatalf("mainCache should get all cacheBytes")
+	}
+}
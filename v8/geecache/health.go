@@ -0,0 +1,54 @@
+package geecache
+
+import (
+	"encoding/json"
+	"net/http"
+	"v8/geecache/registry"
+)
+
+// Health 是节点的健康状态，用于负载均衡和编排系统的健康检查
+type Health struct {
+	Serving  bool                   `json:"serving"`  // grpc 服务正在运行
+	Draining bool                   `json:"draining"` // 正在平滑下线
+	Registry registry.RegisterState `json:"-"`        // 在 discovery 中的注册状态
+}
+
+// Healthy 节点正在服务、没有下线，并且其他节点可以通过 discovery 发现它时返回 true
+func (h Health) Healthy() bool {
+	return h.Serving && !h.Draining && h.Registry == registry.StateRegistered
+}
+
+func (h Health) MarshalJSON() ([]byte, error) {
+	type health Health // 去掉 MarshalJSON 方法，避免递归
+	return json.Marshal(struct {
+		health
+		Registry string `json:"registry"`
+		Healthy  bool   `json:"healthy"`
+	}{health(h), h.Registry.String(), h.Healthy()})
+}
+
+// Health 返回节点当前的健康状态。
+// discovery 实现了 registry.StateReporter 时使用它报告的注册状态，
+// 比如 etcd 租约丢失、正在重新注册时节点是不健康的；
+// 否则认为节点运行期间一直处于注册状态
+func (s *Server) Health() Health {
+	s.mux.Lock()
+	h := Health{Serving: s.status, Draining: s.draining}
+	s.mux.Unlock()
+	if reporter, ok := s.discovery.(registry.StateReporter); ok {
+		h.Registry = reporter.RegisterState()
+	} else if h.Serving && !h.Draining {
+		h.Registry = registry.StateRegistered
+	}
+	return h
+}
+
+// serveHealth 返回 JSON 编码的 Health，节点不健康时状态码为 503
+func (s *Server) serveHealth(w http.ResponseWriter, r *http.Request) {
+	h := s.Health()
+	w.Header().Set("Content-Type", "application/json")
+	if !h.Healthy() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
@@ -0,0 +1,69 @@
+package geecache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	"v8/geecache/registry"
+)
+
+// reportingDiscovery 是可以修改注册状态的静态服务发现
+type reportingDiscovery struct {
+	*registry.Static
+	state atomic.Int32
+}
+
+func (d *reportingDiscovery) RegisterState() registry.RegisterState {
+	return registry.RegisterState(d.state.Load())
+}
+
+// getHealth 请求 /admin/health，返回状态码和解码后的结果
+func getHealth(t *testing.T, svr *Server) (int, map[string]any) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	svr.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/health", nil))
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid health body %q: %v", w.Body.String(), err)
+	}
+	return w.Code, body
+}
+
+func TestServerHealth(t *testing.T) {
+	svr := newListenServer(t, WithDrainDelay(time.Millisecond))
+	if code, body := getHealth(t, svr); code != http.StatusServiceUnavailable || body["serving"] != false {
+		t.Fatalf("server not started should be unhealthy, got %d %v", code, body)
+	}
+	serveServer(t, svr)
+	if code, body := getHealth(t, svr); code != http.StatusOK || body["registry"] != "registered" || body["healthy"] != true {
+		t.Fatalf("running server should be healthy, got %d %v", code, body)
+	}
+
+	if err := svr.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if h := svr.Health(); h.Healthy() || h.Serving || !h.Draining {
+		t.Fatalf("drained server should be unhealthy, got %+v", h)
+	}
+}
+
+func TestServerHealthRegistry(t *testing.T) {
+	d := &reportingDiscovery{Static: registry.NewStatic()}
+	svr := newListenServer(t, WithDiscovery(d))
+	serveServer(t, svr)
+	defer svr.Stop()
+
+	// 注册丢失、正在重试时节点不健康，重新注册之后恢复
+	d.state.Store(int32(registry.StateRetrying))
+	if code, body := getHealth(t, svr); code != http.StatusServiceUnavailable || body["registry"] != "retrying" || body["serving"] != true {
+		t.Fatalf("server retrying registration should be unhealthy, got %d %v", code, body)
+	}
+	d.state.Store(int32(registry.StateRegistered))
+	if code, _ := getHealth(t, svr); code != http.StatusOK {
+		t.Fatalf("server should be healthy after re-registration, got %d", code)
+	}
+}
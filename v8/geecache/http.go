@@ -1,6 +1,7 @@
 package geecache
 
 import (
+	"context"
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	"io"
@@ -25,18 +26,28 @@ type HTTPPool struct {
 	self        string
 	basePath    string
 	mux         sync.Mutex // guards peers and httpGetters
-	peers       *consistenthash.Consistency
+	peers       consistenthash.Placement
 	httpGetters map[string]*httpGetter // keyed by e.g. "http://10.0.0.2:8008"
+
+	newPlacement func() consistenthash.Placement
 }
 
 // NewHTTPPool initializes an HTTP pool of peers.
 func NewHTTPPool(self string) *HTTPPool {
 	return &HTTPPool{
-		self:     self,
-		basePath: defaultBasePath,
+		self:         self,
+		basePath:     defaultBasePath,
+		newPlacement: defaultPlacement,
 	}
 }
 
+// SetPlacement 指定 key 的放置算法，在下一次 Set 时生效
+func (p *HTTPPool) SetPlacement(newPlacement func() consistenthash.Placement) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.newPlacement = newPlacement
+}
+
 // Log info with server name
 func (p *HTTPPool) Log(format string, args ...interface{}) {
 	log.Printf("[Server %s] %s", p.self, fmt.Sprintf(format, args...))
@@ -62,14 +73,18 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	view, err := group.Get(key)
+	view, err := group.Get(withPeerRequest(r.Context()), key)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Write the value to the response body as a proto message.
-	body, err := proto.Marshal(&geecachepb.Response{Value: view.ByteSlice()})
+	res := &geecachepb.Response{Value: view.ByteSlice()}
+	if expire := view.Expire(); !expire.IsZero() {
+		res.Expire = expire.UnixNano()
+	}
+	body, err := proto.Marshal(res)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -84,10 +99,10 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (p *HTTPPool) Set(peers ...string) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
-	p.peers = consistenthash.New(defaultReplicas, nil)
-	p.peers.Register(peers...) //这里面的peers切片  就是 peer 的值为 http://192.168.1.100，这代表一个远程节点的地址。
+	p.peers = p.newPlacement()
 	p.httpGetters = make(map[string]*httpGetter, len(peers))
 	for _, peer := range peers {
+		p.peers.RegisterWeighted(peer, 1) //这里面的peers切片  就是 peer 的值为 http://192.168.1.100，这代表一个远程节点的地址。
 		p.httpGetters[peer] = &httpGetter{baseURL: peer + p.basePath}
 	}
 }
@@ -112,7 +127,7 @@ type httpGetter struct {
 // 判断是否实现了 PeerGetter
 var _ Fetcher = (*httpGetter)(nil)
 
-func (g *httpGetter) Fetch(in *geecachepb.Request, out *geecachepb.Response) error {
+func (g *httpGetter) Fetch(ctx context.Context, in *geecachepb.Request, out *geecachepb.Response) error {
 	//例如，若 group 的值为 hello world，key 的值为 user&admin，不进行转义的话，
 	//构建的 URL 可能是 http://example.com?group=hello world&key=user&admin，
 	//服务器会把 hello 和 world 以及 user 和 admin 分别当作不同的参数值，从而产生解析错误。
@@ -126,7 +141,11 @@ func (g *httpGetter) Fetch(in *geecachepb.Request, out *geecachepb.Response) err
 		url.QueryEscape(in.GetKey()),
 	)
 
-	resp, err := http.Get(u)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,174 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"time"
+	"v8/geecache/geecachepb"
+)
+
+var invalidateQueueSize = 1024 // 每个远端节点等待发送的失效消息队列长度
+
+// invalidateRetry 是发送失效消息的超时和重试间隔，发送协程创建时复制一份
+var invalidateRetry = retryPolicy{
+	timeout:    time.Second,
+	backoff:    100 * time.Millisecond,
+	maxBackoff: 5 * time.Second,
+}
+
+type retryPolicy struct {
+	timeout    time.Duration // 每次发送的超时时间
+	backoff    time.Duration // 第一次重试前的等待时间，之后每次翻倍
+	maxBackoff time.Duration // 重试间隔的上限
+}
+
+// ErrInvalidateQueueFull 表示有节点的失效消息队列已满，这条消息没有发给该节点，调用方可以稍后重试
+var ErrInvalidateQueueFull = errors.New("invalidate queue is full")
+
+type invalidation struct {
+	group string
+	key   string
+}
+
+// BroadcastInvalidate 把失效消息放入每个远端节点各自的有界队列，由每个节点的后台协程按顺序发送。
+// 发送失败时一直重试，直到成功或者节点离开集群，所以一个节点不可用只会让它自己的队列积压，
+// 不影响发给其他节点的消息。某个节点的队列满时这条消息不会发给该节点，
+// 其他节点照常发送，返回 ErrInvalidateQueueFull
+func (s *Server) BroadcastInvalidate(group, key string) error {
+	inv := invalidation{group: group, key: key}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var err error
+	for addr := range s.clients {
+		if addr == s.addr {
+			continue
+		}
+		select {
+		case s.invalidateQueueLocked(addr) <- inv:
+		default:
+			log.Printf("[geecache_svr %s] invalidate queue of %s is full, drop %s/%s", s.addr, addr, group, key)
+			err = ErrInvalidateQueueFull
+		}
+	}
+	return err
+}
+
+// 测试Server是否实现了Invalidator接口
+var _ Invalidator = (*Server)(nil)
+
+// invalidateQueueLocked 返回 addr 的失效消息队列，第一次使用时创建队列和发送协程。调用方持有 s.mux
+func (s *Server) invalidateQueueLocked(addr string) chan invalidation {
+	q, ok := s.invalidateQueues[addr]
+	if !ok {
+		if s.invalidateQueues == nil {
+			s.invalidateQueues = make(map[string]chan invalidation)
+		}
+		q = make(chan invalidation, invalidateQueueSize)
+		s.invalidateQueues[addr] = q
+		go s.runInvalidations(addr, q, invalidateRetry)
+	}
+	return q
+}
+
+// closeInvalidateQueueLocked 在节点离开集群时关闭它的队列，发送协程处理完当前消息后退出。
+// 发送方也持有 s.mux，所以关闭之后不会再有消息写入。调用方持有 s.mux
+func (s *Server) closeInvalidateQueueLocked(addr string) {
+	if q, ok := s.invalidateQueues[addr]; ok {
+		delete(s.invalidateQueues, addr)
+		close(q)
+	}
+}
+
+// runInvalidations 按顺序把 q 中的失效消息发给 addr
+func (s *Server) runInvalidations(addr string, q <-chan invalidation, retry retryPolicy) {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case inv, ok := <-q:
+			if !ok {
+				return
+			}
+			req := &geecachepb.InvalidateRequest{Group: inv.group, Key: inv.key}
+			if err := s.sendInvalidate(addr, req, retry); err != nil {
+				log.Printf("[geecache_svr %s] invalidate %s/%s on %s failed: %v", s.addr, inv.group, inv.key, addr, err)
+			}
+		}
+	}
+}
+
+// sendInvalidate 带指数退避地重试，直到成功、节点已经下线、server 停止或者遇到重试也不会成功的错误。
+// 每次发送都有 retry.timeout 的超时，不会卡在一个没有响应的节点上
+func (s *Server) sendInvalidate(addr string, req *geecachepb.InvalidateRequest, retry retryPolicy) error {
+	backoff := retry.backoff
+	for {
+		peer, ok := s.peerClient(addr)
+		if !ok {
+			// 节点已经被移出集群，它的缓存也就无所谓了
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(s.ctx, retry.timeout)
+		err := peer.Invalidate(ctx, req)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if !retryable(err) {
+			return err
+		}
+		select {
+		case <-s.ctx.Done():
+			return fmt.Errorf("server stopped: %v", err)
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, retry.maxBackoff)
+	}
+}
+
+// retryable 判断失效消息发送失败后是否值得重试。
+// 连接失败、超时等暂时性的错误重试；对方明确拒绝的请求（比如没有这个 group）重试也不会成功，
+// 旧版本的节点把这类错误返回为 codes.Unknown，同样不重试
+func retryable(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok {
+		// 不是 grpc 返回的错误，比如本地建立连接失败
+		return true
+	}
+	switch st.Code() {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// peerClient 返回 addr 当前的客户端，节点不在集群中时返回 false
+func (s *Server) peerClient(addr string) (Updater, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	client, ok := s.clients[addr]
+	if !ok {
+		return nil, false
+	}
+	return client, true
+}
+
+// Invalidate 实现GroupCache service的Invalidate接口，只删除本节点的缓存，不再继续广播
+func (s *Server) Invalidate(ctx context.Context, in *geecachepb.InvalidateRequest) (*geecachepb.Ack, error) {
+	groupName, key := in.GetGroup(), in.GetKey()
+	log.Printf("[geecache_svr %s] Recv RPC Invalidate - (%s)/(%s)", s.addr, groupName, key)
+
+	// 返回带错误码的 status，发送方据此判断不需要重试
+	if key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key required")
+	}
+	group := GetGroup(groupName)
+	if group == nil {
+		return nil, status.Errorf(codes.NotFound, "group %s not found", groupName)
+	}
+	group.removeLocally(key)
+	return &geecachepb.Ack{}, nil
+}
@@ -0,0 +1,207 @@
+package lfu
+
+import (
+	"container/list"
+	"time"
+	"v8/geecache/policy"
+)
+
+// Cache is a LFU cache. It is not safe for concurrent access.
+// 访问次数相同的条目之间按 LRU 淘汰，所有操作都是 O(1) 的。
+type Cache struct {
+	maxBytes  int64                    // 允许使用的最大内存（字节），0 表示不限制
+	nbytes    int64                    // 当前已使用的内存大小（字节）
+	freqs     *list.List               // 频次桶链表，按访问次数从小到大排列，元素是 *bucket
+	cache     map[string]*list.Element // 哈希表，值是条目在所属频次桶链表中的节点
+	OnEvicted policy.OnEvicted         // 当数据被移除时的回调函数
+
+	now func() time.Time // 获取当前时间，测试时可替换
+}
+
+// bucket 保存访问次数都为 freq 的条目，items 头部是最近访问的
+type bucket struct {
+	freq  int
+	items *list.List
+}
+
+type entry struct {
+	key    string
+	value  Value
+	expire time.Time     // 过期时间，零值表示永不过期
+	bucket *list.Element // 所在的频次桶
+}
+
+// Value use Len to count how many bytes it takes
+type Value = policy.Value
+
+var _ policy.Policy = (*Cache)(nil)
+
+// New is the Constructor of Cache
+func New(maxBytes int64, onEvicted policy.OnEvicted) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		freqs:     list.New(),
+		cache:     make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+		now:       time.Now,
+	}
+}
+
+// Get look ups a key's value and increases its frequency
+func (c *Cache) Get(key string) (value Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return
+	}
+	kv := ele.Value.(*entry)
+	if policy.Expired(kv.expire, c.now()) {
+		c.removeElement(ele, policy.EvictExpired)
+		return nil, false
+	}
+	c.increment(ele)
+	return kv.value, true
+}
+
+// Peek 查找 key 但不改变它的访问记录，已过期的条目视为不存在
+func (c *Cache) Peek(key string) (value Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return
+	}
+	kv := ele.Value.(*entry)
+	if policy.Expired(kv.expire, c.now()) {
+		return nil, false
+	}
+	return kv.value, true
+}
+
+// increment 把条目移动到访问次数 +1 的频次桶中
+func (c *Cache) increment(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	cur := kv.bucket
+	b := cur.Value.(*bucket)
+
+	next := cur.Next()
+	if next == nil || next.Value.(*bucket).freq != b.freq+1 {
+		next = c.freqs.InsertAfter(&bucket{freq: b.freq + 1, items: list.New()}, cur)
+	}
+	b.items.Remove(ele)
+	kv.bucket = next
+	c.cache[kv.key] = next.Value.(*bucket).items.PushFront(kv)
+
+	if b.items.Len() == 0 {
+		c.freqs.Remove(cur)
+	}
+}
+
+// RemoveLeast removes the least frequently used item,
+// ties are broken by removing the least recently used one.
+func (c *Cache) RemoveLeast() {
+	front := c.freqs.Front()
+	if front == nil {
+		return
+	}
+	if ele := front.Value.(*bucket).items.Back(); ele != nil {
+		c.removeElement(ele, policy.EvictCapacity)
+	}
+}
+
+// Remove removes the provided key from the cache.
+func (c *Cache) Remove(key string) bool {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, policy.EvictRemoved)
+		return true
+	}
+	return false
+}
+
+// Clear purges all stored items from the cache.
+func (c *Cache) Clear() {
+	for _, ele := range c.cache {
+		c.removeElement(ele, policy.EvictRemoved)
+	}
+}
+
+// RemoveExpired 扫描整个缓存，删除所有已过期的条目，返回删除的个数
+func (c *Cache) RemoveExpired() int {
+	now := c.now()
+	n := 0
+	for _, ele := range c.cache {
+		if policy.Expired(ele.Value.(*entry).expire, now) {
+			c.removeElement(ele, policy.EvictExpired)
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Cache) removeElement(ele *list.Element, reason policy.EvictReason) {
+	kv := ele.Value.(*entry)
+	b := kv.bucket.Value.(*bucket)
+	b.items.Remove(ele)
+	if b.items.Len() == 0 {
+		c.freqs.Remove(kv.bucket)
+	}
+	delete(c.cache, kv.key)
+	c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value, reason)
+	}
+}
+
+// Add adds a value to the cache.
+func (c *Cache) Add(key string, value Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire adds a value that expires at the given time,
+// the zero time means never expire.
+func (c *Cache) AddWithExpire(key string, value Value, expire time.Time) {
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		c.nbytes += int64(value.Len()) - int64(kv.value.Len())
+		kv.value = value
+		kv.expire = expire
+		c.increment(ele)
+	} else {
+		// 新条目的访问次数为 1，放在最前面的频次桶里
+		front := c.freqs.Front()
+		if front == nil || front.Value.(*bucket).freq != 1 {
+			front = c.freqs.PushFront(&bucket{freq: 1, items: list.New()})
+		}
+		kv := &entry{key: key, value: value, expire: expire, bucket: front}
+		c.cache[key] = front.Value.(*bucket).items.PushFront(kv)
+		c.nbytes += int64(len(key)) + int64(value.Len())
+	}
+
+	for c.maxBytes > 0 && c.maxBytes < c.nbytes {
+		c.RemoveLeast()
+	}
+}
+
+// Range calls fn for each unexpired entry from the most frequently used one,
+// until fn returns false.
+func (c *Cache) Range(fn func(key string, value Value) bool) {
+	now := c.now()
+	for b := c.freqs.Back(); b != nil; b = b.Prev() {
+		for ele := b.Value.(*bucket).items.Front(); ele != nil; ele = ele.Next() {
+			kv := ele.Value.(*entry)
+			if policy.Expired(kv.expire, now) {
+				continue
+			}
+			if !fn(kv.key, kv.value) {
+				return
+			}
+		}
+	}
+}
+
+// Len the number of cache entries
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+// Bytes the number of bytes taken by cache entries
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
@@ -0,0 +1,220 @@
+package lfu
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"v8/geecache/policy"
+	"v8/geecache/policy/policytest"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+func TestGet(t *testing.T) {
+	lfu := New(int64(0), nil)
+	lfu.Add("key1", String("1234"))
+	if v, ok := lfu.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+
+	if _, ok := lfu.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+func TestRemoveLeast(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "k3"
+	v1, v2, v3 := "value1", "value2", "v3"
+	cap := len(k1 + k2 + v1 + v2)
+	lfu := New(int64(cap), nil)
+	lfu.Add(k1, String(v1))
+	lfu.Add(k2, String(v2))
+	// key1 被访问过，频次更高，应该淘汰 key2
+	lfu.Get(k1)
+	lfu.Add(k3, String(v3))
+
+	if _, ok := lfu.Get("key2"); ok || lfu.Len() != 2 {
+		t.Fatalf("RemoveLeast key2 failed")
+	}
+	if _, ok := lfu.Get("key1"); !ok {
+		t.Fatalf("frequently used key1 should be kept")
+	}
+}
+
+func TestPeek(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "k3"
+	v1, v2, v3 := "value1", "value2", "v3"
+	lfu := New(int64(len(k1+k2+v1+v2)), nil)
+	lfu.Add(k1, String(v1))
+	lfu.Add(k2, String(v2))
+	lfu.Get(k2)
+	// Peek 不增加访问频次，key1 仍然是频次最低的
+	for i := 0; i < 3; i++ {
+		if _, ok := lfu.Peek(k1); !ok {
+			t.Fatalf("peek key1 failed")
+		}
+	}
+	lfu.Add(k3, String(v3))
+	if _, ok := lfu.Peek(k1); ok {
+		t.Fatalf("peeked key1 should be the least frequently used")
+	}
+}
+
+func TestOnEvicted(t *testing.T) {
+	keys := make([]string, 0)
+	callback := func(key string, value Value, reason policy.EvictReason) {
+		keys = append(keys, key)
+	}
+	lfu := New(int64(10), callback)
+	lfu.Add("key1", String("123456"))
+	lfu.Add("k2", String("k2"))
+	lfu.Add("k3", String("k3"))
+	lfu.Get("k3")
+	lfu.Add("k4", String("k4"))
+
+	// 频次都为 1 时按 LRU 淘汰 key1，之后 k2 的频次比 k3 低
+	expect := []string{"key1", "k2"}
+
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s, got %s", expect, keys)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	lfu := New(int64(0), nil)
+	lfu.Add("key", String("1"))
+	lfu.Add("key", String("111"))
+
+	if lfu.Bytes() != int64(len("key")+len("111")) {
+		t.Fatal("expected 6 but got", lfu.Bytes())
+	}
+	if lfu.freqs.Len() != 1 || lfu.freqs.Front().Value.(*bucket).freq != 2 {
+		t.Fatal("updating key should increase its frequency")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	now := time.Now()
+	reasons := make(map[string]policy.EvictReason)
+	lfu := New(int64(0), func(key string, value Value, reason policy.EvictReason) {
+		reasons[key] = reason
+	})
+	lfu.now = func() time.Time { return now }
+	lfu.AddWithExpire("key1", String("1234"), now.Add(time.Second))
+	lfu.Add("key2", String("5678"))
+
+	if _, ok := lfu.Get("key1"); !ok {
+		t.Fatalf("cache hit key1 before expire failed")
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, ok := lfu.Get("key1"); ok {
+		t.Fatalf("key1 should be expired")
+	}
+	if _, ok := lfu.Get("key2"); !ok {
+		t.Fatalf("key2 without ttl should never expire")
+	}
+	if lfu.Len() != 1 || lfu.nbytes != int64(len("key2")+len("5678")) {
+		t.Fatalf("expired key1 should be removed, len=%d nbytes=%d", lfu.Len(), lfu.nbytes)
+	}
+	if reasons["key1"] != policy.EvictExpired {
+		t.Fatalf("expect key1 evicted by %s, got %s", policy.EvictExpired, reasons["key1"])
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	now := time.Now()
+	lfu := New(int64(0), nil)
+	lfu.now = func() time.Time { return now }
+	lfu.AddWithExpire("k1", String("v1"), now.Add(time.Second))
+	lfu.AddWithExpire("k2", String("v2"), now.Add(time.Minute))
+	lfu.Add("k3", String("v3"))
+
+	now = now.Add(2 * time.Second)
+	if n := lfu.RemoveExpired(); n != 1 || lfu.Len() != 2 {
+		t.Fatalf("expect k1 swept, got n=%d len=%d", n, lfu.Len())
+	}
+	now = now.Add(time.Hour)
+	if n := lfu.RemoveExpired(); n != 1 || lfu.Len() != 1 {
+		t.Fatalf("expect k2 swept, got n=%d len=%d", n, lfu.Len())
+	}
+}
+
+func TestRemove(t *testing.T) {
+	reasons := make(map[string]policy.EvictReason)
+	lfu := New(int64(0), func(key string, value Value, reason policy.EvictReason) {
+		reasons[key] = reason
+	})
+	lfu.Add("key1", String("1234"))
+	lfu.Add("key2", String("5678"))
+
+	if !lfu.Remove("key1") || lfu.Remove("key1") {
+		t.Fatalf("Remove key1 should report existence")
+	}
+	if _, ok := lfu.Get("key1"); ok || lfu.Len() != 1 {
+		t.Fatalf("key1 should be removed")
+	}
+	if reasons["key1"] != policy.EvictRemoved {
+		t.Fatalf("expect key1 evicted by %s, got %s", policy.EvictRemoved, reasons["key1"])
+	}
+}
+
+func TestClear(t *testing.T) {
+	lfu := New(int64(0), nil)
+	lfu.Add("key1", String("1234"))
+	lfu.Add("key2", String("5678"))
+	lfu.Clear()
+
+	if lfu.Len() != 0 {
+		t.Fatalf("Clear should remove all entries, got %d", lfu.Len())
+	}
+	if _, ok := lfu.Get("key2"); ok {
+		t.Fatalf("key2 should be cleared")
+	}
+}
+
+func BenchmarkZipf(b *testing.B) {
+	policytest.BenchmarkZipf(b, func(maxBytes int64) policy.Policy {
+		return New(maxBytes, nil)
+	})
+}
+
+func BenchmarkZipfScan(b *testing.B) {
+	policytest.BenchmarkZipfScan(b, func(maxBytes int64) policy.Policy {
+		return New(maxBytes, nil)
+	})
+}
+
+func TestRange(t *testing.T) {
+	now := time.Now()
+	c := New(int64(0), nil)
+	c.now = func() time.Time { return now }
+	c.Add("k1", String("v1"))
+	c.AddWithExpire("k2", String("v2"), now.Add(time.Second))
+	c.Add("k3", String("v3"))
+	c.Get("k1")
+	c.Get("k1")
+	now = now.Add(2 * time.Second)
+
+	var keys []string
+	c.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 2 || keys[0] != "k1" {
+		t.Fatalf("expect 2 unexpired keys starting with k1, got %v", keys)
+	}
+
+	keys = keys[:0]
+	c.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return false
+	})
+	if len(keys) != 1 {
+		t.Fatalf("Range should stop when fn returns false, got %v", keys)
+	}
+}
@@ -1,75 +1,155 @@
 package lru
 
-import "container/list"
+import (
+	"container/list"
+	"time"
+	"v8/geecache/policy"
+)
 
 // Cache is a LRU cache. It is not safe for concurrent access.
 type Cache struct {
-	maxBytes  int64                         // 允许使用的最大内存（字节）
-	nbytes    int64                         // 当前已使用的内存大小（字节）
-	ll        *list.List                    // 双向链表，存储缓存数据的访问顺序
-	cache     map[string]*list.Element      // 哈希表，键是字符串，值是链表节点指针
-	OnEvicted func(key string, value Value) // 当数据被移除时的回调函数
+	maxBytes  int64                                             // 允许使用的最大内存（字节），0 表示不限制
+	nbytes    int64                                             // 当前已使用的内存大小（字节）
+	ll        *list.List                                        // 双向链表，存储缓存数据的访问顺序
+	cache     map[string]*list.Element                          // 哈希表，键是字符串，值是链表节点指针
+	OnEvicted func(key string, value Value, reason EvictReason) // 当数据被移除时的回调函数
+
+	now func() time.Time // 获取当前时间，测试时可替换
 }
 
 type entry struct {
-	key   string
-	value Value
+	key    string
+	value  Value
+	expire time.Time // 过期时间，零值表示永不过期
 }
 
 // Value use Len to count how many bytes it takes
-type Value interface {
-	Len() int
-}
+type Value = policy.Value
+
+// EvictReason 说明一个条目为什么被移出缓存
+type EvictReason = policy.EvictReason
+
+const (
+	EvictCapacity = policy.EvictCapacity
+	EvictExpired  = policy.EvictExpired
+	EvictRemoved  = policy.EvictRemoved
+)
+
+var _ policy.Policy = (*Cache)(nil)
 
 // New is the Constructor of Cache
-func New(maxBytes int64, OnEvicted func(string, Value)) *Cache {
+func New(maxBytes int64, OnEvicted func(string, Value, EvictReason)) *Cache {
 	return &Cache{
 		maxBytes:  maxBytes,
 		ll:        list.New(),
 		cache:     make(map[string]*list.Element),
 		OnEvicted: OnEvicted,
+		now:       time.Now,
 	}
 }
 
 // Get look ups a key's value
+// 已过期的条目会在这里被惰性删除
 func (c *Cache) Get(key string) (value Value, ok bool) {
 	if ele, ok := c.cache[key]; ok {
-		c.ll.MoveToFront(ele)
 		kv := ele.Value.(*entry)
+		if policy.Expired(kv.expire, c.now()) {
+			c.removeElement(ele, EvictExpired)
+			return nil, false
+		}
+		c.ll.MoveToFront(ele)
 		return kv.value, true
 	}
 	return
 }
 
-// RemoveOldest removes the oldest item
+// Peek 查找 key 但不改变它的访问记录，已过期的条目视为不存在
+func (c *Cache) Peek(key string) (value Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return
+	}
+	kv := ele.Value.(*entry)
+	if policy.Expired(kv.expire, c.now()) {
+		return nil, false
+	}
+	return kv.value, true
+}
 
+// RemoveOldest removes the oldest item
 func (c *Cache) RemoveOldest() {
 	ele := c.ll.Back()
 	if ele != nil {
-		c.ll.Remove(ele)
-		kv := ele.Value.(*entry)
-		delete(c.cache, kv.key)
-		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value)
+		c.removeElement(ele, EvictCapacity)
+	}
+}
+
+// Remove removes the provided key from the cache.
+func (c *Cache) Remove(key string) bool {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, EvictRemoved)
+		return true
+	}
+	return false
+}
+
+// Clear purges all stored items from the cache.
+func (c *Cache) Clear() {
+	for ele := c.ll.Back(); ele != nil; ele = c.ll.Back() {
+		c.removeElement(ele, EvictRemoved)
+	}
+}
+
+// RemoveExpired 扫描整个缓存，删除所有已过期的条目，返回删除的个数
+func (c *Cache) RemoveExpired() int {
+	now := c.now()
+	n := 0
+	for ele := c.ll.Back(); ele != nil; {
+		prev := ele.Prev()
+		if policy.Expired(ele.Value.(*entry).expire, now) {
+			c.removeElement(ele, EvictExpired)
+			n++
 		}
+		ele = prev
+	}
+	return n
+}
+
+func (c *Cache) removeElement(ele *list.Element, reason EvictReason) {
+	c.ll.Remove(ele)
+	kv := ele.Value.(*entry)
+	delete(c.cache, kv.key)
+	c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value, reason)
 	}
 }
 
 // Add adds a value to the cache.
 func (c *Cache) Add(key string, value Value) {
-	if c.maxBytes <= 0 {
-		// 如果 maxBytes 为 0，则不添加数据
-		return
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithTTL adds a value that expires after ttl, ttl <= 0 means never expire.
+func (c *Cache) AddWithTTL(key string, value Value, ttl time.Duration) {
+	var expire time.Time
+	if ttl > 0 {
+		expire = c.now().Add(ttl)
 	}
+	c.AddWithExpire(key, value, expire)
+}
 
+// AddWithExpire adds a value that expires at the given time,
+// the zero time means never expire.
+func (c *Cache) AddWithExpire(key string, value Value, expire time.Time) {
 	if ele, ok := c.cache[key]; ok {
 		c.ll.MoveToFront(ele)
 		kv := ele.Value.(*entry)
 		c.nbytes += int64(value.Len()) - int64(kv.value.Len()) //新的减去旧的，如果变成了就是加上一个正值，反之就是负值
 		kv.value = value
+		kv.expire = expire
 	} else {
-		c.cache[key] = c.ll.PushFront(&entry{key, value})
+		c.cache[key] = c.ll.PushFront(&entry{key, value, expire})
 		c.nbytes += int64(len(key)) + int64(value.Len())
 	}
 
@@ -79,7 +159,27 @@ func (c *Cache) Add(key string, value Value) {
 	}
 }
 
+// Range calls fn for each unexpired entry from the most recently used one,
+// until fn returns false.
+func (c *Cache) Range(fn func(key string, value Value) bool) {
+	now := c.now()
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		kv := ele.Value.(*entry)
+		if policy.Expired(kv.expire, now) {
+			continue
+		}
+		if !fn(kv.key, kv.value) {
+			return
+		}
+	}
+}
+
 // Len the number of cache entries
 func (c *Cache) Len() int {
 	return c.ll.Len()
 }
+
+// Bytes the number of bytes taken by cache entries
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
@@ -3,6 +3,9 @@ package lru
 import (
 	"reflect"
 	"testing"
+	"time"
+	"v8/geecache/policy"
+	"v8/geecache/policy/policytest"
 )
 
 type String string
@@ -39,9 +42,25 @@ func TestRemoveoldest(t *testing.T) {
 
 }
 
+func TestPeek(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "k3"
+	v1, v2, v3 := "value1", "value2", "v3"
+	lru := New(int64(len(k1+k2+v1+v2)), nil)
+	lru.Add(k1, String(v1))
+	lru.Add(k2, String(v2))
+	// Peek 不会把 key1 移到队头，它仍然是最久未访问的
+	if v, ok := lru.Peek(k1); !ok || string(v.(String)) != v1 {
+		t.Fatalf("peek key1 failed")
+	}
+	lru.Add(k3, String(v3))
+	if _, ok := lru.Peek(k1); ok {
+		t.Fatalf("peeked key1 should still be the oldest")
+	}
+}
+
 func TestOnEvicted(t *testing.T) {
 	keys := make([]string, 0)
-	callback := func(key string, value Value) {
+	callback := func(key string, value Value, reason EvictReason) {
 		keys = append(keys, key)
 	}
 	lru := New(int64(10), callback)
@@ -63,7 +82,137 @@ func TestAdd(t *testing.T) {
 	lru.Add("key", String("1"))
 	lru.Add("key", String("111"))
 
-	if lru.nbytes != int64(len("key")+len("111")) {
-		t.Fatal("expected 6 but got", lru.nbytes)
+	if lru.Bytes() != int64(len("key")+len("111")) {
+		t.Fatal("expected 6 but got", lru.Bytes())
+	}
+}
+
+func TestExpire(t *testing.T) {
+	now := time.Now()
+	reasons := make(map[string]EvictReason)
+	lru := New(int64(0), func(key string, value Value, reason EvictReason) {
+		reasons[key] = reason
+	})
+	lru.now = func() time.Time { return now }
+	lru.AddWithTTL("key1", String("1234"), time.Second)
+	lru.Add("key2", String("5678"))
+
+	if _, ok := lru.Get("key1"); !ok {
+		t.Fatalf("cache hit key1 before expire failed")
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, ok := lru.Get("key1"); ok {
+		t.Fatalf("key1 should be expired")
+	}
+	if _, ok := lru.Get("key2"); !ok {
+		t.Fatalf("key2 without ttl should never expire")
+	}
+	if lru.Len() != 1 || lru.nbytes != int64(len("key2")+len("5678")) {
+		t.Fatalf("expired key1 should be removed, len=%d nbytes=%d", lru.Len(), lru.nbytes)
+	}
+	if reasons["key1"] != EvictExpired {
+		t.Fatalf("expect key1 evicted by %s, got %s", EvictExpired, reasons["key1"])
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	now := time.Now()
+	reasons := make(map[string]EvictReason)
+	lru := New(int64(len("k1v1k2v2")), func(key string, value Value, reason EvictReason) {
+		reasons[key] = reason
+	})
+	lru.now = func() time.Time { return now }
+	lru.AddWithExpire("k1", String("v1"), now.Add(time.Second))
+	lru.AddWithTTL("k2", String("v2"), time.Minute)
+	lru.Add("k3", String("v3"))
+
+	now = now.Add(2 * time.Second)
+	if n := lru.RemoveExpired(); n != 0 {
+		t.Fatalf("expect nothing to sweep, got %d", n)
+	}
+	if reasons["k1"] != EvictCapacity {
+		t.Fatalf("expect k1 evicted by %s, got %s", EvictCapacity, reasons["k1"])
+	}
+
+	now = now.Add(time.Hour)
+	if n := lru.RemoveExpired(); n != 1 || lru.Len() != 1 {
+		t.Fatalf("expect k2 swept, got n=%d len=%d", n, lru.Len())
+	}
+	if reasons["k2"] != EvictExpired {
+		t.Fatalf("expect k2 evicted by %s, got %s", EvictExpired, reasons["k2"])
+	}
+}
+
+func TestRemove(t *testing.T) {
+	reasons := make(map[string]EvictReason)
+	lru := New(int64(0), func(key string, value Value, reason EvictReason) {
+		reasons[key] = reason
+	})
+	lru.Add("key1", String("1234"))
+	lru.Add("key2", String("5678"))
+
+	if !lru.Remove("key1") || lru.Remove("key1") {
+		t.Fatalf("Remove key1 should report existence")
+	}
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 1 {
+		t.Fatalf("key1 should be removed")
+	}
+	if reasons["key1"] != EvictRemoved {
+		t.Fatalf("expect key1 evicted by %s, got %s", EvictRemoved, reasons["key1"])
+	}
+}
+
+func TestClear(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("key1", String("1234"))
+	lru.Add("key2", String("5678"))
+	lru.Clear()
+
+	if lru.Len() != 0 {
+		t.Fatalf("Clear should remove all entries, got %d", lru.Len())
+	}
+	if _, ok := lru.Get("key2"); ok {
+		t.Fatalf("key2 should be cleared")
+	}
+}
+
+func BenchmarkZipf(b *testing.B) {
+	policytest.BenchmarkZipf(b, func(maxBytes int64) policy.Policy {
+		return New(maxBytes, nil)
+	})
+}
+
+func BenchmarkZipfScan(b *testing.B) {
+	policytest.BenchmarkZipfScan(b, func(maxBytes int64) policy.Policy {
+		return New(maxBytes, nil)
+	})
+}
+
+func TestRange(t *testing.T) {
+	now := time.Now()
+	c := New(int64(0), nil)
+	c.now = func() time.Time { return now }
+	c.Add("k1", String("v1"))
+	c.AddWithExpire("k2", String("v2"), now.Add(time.Second))
+	c.Add("k3", String("v3"))
+	now = now.Add(2 * time.Second)
+
+	var keys []string
+	c.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 2 || keys[0] != "k3" {
+		t.Fatalf("expect 2 unexpired keys starting with k3, got %v", keys)
+	}
+
+	keys = keys[:0]
+	c.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return false
+	})
+	if len(keys) != 1 {
+		t.Fatalf("Range should stop when fn returns false, got %v", keys)
 	}
 }
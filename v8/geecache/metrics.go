@@ -0,0 +1,211 @@
+package geecache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// unaryStats 是统计 grpc 请求数和耗时的拦截器
+func (s *Server) unaryStats(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	s.RPC.Method(methodName(info.FullMethod)).observe(time.Since(start), err)
+	return resp, err
+}
+
+// streamStats 和 unaryStats 一样，耗时是整个流的时间
+func (s *Server) streamStats(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	s.RPC.Method(methodName(info.FullMethod)).observe(time.Since(start), err)
+	return err
+}
+
+// methodName 从 /geecachepb.GroupCache/Get 中取出 Get
+func methodName(fullMethod string) string {
+	return fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+}
+
+// MetricsHandler 返回以 Prometheus 文本格式输出所有 Group 和本节点统计的 HTTP 接口，
+// 一般挂载在 /metrics 上
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.WriteMetrics(w)
+	})
+}
+
+// WriteMetrics 以 Prometheus 文本格式把所有 Group 和本节点的统计写入 w
+func (s *Server) WriteMetrics(w io.Writer) error {
+	m := &metricWriter{w: bufio.NewWriter(w)}
+	writeGroupMetrics(m, allGroups())
+
+	health := s.Health()
+	m.gauge("geecache_server_up", "Whether the grpc server is serving.", boolValue(health.Serving))
+	m.gauge("geecache_server_healthy", "Whether the node is serving, not draining and registered.", boolValue(health.Healthy()))
+	m.gauge("geecache_server_peers", "Number of nodes on the hash ring, including this node.", int64(len(s.placement.Nodes())))
+
+	methods := s.RPC.Methods()
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	m.header("geecache_server_rpcs_total", "Total grpc requests served.", "counter")
+	for _, name := range names {
+		m.sample("geecache_server_rpcs_total", methods[name].Requests.Get(), "method", name)
+	}
+	m.header("geecache_server_rpc_errors_total", "Total grpc requests that returned an error.", "counter")
+	for _, name := range names {
+		m.sample("geecache_server_rpc_errors_total", methods[name].Errors.Get(), "method", name)
+	}
+	m.header("geecache_server_rpc_duration_seconds", "Latency of grpc requests served.", "histogram")
+	for _, name := range names {
+		m.histogram("geecache_server_rpc_duration_seconds", methods[name].Latency, "method", name)
+	}
+
+	m.counter("geecache_migration_rounds_total", "Rebalance rounds after ring changes.", s.Migration.Rounds.Get())
+	m.counter("geecache_migration_migrated_total", "Entries migrated to their new owners.", s.Migration.Migrated.Get())
+	m.counter("geecache_migration_failed_total", "Entries that failed to migrate and stayed local.", s.Migration.Failed.Get())
+	m.gauge("geecache_migration_pending", "Entries waiting to be migrated.", s.Migration.Pending.Get())
+	return m.flush()
+}
+
+// groupCounters 是每个 Group 导出的计数器，按名称顺序输出
+var groupCounters = []struct {
+	name, help string
+	value      func(*Stats) *AtomicInt
+}{
+	{"geecache_group_gets_total", "Total Get requests.", func(s *Stats) *AtomicInt { return &s.Gets }},
+	{"geecache_group_cache_hits_total", "Gets served from the main or hot cache.", func(s *Stats) *AtomicInt { return &s.CacheHits }},
+	{"geecache_group_hot_cache_hits_total", "Gets served from the hot cache.", func(s *Stats) *AtomicInt { return &s.HotCacheHits }},
+	{"geecache_group_hot_cache_adds_total", "Values fetched from peers and added to the hot cache.", func(s *Stats) *AtomicInt { return &s.HotCacheAdds }},
+	{"geecache_group_loads_total", "Gets that missed the cache.", func(s *Stats) *AtomicInt { return &s.Loads }},
+	{"geecache_group_loads_deduped_total", "Loads actually executed after singleflight deduplication.", func(s *Stats) *AtomicInt { return &s.LoadsDeduped }},
+	{"geecache_group_peer_loads_total", "Values fetched from peers.", func(s *Stats) *AtomicInt { return &s.PeerLoads }},
+	{"geecache_group_peer_errors_total", "Failed fetches from peers.", func(s *Stats) *AtomicInt { return &s.PeerErrors }},
+	{"geecache_group_local_loads_total", "Values loaded from the data source.", func(s *Stats) *AtomicInt { return &s.LocalLoads }},
+	{"geecache_group_local_load_errors_total", "Failed loads from the data source.", func(s *Stats) *AtomicInt { return &s.LocalLoadErrs }},
+}
+
+// writeGroupMetrics 输出 groups 的计数器和缓存统计，groups 按名称排序
+func writeGroupMetrics(m *metricWriter, groups []*Group) {
+	sort.Slice(groups, func(i, j int) bool { return groups[i].name < groups[j].name })
+	for _, c := range groupCounters {
+		m.header(c.name, c.help, "counter")
+		for _, g := range groups {
+			m.sample(c.name, c.value(&g.Stats).Get(), "group", g.name)
+		}
+	}
+
+	caches := []CacheType{MainCache, HotCache}
+	stats := make(map[*Group][]CacheStats, len(groups))
+	for _, g := range groups {
+		for _, which := range caches {
+			stats[g] = append(stats[g], g.CacheStats(which))
+		}
+	}
+	cacheMetrics := []struct {
+		name, help, typ string
+		value           func(CacheStats) int64
+	}{
+		{"geecache_cache_bytes", "Bytes taken by keys and values in the cache.", "gauge", func(st CacheStats) int64 { return st.Bytes }},
+		{"geecache_cache_items", "Entries in the cache.", "gauge", func(st CacheStats) int64 { return st.Items }},
+		{"geecache_cache_evictions_total", "Entries evicted because the cache was full.", "counter", func(st CacheStats) int64 { return st.Evictions }},
+		{"geecache_cache_expired_total", "Expired entries removed from the cache.", "counter", func(st CacheStats) int64 { return st.Expired }},
+	}
+	for _, c := range cacheMetrics {
+		m.header(c.name, c.help, c.typ)
+		for _, g := range groups {
+			for i, which := range caches {
+				m.sample(c.name, c.value(stats[g][i]), "group", g.name, "cache", which.String())
+			}
+		}
+	}
+}
+
+// metricWriter 输出 Prometheus 文本格式，记录第一个写入错误
+type metricWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (m *metricWriter) printf(format string, args ...any) {
+	if m.err == nil {
+		_, m.err = fmt.Fprintf(m.w, format, args...)
+	}
+}
+
+func (m *metricWriter) header(name, help, typ string) {
+	m.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (m *metricWriter) counter(name, help string, value int64) {
+	m.header(name, help, "counter")
+	m.sample(name, value)
+}
+
+func (m *metricWriter) gauge(name, help string, value int64) {
+	m.header(name, help, "gauge")
+	m.sample(name, value)
+}
+
+// sample 输出一个样本，labels 是成对的名称和值
+func (m *metricWriter) sample(name string, value int64, labels ...string) {
+	m.printf("%s%s %d\n", name, formatLabels(labels), value)
+}
+
+// histogram 输出直方图的 _bucket、_sum 和 _count，header 由调用方输出
+func (m *metricWriter) histogram(name string, h *Histogram, labels ...string) {
+	bounds, cumulative := h.Buckets()
+	for i, bound := range bounds {
+		le := strconv.FormatFloat(bound, 'g', -1, 64)
+		m.printf("%s_bucket%s %d\n", name, formatLabels(append(labels, "le", le)), cumulative[i])
+	}
+	count := h.Count()
+	m.printf("%s_bucket%s %d\n", name, formatLabels(append(labels, "le", "+Inf")), count)
+	m.printf("%s_sum%s %s\n", name, formatLabels(labels), strconv.FormatFloat(h.Sum().Seconds(), 'g', -1, 64))
+	m.printf("%s_count%s %d\n", name, formatLabels(labels), count)
+}
+
+func (m *metricWriter) flush() error {
+	if m.err != nil {
+		return m.err
+	}
+	return m.w.Flush()
+}
+
+// labelEscaper 转义 label 值中的反斜杠、双引号和换行
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"v8/geecache/geecachepb"
+)
+
+func TestGroupStats(t *testing.T) {
+	gee := NewGroup("stats", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		if key == "bad" {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return []byte("db-" + key), nil
+	}))
+	gee.RegisterPeers(&fakePicker{peer: &fakePeer{values: map[string]string{"Jack": "589"}}, remote: map[string]bool{"Jack": true, "Sam": true}})
+
+	for _, key := range []string{"Tom", "Tom", "bad", "Jack", "Sam"} {
+		gee.Get(context.Background(), key)
+	}
+	st := &gee.Stats
+	expect := map[string]int64{
+		"gets": 5, "hits": 1, "loads": 4, "deduped": 4,
+		"peer loads": 1, "peer errors": 1, "local loads": 2, "local errors": 1,
+	}
+	got := map[string]int64{
+		"gets": st.Gets.Get(), "hits": st.CacheHits.Get(), "loads": st.Loads.Get(), "deduped": st.LoadsDeduped.Get(),
+		"peer loads": st.PeerLoads.Get(), "peer errors": st.PeerErrors.Get(), "local loads": st.LocalLoads.Get(), "local errors": st.LocalLoadErrs.Get(),
+	}
+	for name, n := range expect {
+		if got[name] != n {
+			t.Errorf("expect %s %d, got %d", name, n, got[name])
+		}
+	}
+
+	// Tom 和 Sam 从数据源加载后放入 mainCache
+	main := gee.CacheStats(MainCache)
+	if main.Items != 2 || main.Bytes != int64(len("Tom")+len("db-Tom")+len("Sam")+len("db-Sam")) {
+		t.Fatalf("unexpected main cache stats %+v", main)
+	}
+}
+
+func TestCacheStatsEvictions(t *testing.T) {
+	c := newCache(10, 1, PolicyLRU)
+	c.add("k1", ByteView{b: []byte("1234")})
+	c.add("k2", ByteView{b: []byte("1234")})
+	c.add("k3", ByteView{b: []byte("1")}) // 淘汰 k1
+	c.add("k4", ByteView{b: []byte("1"), e: time.Now().Add(-time.Second)})
+	c.get("k4") // 已经过期，被清理
+	st := c.stats()
+	if st.Evictions != 2 || st.Expired != 1 || st.Items != 1 || st.Bytes != 3 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{0.01, 0.1})
+	for _, d := range []time.Duration{time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond, time.Second} {
+		h.Observe(d)
+	}
+	bounds, cumulative := h.Buckets()
+	if len(bounds) != 2 || cumulative[0] != 2 || cumulative[1] != 3 || h.Count() != 4 {
+		t.Fatalf("unexpected buckets %v %v count=%d", bounds, cumulative, h.Count())
+	}
+	if h.Sum() != 1061*time.Millisecond {
+		t.Fatalf("unexpected sum %v", h.Sum())
+	}
+}
+
+// sampleLine 匹配 Prometheus 文本格式中的一个样本
+var sampleLine = regexp.MustCompile(`^[a-z_]+(\{[a-z_]+="[^"]*"(,[a-z_]+="[^"]*")*\})? [-+0-9.e]+$`)
+
+func TestServerMetrics(t *testing.T) {
+	gee := NewGroup("metrics", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}))
+	svr := newListenServer(t)
+	serveServer(t, svr)
+	defer svr.Stop()
+
+	client := NewClient("geecache/peer", svr.addr)
+	defer client.Close()
+	if err := client.Fetch(context.Background(), &geecachepb.Request{Group: gee.name, Key: "Tom"}, &geecachepb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	client.Fetch(context.Background(), &geecachepb.Request{Group: "unknown", Key: "Tom"}, &geecachepb.Response{})
+
+	w := httptest.NewRecorder()
+	svr.MetricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if !strings.HasPrefix(line, "# ") && !sampleLine.MatchString(line) {
+			t.Fatalf("invalid sample line %q", line)
+		}
+	}
+	for _, expect := range []string{
+		`geecache_group_gets_total{group="metrics"} 1`,
+		`geecache_group_local_loads_total{group="metrics"} 1`,
+		`geecache_cache_items{group="metrics",cache="main"} 1`,
+		`geecache_server_rpcs_total{method="Get"} 2`,
+		`geecache_server_rpc_errors_total{method="Get"} 1`,
+		`geecache_server_rpc_duration_seconds_bucket{method="Get",le="+Inf"} 2`,
+		`geecache_server_rpc_duration_seconds_count{method="Get"} 2`,
+		"# TYPE geecache_server_rpc_duration_seconds histogram",
+		"geecache_server_up 1",
+	} {
+		if !strings.Contains(body, expect+"\n") {
+			t.Errorf("metrics should contain %q", expect)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package geecache
 
-import "v8/geecache/geecachepb"
+import (
+	"context"
+	"v8/geecache/geecachepb"
+)
 
 // PeerPicker is the interface that must be implemented to locate
 // the peer that owns a specific key.
@@ -9,11 +12,61 @@ type PeerPicker interface {
 	PickPeer(key string) (peer Fetcher, ok bool)
 }
 
+// ReplicaPicker 定义了为一个 key 选出 n 个副本节点的能力
+type ReplicaPicker interface {
+	// PickReplicas 按哈希环上的顺序返回 key 的远端副本节点，不包括本节点。
+	// self 是本节点在全部 n 个副本中的位置，0 表示本节点是主副本，-1 表示本节点不是副本
+	PickReplicas(key string, n int) (peers []Fetcher, self int)
+}
+
+// LoadPicker 定义了按节点负载选择读取节点的能力，
+// 实现了这个接口的 PeerPicker 在读取 key 时会代替 PickPeer 使用
+type LoadPicker interface {
+	// PickLoaded 和 PickPeer 一样选出 key 的节点，owner 过载时可以选择哈希环上的其他节点。
+	// ok 为 false 表示由本节点加载，无论选中哪个节点，done 都要在请求结束后调用
+	PickLoaded(key string) (peer Fetcher, ok bool, done func())
+}
+
+// FallbackPicker 定义了首选节点请求失败后选择备选节点的能力，
+// 比如按可用区路由时本可用区的 owner 不可用，就转发给其他可用区的 owner
+type FallbackPicker interface {
+	// PickFallback 返回 key 的备选节点，failed 是请求失败的节点。
+	// ok 为 false 表示没有备选节点，由本节点加载
+	PickFallback(key string, failed Fetcher) (peer Fetcher, ok bool)
+}
+
+// PeerLister 定义了列出所有远端节点的能力，用于 Purge 这类需要通知全部节点的操作
+type PeerLister interface {
+	ListPeers() []Fetcher
+}
+
 // 接口 PeerGetter 的 Get() 方法用于从对应 group 查找缓存值。PeerGetter 就对应于上述流程中的 HTTP 客户端。
 
 // Fetcher 定义了从远端获取缓存的能力
 // 所以每个Peer应实现这个接口
 type Fetcher interface {
 	//Get(group string, key string) ([]byte, error)
-	Fetch(in *geecachepb.Request, out *geecachepb.Response) error
+	// ctx 的 deadline 和取消信号会传递给远端节点
+	Fetch(ctx context.Context, in *geecachepb.Request, out *geecachepb.Response) error
+}
+
+// Updater 定义了修改远端缓存的能力
+// 支持写操作的 Peer 应同时实现 Fetcher 和这个接口
+type Updater interface {
+	Set(ctx context.Context, in *geecachepb.SetRequest) error
+	Remove(ctx context.Context, in *geecachepb.Request) error
+	Purge(ctx context.Context, in *geecachepb.PurgeRequest) error
+	Invalidate(ctx context.Context, in *geecachepb.InvalidateRequest) error
+}
+
+// Migrator 定义了把缓存条目迁移到远端节点的能力
+type Migrator interface {
+	// Migrate 以流的方式发送 entries 中的条目，直到 entries 被关闭，
+	// 返回远端写入的条目个数
+	Migrate(ctx context.Context, entries <-chan *geecachepb.SetRequest) (int64, error)
+}
+
+// Invalidator 定义了向集群中所有节点广播失效消息的能力
+type Invalidator interface {
+	BroadcastInvalidate(group, key string) error
 }
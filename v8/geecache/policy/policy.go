@@ -0,0 +1,69 @@
+// Package policy 定义了缓存淘汰策略的公共接口，
+// lru、lfu、arc、tinylfu 等包都实现了这里的 Policy。
+package policy
+
+import "time"
+
+// Value use Len to count how many bytes it takes
+type Value interface {
+	Len() int
+}
+
+// EvictReason 说明一个条目为什么被移出缓存
+type EvictReason int
+
+const (
+	// EvictCapacity 内存超出 maxBytes，被淘汰策略挑中移除
+	EvictCapacity EvictReason = iota
+	// EvictExpired 条目已过期被清理
+	EvictExpired
+	// EvictRemoved 条目被调用方显式删除
+	EvictRemoved
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// OnEvicted 是条目被移除时的回调
+type OnEvicted func(key string, value Value, reason EvictReason)
+
+// Policy 是一个按字节数限制容量的缓存淘汰策略。
+// 实现不需要是并发安全的，由调用方加锁。
+type Policy interface {
+	// Get 查找 key，已过期的条目视为不存在
+	Get(key string) (value Value, ok bool)
+	// Peek 和 Get 一样查找 key，但不改变条目的访问记录，也不清理过期的条目，
+	// 用于迁移、比较等内部扫描，避免把扫描到的条目都当成热点
+	Peek(key string) (value Value, ok bool)
+	// Add 添加一个永不过期的条目
+	Add(key string, value Value)
+	// AddWithExpire 添加一个在 expire 时刻过期的条目，零值表示永不过期
+	AddWithExpire(key string, value Value, expire time.Time)
+	// Remove 删除 key，返回 key 是否存在
+	Remove(key string) bool
+	// Clear 删除所有条目
+	Clear()
+	// RemoveExpired 清理所有已过期的条目，返回清理的个数
+	RemoveExpired() int
+	// Len 返回条目个数
+	Len() int
+	// Bytes 返回条目的 key 和 value 一共占用的字节数
+	Bytes() int64
+	// Range 依次对每个未过期的条目调用 fn，fn 返回 false 时停止遍历。
+	// 越热的条目越先被遍历到，遍历不会改变条目的访问记录，fn 中不能修改缓存
+	Range(fn func(key string, value Value) bool)
+}
+
+// Expired 判断过期时间为 expire 的条目在 now 时刻是否已经过期
+func Expired(expire, now time.Time) bool {
+	return !expire.IsZero() && !now.Before(expire)
+}
@@ -0,0 +1,69 @@
+// Package policytest 提供各淘汰策略共用的基准测试，
+// 通过回放 Zipf 分布的访问序列来比较命中率。
+package policytest
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"v8/geecache/policy"
+)
+
+const (
+	keySpace   = 100000 // 不同 key 的个数
+	traceLen   = 1 << 20
+	valueBytes = 16
+	cacheItems = keySpace / 100 // 缓存大约能放下 1% 的 key
+)
+
+type value []byte
+
+func (v value) Len() int {
+	return len(v)
+}
+
+// zipfTrace 生成一段服从 Zipf 分布的 key 访问序列，scanEvery > 0 时
+// 每隔 scanEvery 次访问插入一段对冷 key 的顺序扫描
+func zipfTrace(scanEvery int) []string {
+	r := rand.New(rand.NewSource(1))
+	z := rand.NewZipf(r, 1.01, 1, keySpace-1)
+	trace := make([]string, 0, traceLen)
+	scan := keySpace
+	for len(trace) < traceLen {
+		trace = append(trace, strconv.FormatUint(z.Uint64(), 10))
+		if scanEvery > 0 && len(trace)%scanEvery == 0 {
+			for i := 0; i < cacheItems && len(trace) < traceLen; i++ {
+				trace = append(trace, strconv.Itoa(scan))
+				scan++
+			}
+		}
+	}
+	return trace
+}
+
+func replay(b *testing.B, trace []string, newPolicy func(maxBytes int64) policy.Policy) {
+	// 每个条目大约占用 key 长度 + valueBytes 个字节
+	p := newPolicy(int64(cacheItems * (valueBytes + 5)))
+	v := make(value, valueBytes)
+	hits := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := trace[i%len(trace)]
+		if _, ok := p.Get(key); ok {
+			hits++
+		} else {
+			p.Add(key, v)
+		}
+	}
+	b.ReportMetric(float64(hits)/float64(b.N), "hit-ratio")
+}
+
+// BenchmarkZipf 回放 Zipf 访问序列并报告命中率
+func BenchmarkZipf(b *testing.B, newPolicy func(maxBytes int64) policy.Policy) {
+	replay(b, zipfTrace(0), newPolicy)
+}
+
+// BenchmarkZipfScan 在 Zipf 访问序列中穿插顺序扫描，用于衡量策略的抗扫描能力
+func BenchmarkZipfScan(b *testing.B, newPolicy func(maxBytes int64) policy.Policy) {
+	replay(b, zipfTrace(cacheItems*4), newPolicy)
+}
@@ -0,0 +1,248 @@
+package geecache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"time"
+	"v8/geecache/consistenthash"
+	"v8/geecache/geecachepb"
+)
+
+var (
+	migrateRate  = 1000        // 每秒最多迁移的条目个数，不大于 0 时不限速
+	migrateDelay = time.Second // 节点变化后等待一段时间再迁移，合并短时间内的多次变化
+)
+
+// MigrationStats 记录哈希环变化后 key 迁移的进度
+type MigrationStats struct {
+	Rounds   AtomicInt // 执行过的迁移轮数
+	Pending  AtomicInt // 已经找出、但还没有发送的条目个数
+	Migrated AtomicInt // 新节点确认写入的条目个数
+	Failed   AtomicInt // 迁移失败、仍留在本节点的条目个数
+}
+
+// scheduleRebalance 通知后台协程哈希环发生了变化，
+// 第一次调用时才启动后台协程，连续多次通知只会触发一轮迁移
+func (s *Server) scheduleRebalance() {
+	s.rebalanceOnce.Do(func() {
+		s.rebalanceSignal = make(chan struct{}, 1)
+		go s.runRebalance()
+	})
+	select {
+	case s.rebalanceSignal <- struct{}{}:
+	default:
+		// 已经有一轮在等待执行
+	}
+}
+
+func (s *Server) runRebalance() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.rebalanceSignal:
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(migrateDelay):
+		}
+		// 等待期间的通知已经包含在这一轮中
+		select {
+		case <-s.rebalanceSignal:
+		default:
+		}
+		s.rebalance(s.ctx)
+	}
+}
+
+// rebalance 找出本节点缓存中现在属于其他节点的 key，把它们迁移到新的节点，
+// 迁移成功后从本节点删除
+func (s *Server) rebalance(ctx context.Context) {
+	s.Migration.Rounds.Add(1)
+	moves := s.collectMoves()
+	for _, entries := range moves {
+		s.Migration.Pending.Add(int64(len(entries)))
+	}
+
+	limiter := newRateLimiter(migrateRate)
+	for addr, entries := range moves {
+		s.mux.Lock()
+		client, ok := s.clients[addr]
+		s.mux.Unlock()
+		if !ok {
+			// 迁移开始前目标节点又下线了，下一轮会重新计算
+			s.Migration.Pending.Add(-int64(len(entries)))
+			s.Migration.Failed.Add(int64(len(entries)))
+			continue
+		}
+		n, err := s.migrateTo(ctx, client, entries, limiter)
+		if err != nil {
+			log.Printf("[geecache_svr %s] migrate %d keys to %s failed: %v", s.addr, len(entries), addr, err)
+			s.Migration.Failed.Add(int64(len(entries)))
+			continue
+		}
+		s.Migration.Migrated.Add(n)
+		for _, e := range entries {
+			if g := GetGroup(e.GetGroup()); g != nil {
+				// 迁移期间 key 可能被重新写入，只删除仍然是迁移出去的那个值的 key
+				g.mainCache.removeIf(e.GetKey(), func(value ByteView) bool { return migrated(e, value) })
+			}
+		}
+		log.Printf("[geecache_svr %s] migrated %d keys to %s", s.addr, n, addr)
+	}
+}
+
+// collectMoves 按新的归属节点对需要迁移的条目分组，
+// 开启副本的 Group 中本节点仍是副本之一的 key 不需要迁移
+func (s *Server) collectMoves() map[string][]*geecachepb.SetRequest {
+	moves := make(map[string][]*geecachepb.SetRequest)
+	for _, g := range allGroups() {
+		g.mainCache.rangeEntries(func(key string, value ByteView) bool {
+			owner := s.ownerOf(key, g.replicas)
+			if owner == "" {
+				return true
+			}
+			req := &geecachepb.SetRequest{Group: g.name, Key: key, Value: value.b}
+			if !value.e.IsZero() {
+				req.Expire = value.e.UnixNano()
+			}
+			moves[owner] = append(moves[owner], req)
+			return true
+		})
+	}
+	return moves
+}
+
+// migrated 判断 value 是否就是 e 迁移出去的值
+func migrated(e *geecachepb.SetRequest, value ByteView) bool {
+	var expire int64
+	if !value.e.IsZero() {
+		expire = value.e.UnixNano()
+	}
+	return expire == e.GetExpire() && bytes.Equal(value.b, e.GetValue())
+}
+
+// ownerOf 返回 key 的主副本节点，按可用区路由时只在本可用区中选择，
+// 本节点是 key 的 replicas 个副本之一时返回空
+func (s *Server) ownerOf(key string, replicas int) string {
+	return ownerIn(s.route(key), s.addr, key, replicas)
+}
+
+// ownerIn 返回 key 在 p 中的主副本节点，self 是 key 的 replicas 个副本之一时返回空
+func ownerIn(p consistenthash.Placement, self, key string, replicas int) string {
+	owners := p.GetN(key, max(replicas, 1))
+	for _, addr := range owners {
+		if addr == self {
+			return ""
+		}
+	}
+	if len(owners) == 0 {
+		return ""
+	}
+	return owners[0]
+}
+
+// migrateTo 按 limiter 的速度把 entries 发送给 peer，返回对方写入的条目个数。
+// 只要有一条没有发送成功就返回错误，此时所有条目都保留在本节点
+func (s *Server) migrateTo(ctx context.Context, peer Migrator, entries []*geecachepb.SetRequest, limiter *rateLimiter) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		n   int64
+		err error
+	}
+	ch := make(chan *geecachepb.SetRequest)
+	done := make(chan result, 1)
+	go func() {
+		n, err := peer.Migrate(ctx, ch)
+		// 出错时让下面的发送循环退出
+		cancel()
+		done <- result{n, err}
+	}()
+
+	sent := 0
+send:
+	for _, e := range entries {
+		if limiter.wait(ctx) != nil {
+			break
+		}
+		select {
+		case ch <- e:
+			sent++
+			s.Migration.Pending.Add(-1)
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(ch)
+	s.Migration.Pending.Add(-int64(len(entries) - sent))
+
+	r := <-done
+	if r.err == nil && sent < len(entries) {
+		r.err = ctx.Err()
+	}
+	return r.n, r.err
+}
+
+// Migrate 实现GroupCache service的Migrate接口，写入其他节点迁移过来的条目。
+// 本节点已经有的 key 不会被覆盖，它们可能比迁移过来的更新
+func (s *Server) Migrate(stream geecachepb.GroupCache_MigrateServer) error {
+	var n int64
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			log.Printf("[geecache_svr %s] Recv RPC Migrate - %d keys", s.addr, n)
+			return stream.SendAndClose(&geecachepb.MigrateResponse{Received: n})
+		}
+		if err != nil {
+			return err
+		}
+		n++
+		group := GetGroup(in.GetGroup())
+		if group == nil || in.GetKey() == "" {
+			continue
+		}
+		if _, ok := group.mainCache.peek(in.GetKey()); ok {
+			continue
+		}
+		group.setLocally(in.GetKey(), viewFromSetRequest(in))
+	}
+}
+
+// rateLimiter 让连续的操作之间至少间隔 interval
+type rateLimiter struct {
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter 创建每秒最多允许 perSecond 次操作的 rateLimiter，不大于 0 时不限速
+func newRateLimiter(perSecond int) *rateLimiter {
+	if perSecond <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{interval: time.Second / time.Duration(perSecond)}
+}
+
+// wait 阻塞到允许下一次操作，ctx 取消时返回错误
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l.interval <= 0 {
+		return ctx.Err()
+	}
+	now := time.Now()
+	if d := l.next.Sub(now); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		now = l.next
+	}
+	l.next = now.Add(l.interval)
+	return nil
+}
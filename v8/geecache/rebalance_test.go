@@ -0,0 +1,204 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+	"v8/geecache/geecachepb"
+)
+
+// migratePeer 记录通过 Migrate 收到的条目，fail 为 true 时拒绝迁移，
+// onRecv 不为空时每收到一个条目调用一次
+type migratePeer struct {
+	geecachepb.UnimplementedGroupCacheServer
+	mux    sync.Mutex
+	fail   bool
+	keys   map[string]string // group/key -> value
+	onRecv func(in *geecachepb.SetRequest)
+}
+
+func (p *migratePeer) Migrate(stream geecachepb.GroupCache_MigrateServer) error {
+	var n int64
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&geecachepb.MigrateResponse{Received: n})
+		}
+		if err != nil {
+			return err
+		}
+		p.mux.Lock()
+		if p.fail {
+			p.mux.Unlock()
+			return fmt.Errorf("migration rejected")
+		}
+		p.keys[in.GetGroup()+"/"+in.GetKey()] = string(in.GetValue())
+		p.mux.Unlock()
+		if p.onRecv != nil {
+			p.onRecv(in)
+		}
+		n++
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	gee := NewGroup("rebalance", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}))
+	peer := &migratePeer{keys: make(map[string]string)}
+	peerAddr := startTestPeer(t, peer)
+
+	svr, err := NewServer("127.0.0.1:9010")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	svr.SetPeers(svr.addr)
+	for i := 0; i < 50; i++ {
+		gee.Get(context.Background(), fmt.Sprintf("key%d", i))
+	}
+
+	// 新节点加入，一部分 key 的归属发生变化
+	svr.SetPeers(svr.addr, peerAddr)
+	var moved, kept []string
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if svr.placement.Get(key) == peerAddr {
+			moved = append(moved, key)
+		} else {
+			kept = append(kept, key)
+		}
+	}
+	if len(moved) == 0 || len(kept) == 0 {
+		t.Fatalf("expect keys on both peers, moved=%d kept=%d", len(moved), len(kept))
+	}
+
+	svr.rebalance(context.Background())
+	for _, key := range moved {
+		if peer.keys["rebalance/"+key] != "db-"+key {
+			t.Fatalf("%s should be migrated to the new owner", key)
+		}
+		if _, ok := gee.mainCache.get(key); ok {
+			t.Fatalf("%s should be removed locally after migration", key)
+		}
+	}
+	for _, key := range kept {
+		if _, ok := peer.keys["rebalance/"+key]; ok {
+			t.Fatalf("%s still belongs to this node", key)
+		}
+		if _, ok := gee.mainCache.get(key); !ok {
+			t.Fatalf("%s should stay in local cache", key)
+		}
+	}
+	if svr.Migration.Rounds.Get() != 1 || svr.Migration.Pending.Get() != 0 || svr.Migration.Migrated.Get() < int64(len(moved)) {
+		t.Fatalf("unexpected migration stats rounds=%v pending=%v migrated=%v",
+			&svr.Migration.Rounds, &svr.Migration.Pending, &svr.Migration.Migrated)
+	}
+}
+
+func TestRebalanceFailure(t *testing.T) {
+	gee := NewGroup("rebalance-fail", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}))
+	peer := &migratePeer{fail: true, keys: make(map[string]string)}
+	peerAddr := startTestPeer(t, peer)
+
+	svr, err := NewServer("127.0.0.1:9011")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	svr.SetPeers(peerAddr)
+	gee.mainCache.add("Tom", ByteView{b: []byte("630")})
+
+	svr.rebalance(context.Background())
+	if _, ok := gee.mainCache.get("Tom"); !ok {
+		t.Fatalf("keys should stay local when migration fails")
+	}
+	if svr.Migration.Failed.Get() == 0 || svr.Migration.Pending.Get() != 0 {
+		t.Fatalf("unexpected migration stats failed=%v pending=%v", &svr.Migration.Failed, &svr.Migration.Pending)
+	}
+}
+
+func TestRebalanceConcurrentSet(t *testing.T) {
+	gee := NewGroup("rebalance-set", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}))
+	// 迁移 Tom 的过程中本节点又写入了新的 Tom
+	peer := &migratePeer{keys: make(map[string]string), onRecv: func(in *geecachepb.SetRequest) {
+		if in.GetKey() == "Tom" {
+			gee.setLocally("Tom", ByteView{b: []byte("new")})
+		}
+	}}
+	peerAddr := startTestPeer(t, peer)
+
+	svr, err := NewServer("127.0.0.1:9013")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	svr.SetPeers(peerAddr)
+	gee.mainCache.add("Tom", ByteView{b: []byte("630")})
+	gee.mainCache.add("Jack", ByteView{b: []byte("589"), e: time.Now().Add(time.Hour)})
+
+	svr.rebalance(context.Background())
+	if v, ok := gee.mainCache.get("Tom"); !ok || v.String() != "new" {
+		t.Fatalf("Tom set during migration should be kept, got %v %v", v, ok)
+	}
+	if _, ok := gee.mainCache.get("Jack"); ok {
+		t.Fatalf("migrated Jack should be removed locally")
+	}
+}
+
+func TestServerMigrate(t *testing.T) {
+	gee := NewGroup("migrate-recv", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}))
+	gee.mainCache.add("Jack", ByteView{b: []byte("fresh")})
+	svr, err := NewServer("127.0.0.1:9012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient("geecache/peer", startTestPeer(t, svr))
+	defer client.Close()
+
+	expire := time.Now().Add(time.Hour)
+	entries := make(chan *geecachepb.SetRequest, 3)
+	entries <- &geecachepb.SetRequest{Group: gee.name, Key: "Tom", Value: []byte("630"), Expire: expire.UnixNano()}
+	entries <- &geecachepb.SetRequest{Group: gee.name, Key: "Jack", Value: []byte("stale")}
+	entries <- &geecachepb.SetRequest{Group: "unknown", Key: "Sam", Value: []byte("567")}
+	close(entries)
+
+	n, err := client.Migrate(context.Background(), entries)
+	if err != nil || n != 3 {
+		t.Fatalf("expect 3 entries received, got %d err=%v", n, err)
+	}
+	if v, ok := gee.mainCache.get("Tom"); !ok || v.String() != "630" || !v.Expire().Equal(time.Unix(0, expire.UnixNano())) {
+		t.Fatalf("Tom should be migrated with its expiry, got %v", v)
+	}
+	if v, _ := gee.mainCache.get("Jack"); v.String() != "fresh" {
+		t.Fatalf("existing Jack should not be overwritten, got %s", v)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(100)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("5 waits at 100/s should take at least 40ms, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx); err == nil {
+		t.Fatalf("wait should fail after ctx is cancelled")
+	}
+}
@@ -0,0 +1,91 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"os"
+	"time"
+)
+
+// Config 描述如何连接 etcd，以及服务在 etcd 中如何注册
+type Config struct {
+	Endpoints   []string      // etcd 节点地址，如 127.0.0.1:2379
+	DialTimeout time.Duration // 连接 etcd 的超时时间
+	TLS         *tls.Config   // 为 nil 时不使用 TLS
+	Username    string        // etcd 开启认证时的用户名
+	Password    string
+	LeaseTTL    int64  // 注册租约的有效期（秒），节点失联超过这个时间后会被摘除
+	Prefix      string // 服务注册的 key 前缀，key 的格式为 Prefix/addr
+}
+
+// DefaultConfig 返回连接本机 etcd 的默认配置
+func DefaultConfig() Config {
+	return Config{
+		Endpoints:   []string{"127.0.0.1:2379"},
+		DialTimeout: 5 * time.Second,
+		LeaseTTL:    5,
+		Prefix:      serviceEndpointKeyPrefix,
+	}
+}
+
+// WithDefaults 返回用默认值填充了未设置字段的配置
+func (c Config) WithDefaults() Config {
+	def := DefaultConfig()
+	if len(c.Endpoints) == 0 {
+		c.Endpoints = def.Endpoints
+	}
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = def.DialTimeout
+	}
+	if c.LeaseTTL <= 0 {
+		c.LeaseTTL = def.LeaseTTL
+	}
+	if c.Prefix == "" {
+		c.Prefix = def.Prefix
+	}
+	return c
+}
+
+// KeyPrefix 返回用于 watch 所有服务节点的 key 前缀
+func (c Config) KeyPrefix() string {
+	return c.WithDefaults().Prefix + "/"
+}
+
+// NewClient 根据配置创建一个 etcd client
+func (c Config) NewClient() (*clientv3.Client, error) {
+	c = c.WithDefaults()
+	return clientv3.New(clientv3.Config{
+		Endpoints:   c.Endpoints,
+		DialTimeout: c.DialTimeout,
+		TLS:         c.TLS,
+		Username:    c.Username,
+		Password:    c.Password,
+	})
+}
+
+// LoadTLS 根据证书文件创建 TLS 配置，caFile 用于校验 etcd 的证书，
+// certFile 和 keyFile 是客户端证书，不需要双向认证时可以为空
+func LoadTLS(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file failed: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate failed: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -1,5 +1,150 @@
 package registry
 
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
 var (
 	serviceEndpointKeyPrefix = "geecache"
+	defaultPollInterval      = 5 * time.Second // 需要轮询的 Discovery 默认的刷新间隔
+
+	registerBackoffMin = 500 * time.Millisecond // 注册失败后第一次重试前等待的时间
+	registerBackoffMax = 30 * time.Second       // 重试等待时间的上限，每次失败翻倍
+)
+
+// EventType 是节点变化的类型
+type EventType int
+
+const (
+	EventPut    EventType = iota // 节点上线
+	EventDelete                  // 节点下线
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPut:
+		return "put"
+	case EventDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// Event 描述一次节点变化，节点的权重等信息变化时也会发出 EventPut
+type Event struct {
+	Type EventType
+	Endpoint
+}
+
+// Discovery 负责节点的注册与发现
+type Discovery interface {
+	// Register 把 ep 注册为一个服务节点，并保持注册状态，
+	// 直到 ctx 被取消或者出错才会返回，ctx 取消时返回 nil
+	Register(ctx context.Context, ep Endpoint) error
+	// Peers 返回当前所有节点
+	Peers(ctx context.Context) ([]Endpoint, error)
+	// Watch 返回一个接收节点变化事件的 channel，ctx 取消后 channel 会被关闭
+	Watch(ctx context.Context) (<-chan Event, error)
+}
+
+// RegisterState 是节点在服务中心的注册状态
+type RegisterState int32
+
+const (
+	StateUnregistered RegisterState = iota // 还没有注册，或者已经主动注销
+	StateRegistered                        // 注册成功，正常保活
+	StateRetrying                          // 注册失败或者注册丢失，正在重试
 )
+
+func (s RegisterState) String() string {
+	switch s {
+	case StateUnregistered:
+		return "unregistered"
+	case StateRegistered:
+		return "registered"
+	case StateRetrying:
+		return "retrying"
+	}
+	return "unknown"
+}
+
+// StateReporter 是可以报告注册状态的 Discovery，用于健康检查
+type StateReporter interface {
+	// RegisterState 返回当前 Register 的状态
+	RegisterState() RegisterState
+}
+
+// waitRegister 用于节点列表由外部维护的 Discovery，
+// 它们不需要真正注册，只是阻塞到 ctx 取消
+func waitRegister(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+// pollWatch 每隔 interval 调用一次 peers，把前后两次结果的差异作为事件发出。
+// known 是 Watch 之前已经知道的节点
+func pollWatch(ctx context.Context, interval time.Duration, known []Endpoint, peers func(ctx context.Context) ([]Endpoint, error)) <-chan Event {
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		last := toMap(known)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			endpoints, err := peers(ctx)
+			if err != nil {
+				// 读取失败时保留上一次的结果，等下一次轮询
+				log.Printf("[registry] refresh peers failed: %v", err)
+				continue
+			}
+			cur := toMap(endpoints)
+			for _, ev := range diff(last, cur) {
+				select {
+				case ch <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+			last = cur
+		}
+	}()
+	return ch
+}
+
+func toMap(endpoints []Endpoint) map[string]Endpoint {
+	m := make(map[string]Endpoint, len(endpoints))
+	for _, ep := range endpoints {
+		m[ep.Addr] = ep
+	}
+	return m
+}
+
+// diff 返回从 old 变成 cur 需要的事件，按地址排序以保证顺序稳定
+func diff(old, cur map[string]Endpoint) []Event {
+	var events []Event
+	for addr, ep := range cur {
+		if prev, ok := old[addr]; !ok || !prev.Equal(ep) {
+			events = append(events, Event{Type: EventPut, Endpoint: ep})
+		}
+	}
+	for addr, ep := range old {
+		if _, ok := cur[addr]; !ok {
+			events = append(events, Event{Type: EventDelete, Endpoint: ep})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Type != events[j].Type {
+			return events[i].Type < events[j].Type
+		}
+		return events[i].Addr < events[j].Addr
+	})
+	return events
+}
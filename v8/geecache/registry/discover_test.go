@@ -0,0 +1,259 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParsePeers(t *testing.T) {
+	expect := []Endpoint{{Addr: "10.0.0.1:8001"}, {Addr: "10.0.0.2:8001"}}
+	weighted := []Endpoint{{Addr: "10.0.0.1:8001"}, {Addr: "10.0.0.2:8001", Weight: 4}}
+	cases := map[string]struct {
+		data    string
+		useYAML bool
+		expect  []Endpoint
+	}{
+		"json list":     {`["10.0.0.1:8001", "10.0.0.2:8001"]`, false, expect},
+		"json object":   {`{"peers": ["10.0.0.1:8001", "10.0.0.2:8001"]}`, false, expect},
+		"yaml list":     {"- 10.0.0.1:8001\n- 10.0.0.2:8001\n", true, expect},
+		"yaml object":   {"peers:\n  - 10.0.0.1:8001\n  - 10.0.0.2:8001\n", true, expect},
+		"json weighted": {`["10.0.0.1:8001", {"addr": "10.0.0.2:8001", "weight": 4}]`, false, weighted},
+		"yaml weighted": {"peers:\n  - 10.0.0.1:8001\n  - {addr: 10.0.0.2:8001, weight: 4}\n", true, weighted},
+	}
+	for name, c := range cases {
+		addrs, err := ParsePeers([]byte(c.data), c.useYAML)
+		if err != nil || !reflect.DeepEqual(addrs, c.expect) {
+			t.Errorf("%s: expect %v, got %v err=%v", name, c.expect, addrs, err)
+		}
+	}
+	if _, err := ParsePeers([]byte("{"), false); err == nil {
+		t.Errorf("invalid json should fail")
+	}
+}
+
+// nextEvent 等待 ch 中的下一个事件
+func nextEvent(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout waiting for event")
+	}
+	return Event{}
+}
+
+func TestFileWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.yaml")
+	if err := os.WriteFile(path, []byte("peers: [a:1, b:1, {addr: d:1, weight: 2}]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFile(path, 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peers, err := f.Peers(ctx)
+	if err != nil || !reflect.DeepEqual(peers, []Endpoint{{Addr: "a:1"}, {Addr: "b:1"}, {Addr: "d:1", Weight: 2}}) {
+		t.Fatalf("unexpected peers %v err=%v", peers, err)
+	}
+	ch, err := f.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("peers: [b:1, c:1, {addr: d:1, weight: 4}]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 新增的节点和权重变化的节点都是 put 事件，按地址排序
+	if ev := nextEvent(t, ch); ev != (Event{Type: EventPut, Endpoint: Endpoint{Addr: "c:1"}}) {
+		t.Fatalf("expect put c:1, got %v %v", ev.Type, ev.Endpoint)
+	}
+	if ev := nextEvent(t, ch); ev != (Event{Type: EventPut, Endpoint: Endpoint{Addr: "d:1", Weight: 4}}) {
+		t.Fatalf("expect put d:1 with weight 4, got %v %v", ev.Type, ev.Endpoint)
+	}
+	if ev := nextEvent(t, ch); ev != (Event{Type: EventDelete, Endpoint: Endpoint{Addr: "a:1"}}) {
+		t.Fatalf("expect delete a:1, got %v %v", ev.Type, ev.Endpoint)
+	}
+
+	cancel()
+	for range ch {
+	}
+}
+
+// fakeResolver 返回固定的 SRV 记录，target 按 hosts 解析成 IP
+type fakeResolver struct {
+	mux   sync.Mutex
+	srvs  []*net.SRV
+	hosts map[string][]net.IPAddr
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return "_grpc._tcp.geecache.local.", r.srvs, nil
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if ips, ok := r.hosts[host]; ok {
+		return ips, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (r *fakeResolver) setSRV(srvs ...*net.SRV) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.srvs = srvs
+}
+
+func TestDNSWatch(t *testing.T) {
+	r := &fakeResolver{
+		srvs: []*net.SRV{{Target: "a.geecache.local.", Port: 8001}},
+		hosts: map[string][]net.IPAddr{
+			"a.geecache.local": {{IP: net.ParseIP("10.0.0.1")}},
+			"b.geecache.local": {{IP: net.ParseIP("10.0.0.2")}, {IP: net.ParseIP("fe80::1"), Zone: "eth0"}},
+		},
+	}
+	d := NewDNSWithResolver("grpc", "tcp", "geecache.local", 10*time.Millisecond, r)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// target 解析成 IP，节点之间用 IP 地址互相识别
+	peers, err := d.Peers(ctx)
+	if err != nil || !reflect.DeepEqual(peers, []Endpoint{{Addr: "10.0.0.1:8001"}}) {
+		t.Fatalf("unexpected peers %v err=%v", peers, err)
+	}
+	ch, err := d.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.setSRV(&net.SRV{Target: "b.geecache.local.", Port: 8001})
+	if ev := nextEvent(t, ch); ev != (Event{Type: EventPut, Endpoint: Endpoint{Addr: "10.0.0.2:8001"}}) {
+		t.Fatalf("expect put b, got %v %v", ev.Type, ev.Endpoint)
+	}
+	if ev := nextEvent(t, ch); ev != (Event{Type: EventDelete, Endpoint: Endpoint{Addr: "10.0.0.1:8001"}}) {
+		t.Fatalf("expect delete a, got %v %v", ev.Type, ev.Endpoint)
+	}
+
+	// 有 target 解析失败时整个查询失败，保留原来的节点列表
+	r.setSRV(&net.SRV{Target: "c.geecache.local.", Port: 8001}, &net.SRV{Target: "10.0.0.3", Port: 8001})
+	if _, err := d.Peers(ctx); err == nil {
+		t.Fatalf("expect error when a target can not be resolved")
+	}
+}
+
+func TestStatic(t *testing.T) {
+	s := NewStatic("a:1", "b:1")
+	ctx, cancel := context.WithCancel(context.Background())
+	peers, err := s.Peers(ctx)
+	if err != nil || !reflect.DeepEqual(peers, []Endpoint{{Addr: "a:1"}, {Addr: "b:1"}}) {
+		t.Fatalf("unexpected peers %v err=%v", peers, err)
+	}
+	ch, err := s.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Register(ctx, Endpoint{Addr: "a:1"}) }()
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatalf("static discovery should not emit events")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Register should return nil after cancel, got %v", err)
+	}
+}
+
+func TestParseEndpoint(t *testing.T) {
+	cases := map[string]Endpoint{
+		`{"addr": "a:1", "weight": 3}`: {Addr: "a:1", Weight: 3},
+		`{"addr": "a:1"}`:              {Addr: "a:1"},
+		"a:1":                          {Addr: "a:1"}, // 旧版本只写入了地址
+	}
+	for value, expect := range cases {
+		ep, err := parseEndpoint([]byte(value))
+		if err != nil || ep != expect {
+			t.Errorf("parse %s: expect %v, got %v err=%v", value, expect, ep, err)
+		}
+	}
+	for _, value := range []string{"", `{"weight": 3}`, `{"addr": `} {
+		if _, err := parseEndpoint([]byte(value)); err == nil {
+			t.Errorf("parse %q should fail", value)
+		}
+	}
+
+	value, err := marshalEndpoint(Endpoint{Addr: "a:1", Weight: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep, err := parseEndpoint([]byte(value)); err != nil || ep != (Endpoint{Addr: "a:1", Weight: 3}) {
+		t.Fatalf("round trip failed, got %v err=%v", ep, err)
+	}
+	if w := (Endpoint{Addr: "a:1"}).GetWeight(); w != 1 {
+		t.Fatalf("default weight should be 1, got %d", w)
+	}
+
+	// 元数据也写入 etcd，start_time 按 RFC 3339 编码
+	full := Endpoint{Addr: "a:1", Weight: 2, Zone: "az1", Version: "v1.2.0", MinProtocol: 1, MaxProtocol: 2,
+		StartTime: time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)}
+	if value, err = marshalEndpoint(full); err != nil {
+		t.Fatal(err)
+	}
+	if ep, err := parseEndpoint([]byte(value)); err != nil || ep != full {
+		t.Fatalf("round trip %s failed, got %+v err=%v", value, ep, err)
+	}
+	if value, _ = marshalEndpoint(Endpoint{Addr: "a:1"}); value != `{"addr":"a:1"}` {
+		t.Fatalf("unset metadata should be omitted, got %s", value)
+	}
+}
+
+func TestEndpointCompatible(t *testing.T) {
+	cases := []struct {
+		a, b   Endpoint
+		expect bool
+	}{
+		{Endpoint{}, Endpoint{}, true},
+		{Endpoint{}, Endpoint{MinProtocol: 1, MaxProtocol: 2}, true}, // 没有设置时为协议 1
+		{Endpoint{MinProtocol: 2, MaxProtocol: 3}, Endpoint{MinProtocol: 1, MaxProtocol: 2}, true},
+		{Endpoint{}, Endpoint{MinProtocol: 2, MaxProtocol: 2}, false},
+		{Endpoint{MinProtocol: 3}, Endpoint{MinProtocol: 1, MaxProtocol: 2}, false},
+	}
+	for _, c := range cases {
+		if got := c.a.Compatible(c.b); got != c.expect || c.b.Compatible(c.a) != got {
+			t.Errorf("%+v compatible with %+v: expect %v, got %v", c.a, c.b, c.expect, got)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	old := toMap([]Endpoint{{Addr: "a:1", StartTime: start}, {Addr: "b:1"}})
+
+	// 同一时刻在其他时区、带单调时钟读数都不算变化
+	local := start.In(time.FixedZone("CST", 8*3600))
+	now := time.Now()
+	unchanged := toMap([]Endpoint{{Addr: "a:1", StartTime: local}, {Addr: "b:1"}})
+	if events := diff(old, unchanged); len(events) != 0 {
+		t.Fatalf("same start time in another location should not change, got %v", events)
+	}
+	if events := diff(toMap([]Endpoint{{Addr: "c:1", StartTime: now}}), toMap([]Endpoint{{Addr: "c:1", StartTime: now.Round(0)}})); len(events) != 0 {
+		t.Fatalf("monotonic clock reading should be ignored, got %v", events)
+	}
+
+	cur := toMap([]Endpoint{{Addr: "a:1", StartTime: local, Zone: "az1"}, {Addr: "c:1"}})
+	expect := []Event{
+		{Type: EventPut, Endpoint: Endpoint{Addr: "a:1", StartTime: local, Zone: "az1"}},
+		{Type: EventPut, Endpoint: Endpoint{Addr: "c:1"}},
+		{Type: EventDelete, Endpoint: Endpoint{Addr: "b:1"}},
+	}
+	if events := diff(old, cur); !reflect.DeepEqual(events, expect) {
+		t.Fatalf("expect %v, got %v", expect, events)
+	}
+}
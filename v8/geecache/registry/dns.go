@@ -0,0 +1,107 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNS 通过查询 DNS SRV 记录发现节点，例如 kubernetes headless service 的
+// _grpc._tcp.geecache.default.svc.cluster.local
+type DNS struct {
+	service  string // SRV 记录的 service，如 grpc；为空时直接查询 name
+	proto    string // SRV 记录的协议，如 tcp
+	name     string // 域名
+	interval time.Duration
+	resolver Resolver
+}
+
+var _ Discovery = (*DNS)(nil)
+
+// Resolver 查询 SRV 记录和域名对应的 IP，*net.Resolver 实现了这个接口
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// NewDNS 创建一个查询 _service._proto.name 的 DNS，service 和 proto 都为空时
+// 直接查询 name。interval 是重新查询的间隔，不大于 0 时使用默认值
+func NewDNS(service, proto, name string, interval time.Duration) *DNS {
+	return NewDNSWithResolver(service, proto, name, interval, net.DefaultResolver)
+}
+
+// NewDNSWithResolver 和 NewDNS 一样，但使用 resolver 查询
+func NewDNSWithResolver(service, proto, name string, interval time.Duration, resolver Resolver) *DNS {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	return &DNS{
+		service:  service,
+		proto:    proto,
+		name:     name,
+		interval: interval,
+		resolver: resolver,
+	}
+}
+
+// Register 不做任何事，DNS 记录由外部维护
+func (d *DNS) Register(ctx context.Context, ep Endpoint) error {
+	return waitRegister(ctx)
+}
+
+// Peers 查询 SRV 记录，记录中的 weight 作为节点的权重。
+// 节点之间用 IP 地址互相识别，所以 SRV 记录的 target 会被解析成 IP，
+// 一个 target 解析出多个 IP 时每个 IP 都是一个节点
+func (d *DNS) Peers(ctx context.Context) ([]Endpoint, error) {
+	_, srvs, err := d.resolver.LookupSRV(ctx, d.service, d.proto, d.name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup srv %s failed: %v", d.name, err)
+	}
+	endpoints := make([]Endpoint, 0, len(srvs))
+	seen := make(map[string]bool, len(srvs))
+	for _, srv := range srvs {
+		ips, err := d.lookupIP(ctx, strings.TrimSuffix(srv.Target, "."))
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			addr := net.JoinHostPort(ip, fmt.Sprint(srv.Port))
+			if seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			endpoints = append(endpoints, Endpoint{Addr: addr, Weight: int(srv.Weight)})
+		}
+	}
+	return endpoints, nil
+}
+
+// lookupIP 返回 host 对应的 IP，host 本身就是 IP 时直接返回
+func (d *DNS) lookupIP(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	addrs, err := d.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("lookup ip %s failed: %v", host, err)
+	}
+	ips := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		// 带 zone 的 IPv6 链路本地地址不能用于其他节点访问
+		if addr.Zone == "" {
+			ips = append(ips, addr.IP.String())
+		}
+	}
+	return ips, nil
+}
+
+// Watch 定期重新查询 SRV 记录，记录变化时发出对应的事件
+func (d *DNS) Watch(ctx context.Context) (<-chan Event, error) {
+	known, err := d.Peers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pollWatch(ctx, d.interval, known, d.Peers), nil
+}
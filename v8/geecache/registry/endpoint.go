@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strings"
+	"time"
+)
+
+// Endpoint 描述一个服务节点，注册到 etcd 时以 JSON 的形式保存。
+// 除了 Addr 之外的字段都是可选的，旧版本的节点只写入了地址
+type Endpoint struct {
+	Addr    string `json:"addr" yaml:"addr"`                           // 节点地址 ip:port
+	Weight  int    `json:"weight,omitempty" yaml:"weight,omitempty"`   // 节点权重，一般和内存大小成正比，0 表示默认权重 1
+	Zone    string `json:"zone,omitempty" yaml:"zone,omitempty"`       // 节点所在的可用区
+	Version string `json:"version,omitempty" yaml:"version,omitempty"` // 节点的构建版本，只用于展示和排查问题
+
+	// 节点支持的节点间协议版本范围 [MinProtocol, MaxProtocol]，0 表示协议版本 1，
+	// 也就是还没有这两个字段时的协议
+	MinProtocol int `json:"min_protocol,omitempty" yaml:"min_protocol,omitempty"`
+	MaxProtocol int `json:"max_protocol,omitempty" yaml:"max_protocol,omitempty"`
+
+	StartTime time.Time `json:"start_time,omitzero" yaml:"start_time,omitempty"` // 节点的启动时间
+}
+
+// GetWeight 返回节点的权重，没有设置时为 1
+func (e Endpoint) GetWeight() int {
+	if e.Weight < 1 {
+		return 1
+	}
+	return e.Weight
+}
+
+// Protocols 返回节点支持的协议版本范围，没有设置时为 [1, 1]
+func (e Endpoint) Protocols() (lo, hi int) {
+	lo, hi = max(e.MinProtocol, 1), e.MaxProtocol
+	return lo, max(hi, lo)
+}
+
+// Compatible 两个节点支持的协议版本有交集时返回 true。
+// 滚动升级时新版本的节点保留对旧协议的支持，不兼容的节点之间不能互相转发请求
+func (e Endpoint) Compatible(other Endpoint) bool {
+	lo, hi := e.Protocols()
+	otherLo, otherHi := other.Protocols()
+	return lo <= otherHi && otherLo <= hi
+}
+
+// Equal 判断两个 Endpoint 的每个字段是否相同。
+// StartTime 用 time.Time.Equal 比较，同一时刻在不同时区、或者带不带单调时钟读数都视为相同
+func (e Endpoint) Equal(other Endpoint) bool {
+	return e.Addr == other.Addr && e.Weight == other.Weight && e.Zone == other.Zone &&
+		e.Version == other.Version && e.MinProtocol == other.MinProtocol &&
+		e.MaxProtocol == other.MaxProtocol && e.StartTime.Equal(other.StartTime)
+}
+
+// Addrs 返回 endpoints 中每个节点的地址
+func Addrs(endpoints []Endpoint) []string {
+	addrs := make([]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		addrs = append(addrs, ep.Addr)
+	}
+	return addrs
+}
+
+// endpointFields 用于解析对象形式的 Endpoint，避免 UnmarshalJSON 递归调用自己
+type endpointFields Endpoint
+
+// UnmarshalJSON 既支持 {"addr": "...", "weight": 4}，也支持只有地址的字符串
+func (e *Endpoint) UnmarshalJSON(data []byte) error {
+	var addr string
+	if err := json.Unmarshal(data, &addr); err == nil {
+		*e = Endpoint{Addr: addr}
+		return nil
+	}
+	return json.Unmarshal(data, (*endpointFields)(e))
+}
+
+// UnmarshalYAML 既支持 {addr: ..., weight: 4}，也支持只有地址的字符串
+func (e *Endpoint) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*e = Endpoint{Addr: value.Value}
+		return nil
+	}
+	return value.Decode((*endpointFields)(e))
+}
+
+// marshalEndpoint 把 Endpoint 编码成写入 etcd 的值
+func marshalEndpoint(ep Endpoint) (string, error) {
+	data, err := json.Marshal(ep)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// parseEndpoint 解析 etcd 中保存的值。旧版本的节点只写入了地址，
+// 不是 JSON 时把整个值当作地址
+func parseEndpoint(value []byte) (Endpoint, error) {
+	s := strings.TrimSpace(string(value))
+	if !strings.HasPrefix(s, "{") {
+		if s == "" {
+			return Endpoint{}, fmt.Errorf("empty endpoint")
+		}
+		return Endpoint{Addr: s}, nil
+	}
+	var ep Endpoint
+	if err := json.Unmarshal([]byte(s), &ep); err != nil {
+		return Endpoint{}, err
+	}
+	if ep.Addr == "" {
+		return Endpoint{}, fmt.Errorf("endpoint %s has no addr", s)
+	}
+	return ep, nil
+}
@@ -0,0 +1,228 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// etcdClient 是注册时用到的 etcd 客户端方法，测试时可以替换
+type etcdClient interface {
+	clientv3.KV
+	clientv3.Lease
+}
+
+// etcdAdd 在租赁模式添加一对kv至etcd，value 是 JSON 编码的 Endpoint
+func etcdAdd(ctx context.Context, c etcdClient, lid clientv3.LeaseID, service string, ep Endpoint) error {
+	value, err := marshalEndpoint(ep)
+	if err != nil {
+		return err
+	}
+	_, err = c.Put(ctx, service+"/"+ep.Addr, value, clientv3.WithLease(lid))
+	return err
+}
+
+// Etcd 使用 etcd 作为服务中心，节点以 Prefix/addr 为 key 注册，并通过租约保活
+type Etcd struct {
+	cfg   Config
+	state atomic.Int32 // RegisterState
+
+	newClient func() (etcdClient, error) // 注册时创建客户端，测试时可以替换
+}
+
+var (
+	_ Discovery     = (*Etcd)(nil)
+	_ StateReporter = (*Etcd)(nil)
+)
+
+// NewEtcd 用 cfg 创建一个 Etcd，没有设置的字段使用默认值
+func NewEtcd(cfg Config) *Etcd {
+	e := &Etcd{cfg: cfg.WithDefaults()}
+	e.newClient = func() (etcdClient, error) {
+		return e.cfg.NewClient()
+	}
+	return e
+}
+
+// Config 返回 Etcd 使用的配置
+func (e *Etcd) Config() Config {
+	return e.cfg
+}
+
+// RegisterState 返回当前 Register 的状态
+func (e *Etcd) RegisterState() RegisterState {
+	return RegisterState(e.state.Load())
+}
+
+// Register 把 ep 注册至etcd，key 为 cfg.Prefix/addr
+// 注意 Register将不会return 除非 ctx 被取消。
+// etcd 不可用、租约过期等原因导致注册失败或丢失时，按指数退避不断重新申请租约并写入节点，
+// 所以 etcd 短暂故障之后节点会自动重新上线
+func (e *Etcd) Register(ctx context.Context, ep Endpoint) error {
+	defer e.state.Store(int32(StateUnregistered))
+	backoff := registerBackoffMin
+	for {
+		registered, err := e.registerOnce(ctx, ep)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if registered {
+			// 注册成功过说明 etcd 恢复过，从最短的等待时间重新开始
+			backoff = registerBackoffMin
+		}
+		e.state.Store(int32(StateRetrying))
+		log.Printf("[registry] register %s failed: %v, retry in %v", ep.Addr, err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, registerBackoffMax)
+	}
+}
+
+// registerOnce 申请租约、写入节点并保持续约，直到 ctx 取消或者租约丢失。
+// registered 表示这一次是否注册成功过
+func (e *Etcd) registerOnce(ctx context.Context, ep Endpoint) (registered bool, err error) {
+	// 创建一个etcd client
+	cli, err := e.newClient()
+	if err != nil {
+		return false, fmt.Errorf("create etcd client failed: %v", err)
+	}
+	defer cli.Close()
+
+	// 创建一个租约 cfg.LeaseTTL 秒后过期，etcd 不可用时不能一直阻塞在这里
+	reqCtx, cancel := context.WithTimeout(ctx, e.cfg.DialTimeout)
+	defer cancel()
+	leaseResp, err := cli.Grant(reqCtx, e.cfg.LeaseTTL)
+	if err != nil {
+		return false, fmt.Errorf("create lease failed: %v", err)
+	}
+	leaseId := leaseResp.ID
+
+	// 注册服务
+	if err := etcdAdd(reqCtx, cli, leaseId, e.cfg.Prefix, ep); err != nil {
+		return false, fmt.Errorf("add etcd record failed: %v", err)
+	}
+	// 设置服务心跳检测,续约
+	ch, err := cli.KeepAlive(ctx, leaseId)
+	if err != nil {
+		return false, fmt.Errorf("keep alive etcd failed: %v", err)
+	}
+	e.state.Store(int32(StateRegistered))
+	log.Printf("[%s] register service ok\n", ep.Addr)
+	for {
+		select {
+		case <-ctx.Done():
+			// 主动下线，撤销租约让其他节点立刻感知
+			e.revoke(cli, leaseId)
+			return true, nil
+		//通过ok来判断channel是否关闭，如果关闭不就说明续约失败了
+		case _, ok := <-ch:
+			// 监听租约
+			if ok {
+				continue
+			}
+			// 旧的租约可能已经过期了，撤销失败也没有关系，重新注册时会申请新的租约
+			e.revoke(cli, leaseId)
+			if ctx.Err() != nil {
+				return true, nil
+			}
+			return true, fmt.Errorf("lease %x lost", leaseId)
+		}
+	}
+}
+
+// revoke 撤销租约，租约上的 key 会被立即删除
+func (e *Etcd) revoke(cli etcdClient, id clientv3.LeaseID) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.DialTimeout)
+	defer cancel()
+	if _, err := cli.Revoke(ctx, id); err != nil {
+		log.Printf("[registry] revoke lease %x failed: %v", id, err)
+	}
+}
+
+// Peers 从etcd获取所有节点
+func (e *Etcd) Peers(ctx context.Context) ([]Endpoint, error) {
+	cli, err := e.cfg.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("create etcd client failed: %v", err)
+	}
+	defer cli.Close()
+
+	getRes, err := cli.Get(ctx, e.cfg.KeyPrefix(), clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("etcd get failed: %v", err)
+	}
+	var endpoints []Endpoint
+	for _, v := range getRes.Kvs {
+		ep, err := parseEndpoint(v.Value)
+		if err != nil {
+			log.Printf("[registry] skip invalid endpoint %s: %v", v.Key, err)
+			continue
+		}
+		endpoints = append(endpoints, ep)
+	}
+	return endpoints, nil
+}
+
+// Watch 监听 Prefix 下的 key 的变化，watch 中断后会自动重新 watch
+func (e *Etcd) Watch(ctx context.Context) (<-chan Event, error) {
+	cli, err := e.cfg.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("create etcd client failed: %v", err)
+	}
+	events := make(chan Event)
+	go func() {
+		defer cli.Close()
+		defer close(events)
+
+		serviceKey := e.cfg.KeyPrefix()
+		ch := cli.Watch(ctx, serviceKey, clientv3.WithPrefix(), clientv3.WithPrevKV())
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case resp, ok := <-ch:
+				if !ok {
+					log.Println("Watch channel closed, attempting to re-watch...")
+					time.Sleep(time.Second)
+					ch = cli.Watch(ctx, serviceKey, clientv3.WithPrefix(), clientv3.WithPrevKV())
+					continue
+				}
+				for _, v := range resp.Events {
+					var ev Event
+					switch v.Type {
+					// PUT，新增或替换
+					case clientv3.EventTypePut:
+						ep, err := parseEndpoint(v.Kv.Value)
+						if err != nil {
+							log.Printf("[registry] skip invalid endpoint %s: %v", v.Kv.Key, err)
+							continue
+						}
+						ev = Event{Type: EventPut, Endpoint: ep}
+					// DELETE 事件的 Kv 中没有 value，要从 PrevKv 中取得下线节点的地址
+					case clientv3.EventTypeDelete:
+						if v.PrevKv == nil {
+							continue
+						}
+						ep, err := parseEndpoint(v.PrevKv.Value)
+						if err != nil {
+							continue
+						}
+						ev = Event{Type: EventDelete, Endpoint: ep}
+					}
+					select {
+					case events <- ev:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+	return events, nil
+}
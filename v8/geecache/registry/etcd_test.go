@@ -0,0 +1,135 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// fakeEtcd 模拟 etcd 的租约和写入，failGrants 次之后 Grant 才会成功
+type fakeEtcd struct {
+	clientv3.KV
+	clientv3.Lease
+
+	mux        sync.Mutex
+	failGrants int
+	leases     clientv3.LeaseID
+	puts       map[string]clientv3.LeaseID // key -> lease
+	revoked    []clientv3.LeaseID
+	keepAlives []chan *clientv3.LeaseKeepAliveResponse
+}
+
+func (f *fakeEtcd) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.failGrants > 0 {
+		f.failGrants--
+		return nil, fmt.Errorf("etcd unavailable")
+	}
+	f.leases++
+	return &clientv3.LeaseGrantResponse{ID: f.leases, TTL: ttl}, nil
+}
+
+func (f *fakeEtcd) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.puts[key] = f.leases
+	return &clientv3.PutResponse{}, nil
+}
+
+func (f *fakeEtcd) KeepAlive(ctx context.Context, id clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	ch := make(chan *clientv3.LeaseKeepAliveResponse)
+	f.keepAlives = append(f.keepAlives, ch)
+	return ch, nil
+}
+
+func (f *fakeEtcd) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.revoked = append(f.revoked, id)
+	return &clientv3.LeaseRevokeResponse{}, nil
+}
+
+func (f *fakeEtcd) Close() error { return nil }
+
+// loseLease 关闭最近一次的续约 channel，模拟租约过期
+func (f *fakeEtcd) loseLease() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	close(f.keepAlives[len(f.keepAlives)-1])
+}
+
+func (f *fakeEtcd) lease(key string) clientv3.LeaseID {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.puts[key]
+}
+
+// waitState 等待 e 进入 state
+func waitState(t *testing.T, e *Etcd, state RegisterState) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for e.RegisterState() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for state %v, got %v", state, e.RegisterState())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEtcdReregister(t *testing.T) {
+	oldMin, oldMax := registerBackoffMin, registerBackoffMax
+	registerBackoffMin, registerBackoffMax = 20*time.Millisecond, 50*time.Millisecond
+	defer func() { registerBackoffMin, registerBackoffMax = oldMin, oldMax }()
+
+	fake := &fakeEtcd{failGrants: 2, puts: make(map[string]clientv3.LeaseID)}
+	e := NewEtcd(Config{Prefix: "test"})
+	e.newClient = func() (etcdClient, error) { return fake, nil }
+	if e.RegisterState() != StateUnregistered {
+		t.Fatalf("expect unregistered before Register, got %v", e.RegisterState())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- e.Register(ctx, Endpoint{Addr: "a:1"}) }()
+
+	// etcd 不可用时一直重试，直到注册成功
+	waitState(t, e, StateRetrying)
+	waitState(t, e, StateRegistered)
+	if id := fake.lease("test/a:1"); id != 1 {
+		t.Fatalf("expect endpoint put with lease 1, got %d", id)
+	}
+
+	// 租约丢失后重新申请租约并写入节点
+	fake.loseLease()
+	waitState(t, e, StateRetrying)
+	waitState(t, e, StateRegistered)
+	if id := fake.lease("test/a:1"); id != 2 {
+		t.Fatalf("expect endpoint re-put with lease 2, got %d", id)
+	}
+
+	// 只有 ctx 取消才会真正下线
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Register should return nil after cancel, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Register should return after cancel")
+	}
+	if e.RegisterState() != StateUnregistered {
+		t.Fatalf("expect unregistered after cancel, got %v", e.RegisterState())
+	}
+	fake.mux.Lock()
+	defer fake.mux.Unlock()
+	if n := len(fake.revoked); n != 2 || fake.revoked[n-1] != 2 {
+		t.Fatalf("expect lost and final leases revoked, got %v", fake.revoked)
+	}
+}
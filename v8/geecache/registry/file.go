@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File 从一个 JSON 或 YAML 文件中读取节点列表，并定期检查文件是否被修改。
+// 文件可以直接是节点列表，也可以是带有 peers 字段的对象，
+// 节点可以只写地址，也可以写成带权重的对象：
+//
+//	["10.0.0.1:8001", "10.0.0.2:8001"]
+//	{"peers": ["10.0.0.1:8001", {"addr": "10.0.0.2:8001", "weight": 8}]}
+//
+// 扩展名为 .yaml 或 .yml 时按 YAML 解析，否则按 JSON 解析
+type File struct {
+	path     string
+	interval time.Duration
+}
+
+var _ Discovery = (*File)(nil)
+
+// NewFile 创建一个读取 path 的 File，interval 是检查文件修改的间隔，
+// 不大于 0 时使用默认值
+func NewFile(path string, interval time.Duration) *File {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	return &File{path: path, interval: interval}
+}
+
+// Register 不做任何事，节点列表由文件维护
+func (f *File) Register(ctx context.Context, ep Endpoint) error {
+	return waitRegister(ctx)
+}
+
+func (f *File) Peers(ctx context.Context) ([]Endpoint, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("read peers file failed: %v", err)
+	}
+	endpoints, err := ParsePeers(data, isYAML(f.path))
+	if err != nil {
+		return nil, fmt.Errorf("parse peers file %s failed: %v", f.path, err)
+	}
+	return endpoints, nil
+}
+
+// Watch 定期重新读取文件，文件内容变化时发出对应的事件
+func (f *File) Watch(ctx context.Context) (<-chan Event, error) {
+	known, err := f.Peers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 节点文件很小，每次都重新读取解析，不依赖修改时间的精度
+	return pollWatch(ctx, f.interval, known, f.Peers), nil
+}
+
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// peersFile 是带 peers 字段的文件格式
+type peersFile struct {
+	Peers []Endpoint `json:"peers" yaml:"peers"`
+}
+
+// ParsePeers 解析 peers 文件的内容：节点列表，或者带 peers 字段的对象，
+// 每个节点可以是地址字符串或者带权重等字段的对象。useYAML 为 true 时按 YAML 解析，否则按 JSON
+func ParsePeers(data []byte, useYAML bool) ([]Endpoint, error) {
+	unmarshal := json.Unmarshal
+	if useYAML {
+		unmarshal = yaml.Unmarshal
+	}
+	var endpoints []Endpoint
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '-') {
+		if err := unmarshal(data, &endpoints); err != nil {
+			return nil, err
+		}
+		return endpoints, nil
+	}
+	var pf peersFile
+	if err := unmarshal(data, &pf); err != nil {
+		return nil, err
+	}
+	return pf.Peers, nil
+}
@@ -0,0 +1,43 @@
+package registry
+
+import "context"
+
+// Static 是一个固定节点列表的 Discovery，适合没有服务中心的环境
+type Static struct {
+	endpoints []Endpoint
+}
+
+var _ Discovery = (*Static)(nil)
+
+// NewStatic 用给定的节点地址创建一个 Static，所有节点的权重都为 1
+func NewStatic(addrs ...string) *Static {
+	endpoints := make([]Endpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		endpoints = append(endpoints, Endpoint{Addr: addr})
+	}
+	return &Static{endpoints: endpoints}
+}
+
+// NewStaticEndpoints 用给定的节点创建一个 Static
+func NewStaticEndpoints(endpoints ...Endpoint) *Static {
+	return &Static{endpoints: append([]Endpoint(nil), endpoints...)}
+}
+
+// Register 不做任何事，节点列表是固定的
+func (s *Static) Register(ctx context.Context, ep Endpoint) error {
+	return waitRegister(ctx)
+}
+
+func (s *Static) Peers(ctx context.Context) ([]Endpoint, error) {
+	return append([]Endpoint(nil), s.endpoints...), nil
+}
+
+// Watch 返回的 channel 不会收到任何事件，ctx 取消后关闭
+func (s *Static) Watch(ctx context.Context) (<-chan Event, error) {
+	ch := make(chan Event)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
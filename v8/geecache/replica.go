@@ -0,0 +1,129 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"v8/geecache/geecachepb"
+)
+
+// peerRequestKey 标记一个请求来自其他节点
+type peerRequestKey struct{}
+
+// withPeerRequest 标记 ctx 中的请求来自其他节点
+func withPeerRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, peerRequestKey{}, true)
+}
+
+func isPeerRequest(ctx context.Context) bool {
+	fromPeer, _ := ctx.Value(peerRequestKey{}).(bool)
+	return fromPeer
+}
+
+// pickReplicas 返回 key 的远端副本，ok 为 false 表示这个 Group 没有开启副本
+func (g *Group) pickReplicas(key string) (peers []Fetcher, self int, ok bool) {
+	if g.replicas <= 1 || g.peers == nil {
+		return nil, -1, false
+	}
+	picker, ok := g.peers.(ReplicaPicker)
+	if !ok {
+		return nil, -1, false
+	}
+	peers, self = picker.PickReplicas(key, g.replicas)
+	return peers, self, true
+}
+
+// loadReplicated 在开启副本时加载 key：
+// 本节点不是主副本时，依次尝试各个远端副本，都失败后才从数据源加载；
+// 从数据源加载后把值写到其余的副本上。
+// 来自其他节点的请求直接从数据源加载，避免副本之间互相转发
+func (g *Group) loadReplicated(ctx context.Context, key string, peers []Fetcher, self int) (ByteView, error) {
+	if self != 0 && !isPeerRequest(ctx) {
+		for _, peer := range peers {
+			if self < 0 {
+				value, err := g.getFromPeer(ctx, peer, key)
+				if err == nil {
+					return value, nil
+				}
+				log.Println("[GeeCache] Failed to get from replica", err)
+				continue
+			}
+			value, err := g.fetchFromPeer(ctx, peer, key)
+			if err != nil {
+				log.Println("[GeeCache] Failed to get from replica", err)
+				continue
+			}
+			// 本节点也是副本，补上缺失的数据
+			g.populateCache(key, value)
+			return value, nil
+		}
+	}
+	value, err := g.getLocally(ctx, key)
+	if err != nil {
+		return ByteView{}, err
+	}
+	g.populateReplicas(key, value, peers)
+	return value, nil
+}
+
+// populateReplicas 在后台把 value 写到各个远端副本上
+func (g *Group) populateReplicas(key string, value ByteView, peers []Fetcher) {
+	req := &geecachepb.SetRequest{Group: g.name, Key: key, Value: value.b}
+	if !value.e.IsZero() {
+		req.Expire = value.e.UnixNano()
+	}
+	for _, peer := range peers {
+		updater, ok := peer.(Updater)
+		if !ok {
+			continue
+		}
+		go func() {
+			if err := updater.Set(context.Background(), req); err != nil {
+				log.Println("[GeeCache] Failed to populate replica", err)
+			}
+		}()
+	}
+}
+
+// setReplicated 把 value 写到 key 的所有副本上
+func (g *Group) setReplicated(ctx context.Context, key string, value ByteView, peers []Fetcher, self int) error {
+	if self >= 0 {
+		g.setLocally(key, value)
+	} else {
+		// 本地 hotCache 里的副本已经过时了
+		g.removeHot(key)
+	}
+	req := &geecachepb.SetRequest{Group: g.name, Key: key, Value: value.b}
+	if !value.e.IsZero() {
+		req.Expire = value.e.UnixNano()
+	}
+	var errs []error
+	for _, peer := range peers {
+		updater, ok := peer.(Updater)
+		if !ok {
+			errs = append(errs, fmt.Errorf("peer %v does not support update", peer))
+			continue
+		}
+		if err := updater.Set(ctx, req); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// removeReplicated 删除 key 在所有远端副本上的缓存
+func (g *Group) removeReplicated(ctx context.Context, key string, peers []Fetcher) error {
+	var errs []error
+	for _, peer := range peers {
+		updater, ok := peer.(Updater)
+		if !ok {
+			errs = append(errs, fmt.Errorf("peer %v does not support update", peer))
+			continue
+		}
+		if err := updater.Remove(ctx, &geecachepb.Request{Group: g.name, Key: key}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,220 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"v8/geecache/geecachepb"
+)
+
+// replicaPeer 是一个可以模拟下线的副本节点
+type replicaPeer struct {
+	mux     sync.Mutex
+	down    bool
+	values  map[string]string
+	fetches int
+	removes int
+	sets    chan *geecachepb.SetRequest
+}
+
+func newReplicaPeer(values map[string]string) *replicaPeer {
+	return &replicaPeer{values: values, sets: make(chan *geecachepb.SetRequest, 10)}
+}
+
+func (p *replicaPeer) Fetch(ctx context.Context, in *geecachepb.Request, out *geecachepb.Response) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.fetches++
+	if p.down {
+		return fmt.Errorf("peer is down")
+	}
+	v, ok := p.values[in.GetKey()]
+	if !ok {
+		return fmt.Errorf("%s not found", in.GetKey())
+	}
+	out.Value = []byte(v)
+	return nil
+}
+
+func (p *replicaPeer) Set(ctx context.Context, in *geecachepb.SetRequest) error {
+	p.sets <- in
+	return nil
+}
+
+func (p *replicaPeer) Remove(ctx context.Context, in *geecachepb.Request) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.removes++
+	return nil
+}
+
+func (p *replicaPeer) Purge(ctx context.Context, in *geecachepb.PurgeRequest) error {
+	return nil
+}
+
+func (p *replicaPeer) Invalidate(ctx context.Context, in *geecachepb.InvalidateRequest) error {
+	return nil
+}
+
+// waitSet 等待 peer 收到一次 Set
+func (p *replicaPeer) waitSet(t *testing.T) *geecachepb.SetRequest {
+	t.Helper()
+	select {
+	case in := <-p.sets:
+		return in
+	case <-time.After(time.Second):
+		t.Fatalf("replica was not populated")
+	}
+	return nil
+}
+
+// replicaPicker 对所有 key 返回同一组副本
+type replicaPicker struct {
+	peers []*replicaPeer
+	self  int
+}
+
+func (p *replicaPicker) PickPeer(key string) (Fetcher, bool) {
+	if p.self == 0 {
+		return nil, false
+	}
+	return p.peers[0], true
+}
+
+func (p *replicaPicker) PickReplicas(key string, n int) ([]Fetcher, int) {
+	peers := make([]Fetcher, 0, len(p.peers))
+	for _, peer := range p.peers {
+		peers = append(peers, peer)
+	}
+	return peers, p.self
+}
+
+func newReplicaGroup(name string, loads *int) *Group {
+	return NewGroup(name, 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		*loads++
+		return []byte("db-" + key), nil
+	}), WithReplication(3), WithHotCache(0))
+}
+
+func TestReplicaReadFallback(t *testing.T) {
+	loads := 0
+	gee := newReplicaGroup("replica-fallback", &loads)
+	primary := newReplicaPeer(map[string]string{"Tom": "630"})
+	primary.down = true
+	secondary := newReplicaPeer(map[string]string{"Tom": "630"})
+	gee.RegisterPeers(&replicaPicker{peers: []*replicaPeer{primary, secondary}, self: -1})
+
+	view, err := gee.Get(context.Background(), "Tom")
+	if err != nil || view.String() != "630" {
+		t.Fatalf("expect 630 from the second replica, got %s err=%v", view, err)
+	}
+	if primary.fetches != 1 || secondary.fetches != 1 || loads != 0 {
+		t.Fatalf("expect replicas tried in order before the source, fetches=%d,%d loads=%d",
+			primary.fetches, secondary.fetches, loads)
+	}
+
+	// 所有副本都失败后回源，并写到各个副本上
+	secondary.down = true
+	view, err = gee.Get(context.Background(), "Jack")
+	if err != nil || view.String() != "db-Jack" || loads != 1 {
+		t.Fatalf("expect db-Jack from the source, got %s err=%v loads=%d", view, err, loads)
+	}
+	for _, peer := range []*replicaPeer{primary, secondary} {
+		if in := peer.waitSet(t); in.GetKey() != "Jack" || string(in.GetValue()) != "db-Jack" {
+			t.Fatalf("unexpected populate %s=%s", in.GetKey(), in.GetValue())
+		}
+	}
+}
+
+func TestReplicaPrimaryLoadsSource(t *testing.T) {
+	loads := 0
+	gee := newReplicaGroup("replica-primary", &loads)
+	peer := newReplicaPeer(map[string]string{"Tom": "630"})
+	gee.RegisterPeers(&replicaPicker{peers: []*replicaPeer{peer}, self: 0})
+
+	if view, err := gee.Get(context.Background(), "Tom"); err != nil || view.String() != "db-Tom" {
+		t.Fatalf("primary should load from source, got %s err=%v", view, err)
+	}
+	if peer.fetches != 0 || loads != 1 {
+		t.Fatalf("primary should not ask other replicas, fetches=%d loads=%d", peer.fetches, loads)
+	}
+	peer.waitSet(t)
+}
+
+func TestReplicaSecondary(t *testing.T) {
+	loads := 0
+	gee := newReplicaGroup("replica-secondary", &loads)
+	primary := newReplicaPeer(map[string]string{"Tom": "630"})
+	gee.RegisterPeers(&replicaPicker{peers: []*replicaPeer{primary}, self: 1})
+
+	if view, err := gee.Get(context.Background(), "Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("expect 630 from the primary, got %s err=%v", view, err)
+	}
+	if _, ok := gee.mainCache.get("Tom"); !ok {
+		t.Fatalf("a secondary replica should keep the fetched value")
+	}
+
+	// 来自其他节点的请求直接回源，不再转发给其他副本
+	if view, err := gee.Get(withPeerRequest(context.Background()), "Sam"); err != nil || view.String() != "db-Sam" {
+		t.Fatalf("expect db-Sam from the source, got %s err=%v", view, err)
+	}
+	if primary.fetches != 1 || loads != 1 {
+		t.Fatalf("peer request should not be forwarded, fetches=%d loads=%d", primary.fetches, loads)
+	}
+	primary.waitSet(t)
+}
+
+func TestReplicaWrites(t *testing.T) {
+	loads := 0
+	gee := newReplicaGroup("replica-writes", &loads)
+	peers := []*replicaPeer{newReplicaPeer(nil), newReplicaPeer(nil)}
+	gee.RegisterPeers(&replicaPicker{peers: peers, self: 1})
+
+	if err := gee.Set(context.Background(), "Tom", []byte("630")); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := gee.mainCache.get("Tom"); !ok || v.String() != "630" {
+		t.Fatalf("local replica should be written")
+	}
+	for _, peer := range peers {
+		if in := peer.waitSet(t); string(in.GetValue()) != "630" {
+			t.Fatalf("remote replica should be written, got %s", in.GetValue())
+		}
+	}
+
+	if err := gee.Remove(context.Background(), "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gee.mainCache.get("Tom"); ok || peers[0].removes != 1 || peers[1].removes != 1 {
+		t.Fatalf("Remove should reach every replica")
+	}
+}
+
+func TestServerPickReplicas(t *testing.T) {
+	svr, err := NewServer("127.0.0.1:9020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr.SetPeers("127.0.0.1:9020", "127.0.0.1:9021", "127.0.0.1:9022")
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		peers, self := svr.PickReplicas(key, 3)
+		if self < 0 || len(peers) != 2 {
+			t.Fatalf("with 3 nodes and 3 replicas every node holds %s, self=%d peers=%d", key, self, len(peers))
+		}
+		if owner := svr.placement.Get(key); (owner == svr.addr) != (self == 0) {
+			t.Fatalf("self should be 0 only on the primary of %s", key)
+		}
+		if svr.ownerOf(key, 3) != "" {
+			t.Fatalf("%s should not be migrated away from a replica", key)
+		}
+	}
+
+	peers, self := svr.PickReplicas("key0", 1)
+	if !(self == 0 && len(peers) == 0) && !(self == -1 && len(peers) == 1) {
+		t.Fatalf("expect a single replica, got self=%d peers=%d", self, len(peers))
+	}
+}
@@ -0,0 +1,142 @@
+package geecache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"v8/geecache/consistenthash"
+	"v8/geecache/registry"
+)
+
+// MoveEstimate 是节点列表变成某个假设的列表之后，本节点缓存的 key 的移动情况
+type MoveEstimate struct {
+	Keys      int64                 `json:"keys"`       // 本节点缓存的 key 的个数
+	Moved     int64                 `json:"moved"`      // 需要迁移到其他节点的 key 的个数
+	ByPeer    map[string]int64      `json:"by_peer"`    // 按迁移的目标节点统计
+	Ring      []consistenthash.Move `json:"ring"`       // 整个哈希空间中改变归属的部分
+	RingMoved float64               `json:"ring_moved"` // Ring 中比例之和
+}
+
+// EstimateMoves 预估节点列表变成 endpoints 之后本节点缓存的 key 中有多少需要迁移，
+// 和 rebalance 的规则相同：本节点不再是 key 的副本之一时 key 迁移到新的 owner，
+// 按可用区路由时只在本可用区的节点中选择（见 route）。Ring 是路由使用的哈希环的变化。
+// 只做计算，不改变当前的节点列表
+func (s *Server) EstimateMoves(endpoints []registry.Endpoint) MoveEstimate {
+	weights := make(map[string]int, len(endpoints))
+	byAddr := make(map[string]registry.Endpoint, len(endpoints))
+	for _, ep := range endpoints {
+		weights[ep.Addr] = ep.GetWeight()
+		byAddr[ep.Addr] = ep
+	}
+	global := s.placement.Build(weights)
+	cur, next := s.placement.Snapshot(), global
+	route := func(key string) consistenthash.Placement { return global }
+	if s.zoneRing != nil {
+		curLocal, nextLocal := s.zoneRing.Snapshot(), s.zoneRing.Build(s.sameZone(byAddr))
+		route = func(key string) consistenthash.Placement { return s.routeIn(global, nextLocal, key) }
+		// 本可用区有节点或者不回退时路由使用本可用区的哈希环
+		if len(curLocal.Nodes()) > 0 || !s.zoneFallback {
+			cur = curLocal
+		}
+		if len(nextLocal.Nodes()) > 0 || !s.zoneFallback {
+			next = nextLocal
+		}
+	}
+
+	est := MoveEstimate{ByPeer: make(map[string]int64)}
+	est.Ring = consistenthash.Diff(cur, next)
+	est.RingMoved = consistenthash.MovedFraction(est.Ring)
+	for _, g := range allGroups() {
+		g.mainCache.rangeEntries(func(key string, value ByteView) bool {
+			est.Keys++
+			if owner := ownerIn(route(key), s.addr, key, g.replicas); owner != "" {
+				est.Moved++
+				est.ByPeer[owner]++
+			}
+			return true
+		})
+	}
+	return est
+}
+
+// AdminHandler 返回运维用的 HTTP 接口：
+//
+//	GET  /admin/resize?peers=a:1,b:1   预估节点列表变成 peers 之后的迁移量
+//	GET  /admin/resize?add=c:1&remove=a:1   在当前节点列表上增删节点之后的迁移量
+//	POST /admin/resize   body 是和 peers 文件相同格式的节点列表，可以带权重，
+//	                     Content-Type 包含 yaml 时按 YAML 解析，否则按 JSON
+//	GET  /admin/health   节点的健康状态，不健康时返回 503
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/resize", s.serveResize)
+	mux.HandleFunc("/admin/health", s.serveHealth)
+	return mux
+}
+
+// maxResizeBody 是 POST /admin/resize 请求体的大小上限
+const maxResizeBody = 1 << 20
+
+func (s *Server) serveResize(w http.ResponseWriter, r *http.Request) {
+	var endpoints []registry.Endpoint
+	switch r.Method {
+	case http.MethodGet:
+		endpoints = s.resizeEndpoints(r)
+	case http.MethodPost:
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxResizeBody))
+		if err == nil {
+			endpoints, err = registry.ParsePeers(data, strings.Contains(r.Header.Get("Content-Type"), "yaml"))
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid peers: %v", err), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	for _, ep := range endpoints {
+		if !validPeerAddr(ep.Addr) {
+			http.Error(w, fmt.Sprintf("invalid peer address %q", ep.Addr), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.EstimateMoves(endpoints))
+}
+
+// resizeEndpoints 从 GET 请求的参数中得到假设的节点列表，
+// 有 peers 参数时直接使用，否则在当前节点列表上增删 add 和 remove 中的节点
+func (s *Server) resizeEndpoints(r *http.Request) []registry.Endpoint {
+	query := r.URL.Query()
+	if peers := query.Get("peers"); peers != "" {
+		var endpoints []registry.Endpoint
+		for _, addr := range strings.Split(peers, ",") {
+			endpoints = append(endpoints, registry.Endpoint{Addr: addr})
+		}
+		return endpoints
+	}
+	removed := make(map[string]bool)
+	for _, addr := range splitList(query.Get("remove")) {
+		removed[addr] = true
+	}
+	var endpoints []registry.Endpoint
+	for _, ep := range s.PeerEndpoints() {
+		if !removed[ep.Addr] {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	for _, addr := range splitList(query.Get("add")) {
+		endpoints = append(endpoints, registry.Endpoint{Addr: addr})
+	}
+	return endpoints
+}
+
+// splitList 按逗号切分 s，s 为空时返回 nil
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,141 @@
+package geecache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"v8/geecache/registry"
+)
+
+func TestEstimateMoves(t *testing.T) {
+	g := NewGroup("estimate-moves", 2<<20, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	for i := 0; i < 200; i++ {
+		g.Get(context.Background(), fmt.Sprintf("key-%d", i))
+	}
+	self := "127.0.0.1:9001"
+	svr, err := NewServer(self, WithDiscovery(registry.NewStatic()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	svr.SetPeers(self)
+
+	if est := svr.EstimateMoves([]registry.Endpoint{{Addr: self}}); est.Moved != 0 || est.RingMoved != 0 || est.Keys < 200 {
+		t.Fatalf("unchanged peers should not move anything, got %+v", est)
+	}
+
+	// 只有一个节点时所有 key 都属于本节点，加入新节点后移动的 key 都去往新节点
+	est := svr.EstimateMoves([]registry.Endpoint{{Addr: self}, {Addr: "127.0.0.1:9002"}})
+	if est.Moved == 0 || est.Moved >= est.Keys || est.ByPeer["127.0.0.1:9002"] != est.Moved {
+		t.Fatalf("expect part of the keys to move to the new peer, got %+v", est)
+	}
+	if len(est.Ring) != 1 || est.Ring[0].From != self || est.RingMoved <= 0.2 || est.RingMoved >= 0.8 {
+		t.Fatalf("expect about half of the ring to move, got %+v", est.Ring)
+	}
+	if nodes := svr.placement.Nodes(); len(nodes) != 1 {
+		t.Fatalf("estimate should not change peers, got %v", nodes)
+	}
+}
+
+func TestEstimateMovesZoneAware(t *testing.T) {
+	g := NewGroup("estimate-moves-zone", 2<<20, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	for i := 0; i < 200; i++ {
+		g.Get(context.Background(), fmt.Sprintf("key-%d", i))
+	}
+	svr := newZoneServer(t, true, true, map[string]string{"127.0.0.1:9002": "az2"})
+	current := svr.PeerEndpoints()
+
+	// 其他可用区加入节点不影响本可用区的路由
+	est := svr.EstimateMoves(append(current, registry.Endpoint{Addr: "127.0.0.1:9003", Zone: "az2"}))
+	if est.Moved != 0 || est.RingMoved != 0 {
+		t.Fatalf("a node in another zone should not move keys, got %+v", est)
+	}
+
+	// 本可用区加入节点时，预估和实际迁移的结果相同
+	next := append(current, registry.Endpoint{Addr: "127.0.0.1:9004", Zone: "az1"})
+	est = svr.EstimateMoves(next)
+	if est.Moved == 0 || est.ByPeer["127.0.0.1:9004"] != est.Moved || est.RingMoved <= 0.2 {
+		t.Fatalf("expect keys to move to the new node in az1, got %+v", est)
+	}
+	svr.SetPeerEndpoints(next...)
+	moves := svr.collectMoves()
+	if len(moves) != 1 || int64(len(moves["127.0.0.1:9004"])) != est.Moved {
+		t.Fatalf("estimate %+v differs from migration %d", est, len(moves["127.0.0.1:9004"]))
+	}
+}
+
+func TestAdminResize(t *testing.T) {
+	self := "127.0.0.1:9001"
+	svr, err := NewServer(self, WithDiscovery(registry.NewStatic()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	svr.SetPeers(self, "127.0.0.1:9002")
+	ts := httptest.NewServer(svr.AdminHandler())
+	defer ts.Close()
+
+	decode := func(resp *http.Response) MoveEstimate {
+		t.Helper()
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %s", resp.Status)
+		}
+		var est MoveEstimate
+		if err := json.NewDecoder(resp.Body).Decode(&est); err != nil {
+			t.Fatal(err)
+		}
+		return est
+	}
+
+	resp, err := http.Get(ts.URL + "/admin/resize?add=127.0.0.1:9003&remove=127.0.0.1:9002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	est := decode(resp)
+	expect := svr.EstimateMoves([]registry.Endpoint{{Addr: self}, {Addr: "127.0.0.1:9003"}})
+	if est.RingMoved != expect.RingMoved || len(est.Ring) != len(expect.Ring) {
+		t.Fatalf("expect %+v, got %+v", expect, est)
+	}
+
+	body := `["127.0.0.1:9001", {"addr": "127.0.0.1:9002", "weight": 3}]`
+	resp, err = http.Post(ts.URL+"/admin/resize", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 9002 的权重变大，只会有哈希空间从 9001 移到 9002
+	if est := decode(resp); len(est.Ring) != 1 || est.Ring[0].To != "127.0.0.1:9002" {
+		t.Fatalf("expect space to move to the heavier peer, got %+v", est.Ring)
+	}
+
+	// 和 peers 文件一样支持带 peers 字段的对象和 YAML
+	for contentType, body := range map[string]string{
+		"application/json": `{"peers": ["127.0.0.1:9001", {"addr": "127.0.0.1:9002", "weight": 3}]}`,
+		"application/yaml": "peers:\n  - 127.0.0.1:9001\n  - {addr: 127.0.0.1:9002, weight: 3}\n",
+	} {
+		resp, err = http.Post(ts.URL+"/admin/resize", contentType, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if est := decode(resp); len(est.Ring) != 1 || est.Ring[0].To != "127.0.0.1:9002" {
+			t.Fatalf("%s: expect space to move to the heavier peer, got %+v", contentType, est.Ring)
+		}
+	}
+
+	resp, err = http.Get(ts.URL + "/admin/resize?peers=bad-addr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid peer should be rejected, got %s", resp.Status)
+	}
+}
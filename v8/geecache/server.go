@@ -3,9 +3,9 @@ package geecache
 import (
 	"context"
 	"fmt"
-	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc"
 	"net"
+	"sort"
 	"strings"
 	"v8/geecache/registry"
 
@@ -21,32 +21,164 @@ var (
 	defaultReplicas = 50
 )
 
-var (
-	defaultEtcdConfig = clientv3.Config{
-		Endpoints:   []string{"192.168.172.129:2379"},
-		DialTimeout: 5 * time.Second,
-	}
-
-	serviceLocker            sync.Mutex
-	serviceEndpointKeyPrefix = "geecache"
+// 节点间协议的版本范围，注册到 discovery 中，协议版本没有交集的节点之间不会互相转发请求。
+// 不兼容地修改 Get、Set 等接口时增加 ProtocolVersion，仍然能处理旧协议的请求时保留 MinProtocolVersion，
+// 这样滚动升级期间新旧节点可以共存
+const (
+	MinProtocolVersion = 1
+	ProtocolVersion    = 1
 )
 
 // server 和 Group 是解耦合的 所以server要自己实现并发控制
 type Server struct {
 	geecachepb.UnimplementedGroupCacheServer
-	addr       string     // format: ip:port
-	status     bool       // true: running false: stop
-	stopSignal chan error // 通知registry revoke服务
-	mux        sync.Mutex
-	consHash   *consistenthash.Consistency
+	addr       string             // format: ip:port
+	status     bool               // true: running false: stop
+	draining   bool               // 正在下线，不再把自己加回哈希环
+	unregister context.CancelFunc // 通知 discovery 注销本节点
+	grpcServer *grpc.Server
+	listener   net.Listener  // 监听 addr 的 socket，也可以由 WithListener 传入
+	ready      chan struct{} // 开始接受请求后关闭
+	drainDelay time.Duration // Drain 注销之后等待其他节点观察到本节点下线的时间
+	mux        sync.Mutex    // 保护 clients、endpoints、status、draining 和 inflight
 	clients    map[string]*Client
+	endpoints  map[string]registry.Endpoint // 每个节点注册的信息，和 clients 一一对应
+
+	// placement 决定每个 key 由哪个节点负责，默认是一致性哈希环。
+	// 它是原子替换的只读快照，查询时不需要持有 mux
+	placement *consistenthash.Atomic
+
+	// zoneRing 只包含和本节点同一可用区的节点，按可用区路由时代替 placement 查找 owner，
+	// 没有开启时为 nil。zoneFallback 表示本可用区找不到节点时是否使用 placement
+	zoneRing     *consistenthash.Atomic
+	zoneFallback bool
 
 	ctx    context.Context //添加上下文信息可以用于 ，程序退出 监听的停止
 	cancel context.CancelFunc
+
+	invalidateQueues map[string]chan invalidation // 每个远端节点等待发送的失效消息，由 s.mux 保护
+
+	discovery registry.Discovery // 服务注册与发现
+	self      registry.Endpoint  // 注册到 discovery 的本节点信息，其他节点据此分配虚拟节点、检查协议版本
+
+	loadEpsilon float64          // 有界负载的 ε，0 表示不限制节点的负载
+	inflight    map[string]int64 // 每个节点正在处理的请求数，由 s.mux 保护
+
+	rebalanceOnce   sync.Once
+	rebalanceSignal chan struct{} // 哈希环发生变化，需要迁移 key
+
+	// Migration 记录哈希环变化后 key 迁移的进度
+	Migration MigrationStats
+	// RPC 按方法记录本节点处理的 grpc 请求数和耗时
+	RPC RPCStats
+}
+
+// serverOptions 保存 NewServer 的可选配置
+type serverOptions struct {
+	etcd      registry.Config
+	discovery registry.Discovery
+	weight    int
+	zone      string
+	version   string
+	epsilon   float64
+
+	newPlacement func() consistenthash.Placement
+	zoneAware    bool
+	zoneFallback bool
+	drainDelay   time.Duration
+	listener     net.Listener
+}
+
+// ServerOption 用于在 NewServer 时定制 Server
+type ServerOption func(*serverOptions)
+
+// WithEtcdConfig 指定服务注册与发现使用的 etcd 配置，
+// 没有设置的字段使用 registry.DefaultConfig 中的值
+func WithEtcdConfig(cfg registry.Config) ServerOption {
+	return func(o *serverOptions) {
+		o.etcd = cfg
+	}
+}
+
+// WithDiscovery 指定服务注册与发现的方式，如 registry.NewStatic、registry.NewFile、
+// registry.NewDNS。默认使用 etcd，此时 WithEtcdConfig 才会生效
+func WithDiscovery(d registry.Discovery) ServerOption {
+	return func(o *serverOptions) {
+		o.discovery = d
+	}
+}
+
+// WithWeight 指定本节点的权重，一般和节点的内存大小成正比，
+// 哈希环上分给本节点的 key 的比例和权重成正比。默认为 1
+func WithWeight(weight int) ServerOption {
+	if weight < 1 {
+		panic("weight must be positive")
+	}
+	return func(o *serverOptions) {
+		o.weight = weight
+	}
 }
 
-// NewServer 创建cache的svr 若addr为空 则使用defaultAddr
-func NewServer(addr string) (*Server, error) {
+// WithZone 指定本节点所在的可用区，注册到 discovery 中供其他节点按可用区选择节点
+func WithZone(zone string) ServerOption {
+	return func(o *serverOptions) {
+		o.zone = zone
+	}
+}
+
+// WithVersion 指定本节点的构建版本，注册到 discovery 中，便于滚动升级时排查问题
+func WithVersion(version string) ServerOption {
+	return func(o *serverOptions) {
+		o.version = version
+	}
+}
+
+// WithPlacement 指定 key 的放置算法，如 consistenthash.NewRendezvous、
+// consistenthash.NewJump、consistenthash.NewMaglev。集群中所有节点必须使用相同的算法，
+// 默认使用 50 倍虚拟节点的一致性哈希环。
+// Jump 的桶分配取决于节点加入和删除的顺序，以不同顺序观察到节点变化的节点可能得到不同的 owner，
+// 适合节点列表固定的集群。
+func WithPlacement(newPlacement func() consistenthash.Placement) ServerOption {
+	return func(o *serverOptions) {
+		o.newPlacement = newPlacement
+	}
+}
+
+// WithDrainDelay 指定 Drain 从 discovery 注销之后、迁移 key 之前等待的时间，
+// 应当大于其他节点观察到节点下线所需的时间。默认为 2 秒
+func WithDrainDelay(d time.Duration) ServerOption {
+	return func(o *serverOptions) {
+		o.drainDelay = d
+	}
+}
+
+// WithListener 让 server 在 lis 上提供服务而不是自己监听 addr，
+// 例如测试中使用 127.0.0.1:0 随机分配的端口。NewServer 的 addr 为空时使用 lis 的地址
+func WithListener(lis net.Listener) ServerOption {
+	return func(o *serverOptions) {
+		o.listener = lis
+	}
+}
+
+// defaultPlacement 创建默认的一致性哈希环
+func defaultPlacement() consistenthash.Placement {
+	return consistenthash.New(defaultReplicas, nil)
+}
+
+// NewServer 创建cache的svr 若addr为空 则使用 WithListener 传入的 listener 的地址，
+// 都没有时使用defaultAddr
+func NewServer(addr string, opts ...ServerOption) (*Server, error) {
+	o := serverOptions{
+		etcd:         registry.DefaultConfig(),
+		newPlacement: defaultPlacement,
+		drainDelay:   defaultDrainDelay,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if addr == "" && o.listener != nil {
+		addr = o.listener.Addr().String()
+	}
 	if addr == "" {
 		addr = defaultAddr
 	}
@@ -54,11 +186,38 @@ func NewServer(addr string) (*Server, error) {
 	if !validPeerAddr(addr) {
 		return nil, fmt.Errorf("invalid addr %s, it should be x.x.x.x:port", addr)
 	}
+	if o.discovery == nil {
+		o.discovery = registry.NewEtcd(o.etcd)
+	}
 	ctx, cancel := context.WithCancel(context.Background())
+	var zoneRing *consistenthash.Atomic
+	if o.zoneAware {
+		zoneRing = consistenthash.NewAtomic(o.newPlacement)
+	}
 	return &Server{
-		addr:   addr,
-		ctx:    ctx,
-		cancel: cancel,
+		addr:      addr,
+		ctx:       ctx,
+		cancel:    cancel,
+		discovery: o.discovery,
+		self: registry.Endpoint{
+			Addr:        addr,
+			Weight:      o.weight,
+			Zone:        o.zone,
+			Version:     o.version,
+			MinProtocol: MinProtocolVersion,
+			MaxProtocol: ProtocolVersion,
+			StartTime:   time.Now().Truncate(time.Second),
+		},
+		loadEpsilon:  o.epsilon,
+		placement:    consistenthash.NewAtomic(o.newPlacement),
+		zoneRing:     zoneRing,
+		zoneFallback: o.zoneFallback,
+		drainDelay:   o.drainDelay,
+		clients:      make(map[string]*Client),
+		endpoints:    make(map[string]registry.Endpoint),
+		inflight:     make(map[string]int64),
+		listener:     o.listener,
+		ready:        make(chan struct{}),
 	}, nil
 }
 
@@ -78,103 +237,295 @@ func (s *Server) Get(ctx context.Context, in *geecachepb.Request) (*geecachepb.R
 		return resp, fmt.Errorf("group not found")
 	}
 
-	view, err := group.Get(key)
+	// 其他节点发来的请求也算作本节点的负载
+	s.acquire(s.addr)
+	defer s.release(s.addr)
+
+	// ctx 中带有调用方通过 grpc 传过来的 deadline
+	view, err := group.Get(withPeerRequest(ctx), key)
 	if err != nil {
 		return resp, err
 	}
 
 	resp.Value = view.ByteSlice()
+	if expire := view.Expire(); !expire.IsZero() {
+		resp.Expire = expire.UnixNano()
+	}
 
 	return resp, nil
 }
 
+// Set 实现GroupCache service的Set接口，只写入本节点
+func (s *Server) Set(ctx context.Context, in *geecachepb.SetRequest) (*geecachepb.Ack, error) {
+	groupName, key := in.GetGroup(), in.GetKey()
+	log.Printf("[geecache_svr %s] Recv RPC Set - (%s)/(%s)", s.addr, groupName, key)
+
+	if key == "" {
+		return nil, fmt.Errorf("key required")
+	}
+	group := GetGroup(groupName)
+	if group == nil {
+		return nil, fmt.Errorf("group not found")
+	}
+	group.setLocally(key, viewFromSetRequest(in))
+	return &geecachepb.Ack{}, nil
+}
+
+func viewFromSetRequest(in *geecachepb.SetRequest) ByteView {
+	view := ByteView{b: cloneBytes(in.GetValue())}
+	if in.GetExpire() != 0 {
+		view.e = time.Unix(0, in.GetExpire())
+	}
+	return view
+}
+
+// Remove 实现GroupCache service的Remove接口，只删除本节点的缓存
+func (s *Server) Remove(ctx context.Context, in *geecachepb.Request) (*geecachepb.Ack, error) {
+	groupName, key := in.GetGroup(), in.GetKey()
+	log.Printf("[geecache_svr %s] Recv RPC Remove - (%s)/(%s)", s.addr, groupName, key)
+
+	if key == "" {
+		return nil, fmt.Errorf("key required")
+	}
+	group := GetGroup(groupName)
+	if group == nil {
+		return nil, fmt.Errorf("group not found")
+	}
+	group.removeLocally(key)
+	return &geecachepb.Ack{}, nil
+}
+
+// Purge 实现GroupCache service的Purge接口，只清空本节点的缓存
+func (s *Server) Purge(ctx context.Context, in *geecachepb.PurgeRequest) (*geecachepb.Ack, error) {
+	groupName := in.GetGroup()
+	log.Printf("[geecache_svr %s] Recv RPC Purge - (%s)", s.addr, groupName)
+
+	group := GetGroup(groupName)
+	if group == nil {
+		return nil, fmt.Errorf("group not found")
+	}
+	group.purgeLocally()
+	return &geecachepb.Ack{}, nil
+}
+
 // SetPeers 将各个远端主机IP配置到Server里
 // 这样Server就可以Pick他们了
 // 注意: 此操作是*覆写*操作！
 // 注意: peersIP必须满足 x.x.x.x:port的格式
 func (s *Server) SetPeers(peersAddrs ...string) {
+	endpoints := make([]registry.Endpoint, 0, len(peersAddrs))
+	for _, peerAddr := range peersAddrs {
+		endpoints = append(endpoints, registry.Endpoint{Addr: peerAddr})
+	}
+	s.SetPeerEndpoints(endpoints...)
+}
+
+// SetPeerEndpoints 和 SetPeers 一样，但每个节点按照自己的权重分配虚拟节点
+func (s *Server) SetPeerEndpoints(endpoints ...registry.Endpoint) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	s.consHash = consistenthash.New(defaultReplicas, nil)
-	s.consHash.Register(peersAddrs...) //这里面的peers切片  就是 peer 的值为 http://192.168.1.100，这代表一个远程节点的地址。
-	s.clients = make(map[string]*Client, len(peersAddrs))
+	weights := make(map[string]int, len(endpoints))
+	old := s.clients
+	s.clients = make(map[string]*Client, len(endpoints))
+	s.endpoints = make(map[string]registry.Endpoint, len(endpoints))
 
-	for _, peerAddr := range peersAddrs {
+	for _, ep := range endpoints {
+		peerAddr := ep.Addr
 		if !validPeerAddr(peerAddr) {
 			panic(fmt.Sprintf("[peer %s] invalid address format, it should be x.x.x.x:port", peerAddr))
 		}
+		if !s.self.Compatible(ep) {
+			logIncompatible(s.self, ep)
+			continue
+		}
+		weights[peerAddr] = ep.GetWeight()
+		s.endpoints[peerAddr] = ep
+		// 仍然存在的 peer 继续使用原来的连接
+		if client, ok := old[peerAddr]; ok {
+			s.clients[peerAddr] = client
+			delete(old, peerAddr)
+			continue
+		}
 		service := fmt.Sprintf("geecache/%s", peerAddr)
 		s.clients[peerAddr] = NewClient(service, peerAddr)
 	}
+	// 不再存在的 peer 关闭连接
+	for addr, client := range old {
+		client.Close()
+		s.closeInvalidateQueueLocked(addr)
+	}
+	// 所有节点一次性替换，查询方不会看到只注册了一部分节点的哈希环
+	s.placement.Set(weights)
+	if s.zoneRing != nil {
+		s.zoneRing.Set(s.sameZone(s.endpoints))
+	}
 }
 
 // PickPeer 根据一致性哈希选举出key应存放在的cache
 // return false 代表从本地获取cache,或者是没找到peerAddr == ""
 func (s *Server) PickPeer(key string) (peer Fetcher, ok bool) {
+	peerAddr := s.route(key).Get(key)
+	if peerAddr == "" || peerAddr == s.addr {
+		return nil, false
+	}
+	if peer, ok = s.client(peerAddr); ok {
+		log.Printf("[cache %s] pick remote peer: %s\n", s.addr, peerAddr)
+	}
+	return peer, ok
+}
+
+// client 返回 addr 对应的客户端
+func (s *Server) client(addr string) (*Client, bool) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	if peerAddr := s.consHash.Get(key); peerAddr != "" && peerAddr != s.addr {
-		log.Printf("[cache %s] pick remote peer: %s\n", s.addr, peerAddr)
-		return s.clients[peerAddr], true
+	client, ok := s.clients[addr]
+	return client, ok
+}
+
+// PickReplicas 根据一致性哈希选出 key 的 n 个副本节点
+func (s *Server) PickReplicas(key string, n int) (peers []Fetcher, self int) {
+	owners := s.route(key).GetN(key, n)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	self = -1
+	for i, peerAddr := range owners {
+		if peerAddr == s.addr {
+			self = i
+			continue
+		}
+		if client, ok := s.clients[peerAddr]; ok {
+			peers = append(peers, client)
+		}
+	}
+	return peers, self
+}
+
+// ListPeers 返回除自己以外的所有节点
+func (s *Server) ListPeers() []Fetcher {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	peers := make([]Fetcher, 0, len(s.clients))
+	for addr, client := range s.clients {
+		if addr != s.addr {
+			peers = append(peers, client)
+		}
 	}
-	return nil, false
+	return peers
 }
 
 // 测试Server是否实现了Picker接口
 var _ PeerPicker = (*Server)(nil)
+var _ PeerLister = (*Server)(nil)
+var _ ReplicaPicker = (*Server)(nil)
 
-// Start 启动cache服务
-func (s *Server) Start() error {
-	s.mux.Lock()
-	if s.status == true {
-		s.mux.Unlock()
-		return fmt.Errorf("server already started")
-	}
-	// -----------------启动服务----------------------
-	// 1. 设置status为true 表示服务器已在运行
-	// 2. 初始化stop channal,这用于通知registry stop keep alive
-	// 3. 初始化tcp socket并开始监听
-	// 4. 注册rpc服务至grpc 这样grpc收到request可以分发给server处理
-	// 5. 将自己的服务名/Host地址注册至etcd 这样client可以通过etcd
-	//    获取服务Host地址 从而进行通信。这样的好处是client只需知道服务名
-	//    以及etcd的Host即可获取对应服务IP 无需写死至client代码中
-	// ----------------------------------------------
-	s.status = true
-	s.stopSignal = make(chan error)
-	port := strings.Split(s.addr, ":")[1]
-	lis, err := net.Listen("tcp", ":"+port)
+// Serve 启动cache服务并阻塞，直到 Stop 或 Drain 之后返回 nil，
+// 监听失败或者 grpc 服务异常退出时返回错误
+func (s *Server) Serve() error {
+	lis, registerCtx, err := s.listen()
 	if err != nil {
-		return fmt.Errorf("failed to listen: %v", err)
+		return err
 	}
-	grpcServer := grpc.NewServer()
-	geecachepb.RegisterGroupCacheServer(grpcServer, s)
+	return s.serve(lis, registerCtx)
+}
 
-	// 注册服务至etcd
+// Start 在后台启动cache服务，开始接受请求之后返回，
+// 监听失败时返回错误。之后 grpc 服务异常退出的错误只会打印到日志
+func (s *Server) Start() error {
+	lis, registerCtx, err := s.listen()
+	if err != nil {
+		return err
+	}
 	go func() {
-		// Register never return unless stop singnal received
-		err := registry.Register("geecache", s.addr, s.stopSignal)
-		if err != nil {
-			log.Fatalf(err.Error())
+		if err := s.serve(lis, registerCtx); err != nil {
+			log.Printf("[geecache_svr %s] %v", s.addr, err)
+		}
+	}()
+	<-s.ready
+	return nil
+}
+
+// Ready 返回的 channel 在 server 开始接受请求后被关闭
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Addr 返回 server 实际监听的地址，还没有开始监听时返回节点地址
+func (s *Server) Addr() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.listener != nil {
+		return s.listener.Addr().String()
+	}
+	return s.addr
+}
+
+// listen 准备启动服务：
+// 1. 设置status为true 表示服务器已在运行
+// 2. 初始化 unregister,这用于通知 discovery 注销本节点，返回的 ctx 用于注册
+// 3. 初始化tcp socket并开始监听，WithListener 传入的 listener 直接使用
+// 4. 注册rpc服务至grpc 这样grpc收到request可以分发给server处理
+func (s *Server) listen() (net.Listener, context.Context, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.status {
+		return nil, nil, fmt.Errorf("server already started")
+	}
+	if s.grpcServer != nil {
+		return nil, nil, fmt.Errorf("server already stopped")
+	}
+	lis := s.listener
+	if lis == nil {
+		port := strings.Split(s.addr, ":")[1]
+		var err error
+		if lis, err = net.Listen("tcp", ":"+port); err != nil {
+			return nil, nil, fmt.Errorf("failed to listen: %v", err)
 		}
-		// Close channel
-		close(s.stopSignal)
-		// Close tcp listen
-		err = lis.Close()
+		s.listener = lis
+	}
+	s.status = true
+	registerCtx, unregister := context.WithCancel(s.ctx)
+	s.unregister = unregister
+	s.grpcServer = grpc.NewServer(
+		grpc.ChainUnaryInterceptor(s.unaryStats, s.unaryTrace),
+		grpc.ChainStreamInterceptor(s.streamStats, s.streamTrace))
+	geecachepb.RegisterGroupCacheServer(s.grpcServer, s)
+	return lis, registerCtx, nil
+}
+
+// serve 将自己的Host地址注册至 discovery(默认是etcd) 这样其他节点可以通过
+// discovery 获取服务Host地址 从而进行通信。这样的好处是节点只需知道
+// discovery 的配置即可获取对应服务IP 无需写死至代码中。
+// 然后开始服务发现和处理请求
+func (s *Server) serve(lis net.Listener, registerCtx context.Context) error {
+	s.mux.Lock()
+	grpcServer := s.grpcServer
+	s.mux.Unlock()
+
+	// 注册服务至 discovery
+	go func() {
+		// Register never return unless unregister called
+		// discovery 不可用时 Register 自己重试，注册状态通过 Health 报告，
+		// 返回错误也不应该让正在服务的节点退出
+		err := s.discovery.Register(registerCtx, s.Endpoint())
 		if err != nil {
-			log.Fatalf(err.Error())
+			log.Printf("[%s] register service failed: %v", s.addr, err)
+			return
 		}
-		log.Printf("[%s] Revoke service and close tcp socket ok.", s.addr)
+		// 注销之后仍然继续服务，tcp socket 由 grpcServer 停止时关闭
+		log.Printf("[%s] Revoke service ok.", s.addr)
 	}()
-	//log.Printf("[%s] register service ok\n", s.addr)
-	s.mux.Unlock()
 
-	// ✅ 然后再进行服务发现
-	// 等待片刻，确保注册成功（最保险）
-	time.Sleep(500 * time.Millisecond)
-	go ServiceDiscovery(s)
+	// 本节点在启动前已经加入了哈希环，注册事件晚一点到达也没有关系，
+	// 不需要等待注册完成再开始服务发现
+	ServiceDiscovery(s)
+	close(s.ready)
 
-	if err := grpcServer.Serve(lis); s.status && err != nil {
+	err := grpcServer.Serve(lis)
+	s.mux.Lock()
+	running := s.status
+	s.mux.Unlock()
+	if running && err != nil {
 		return fmt.Errorf("failed to serve: %v", err)
 	}
 	return nil
@@ -182,12 +533,6 @@ func (s *Server) Start() error {
 
 // ServiceDiscovery 服务发现
 func ServiceDiscovery(s *Server) {
-	cli, err := clientv3.New(defaultEtcdConfig)
-	if err != nil {
-		log.Printf("create etcd client failed: %v", err)
-		return
-	}
-
 	go func() {
 		//程序崩溃可以恢复过来
 		defer func() {
@@ -196,108 +541,156 @@ func ServiceDiscovery(s *Server) {
 			}
 		}()
 
-		//ctx := context.Background()
-		serviceKey := fmt.Sprintf("%s/", serviceEndpointKeyPrefix)
 		// 获取当前所有服务入口
-		getRes, err := cli.Get(s.ctx, serviceKey, clientv3.WithPrefix())
+		peers, err := s.discovery.Peers(s.ctx)
 		if err != nil {
-			log.Printf("initial etcd get failed: %v", err)
+			log.Printf("initial get peers failed: %v", err)
 			return
 		}
 
-		//在main函数中注册了 哈希环，到开启发现etcd服务 之间可能有新的节点上线 进行处理
-		for _, v := range getRes.Kvs {
-			// 💡注册 client & 哈希环（避免重复）
-			serviceLocker.Lock()
-			if _, ok := s.clients[string(v.Value)]; !ok {
-				s.clients[string(v.Value)] = NewClient(string(v.Key), string(v.Value))
-				s.consHash.Register(string(v.Value))
+		//在main函数中注册了 哈希环，到开启服务发现 之间可能有新的节点上线 进行处理
+		for _, ep := range peers {
+			s.addPeer(ep)
+		}
+
+		fmt.Printf("[service_endpoint_change] service get endpoints success\n")
+		ch, err := s.discovery.Watch(s.ctx)
+		if err != nil {
+			log.Printf("watch peers failed: %v", err)
+			return
+		}
+		// ctx 取消后 ch 会被关闭
+		for ev := range ch {
+			switch ev.Type {
+			// PUT，新增或替换
+			case registry.EventPut:
+				s.addPeer(ev.Endpoint)
+			// DELETE
+			case registry.EventDelete:
+				s.removePeer(ev.Addr)
 			}
-			serviceLocker.Unlock()
+			// 哈希环变化后，把本节点缓存中现在属于其他节点的 key 迁移过去，
+			// 避免新的节点冷启动时把请求都压到数据源上。迁移期间的请求仍然按新的哈希环路由
+			s.scheduleRebalance()
 		}
+		log.Println("Service discovery exited due to context cancellation.")
+	}()
+}
 
-		fmt.Printf("[service_endpoint_change] [%s] service %s get endpoints success\n", serviceEndpointKeyPrefix, serviceEndpointKeyPrefix)
-		ch := cli.Watch(s.ctx, serviceKey, clientv3.WithPrefix(), clientv3.WithPrevKV())
-		for {
-			select {
-			case <-s.ctx.Done():
-				log.Println("Service discovery exited due to context cancellation.")
-				return
-			case v, ok := <-ch:
-				if !ok {
-					log.Println("Watch channel closed, attempting to re-watch...")
-					time.Sleep(time.Second)
-					ch = cli.Watch(s.ctx, serviceKey, clientv3.WithPrefix(), clientv3.WithPrevKV())
-					continue
-				}
-				for _, v := range v.Events {
-					key := string(v.Kv.Key) //这里的key应该是前缀加addr:port
-					endpoint := string(v.Kv.Value)
-					switch v.Type {
-					// PUT，新增或替换   , todo 先考虑 新增节点的情况
-					case clientv3.EventTypePut:
-						//增加对应的客户端 增加对应的哈希环节点
-						serviceLocker.Lock()
-						if old := s.clients[endpoint]; old != nil {
-							// todo 可能只是元数据更新，不重复注册 client
-						} else {
-							s.clients[endpoint] = NewClient(key, endpoint)
-							s.consHash.Register(endpoint) //哈希环上要注册
-						}
-						serviceLocker.Unlock()
-
-						//todo  应该还要发生哈希环数据的迁移，确保一致性
-						// todo: 需要对临界资源哈希环进行更新，同时还要迁移哈希环上的数据，要确保数据的同步，如果迁移过程中又有新的访问，怎么进行同步呢访问
-					// DELETE
-					case clientv3.EventTypeDelete:
-						//删除节点
-						serviceLocker.Lock()
-						if _, ok := s.clients[endpoint]; ok {
-							delete(s.clients, endpoint)
-							s.consHash.Destroy(endpoint)
-						}
-						serviceLocker.Unlock()
-						////todo: 删除也需要更新hash 环，同时还要控制同步问题，删除过程中 一个请求过来了咋办？
-					}
-				}
+// addPeer 增加对应的客户端 增加对应的哈希环节点，已经存在的节点不重复注册，
+// 只在权重变化时按新的权重重新注册到哈希环上
+func (s *Server) addPeer(ep registry.Endpoint) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	addr := ep.Addr
+	if s.draining && addr == s.addr {
+		// 注销事件之前的旧事件，不能把正在下线的本节点加回去
+		return
+	}
+	if !s.self.Compatible(ep) {
+		// 滚动升级时节点可能换成了不兼容的版本，不再把请求转发给它
+		logIncompatible(s.self, ep)
+		s.removePeerLocked(addr)
+		return
+	}
+	if _, ok := s.clients[addr]; !ok {
+		s.clients[addr] = NewClient(fmt.Sprintf("geecache/%s", addr), addr)
+	}
+	s.endpoints[addr] = ep
+	s.placement.RegisterWeighted(addr, ep.GetWeight()) //哈希环上要注册，权重不变时什么都不做
+	s.updateZone(ep)
+}
 
-			}
+// removePeer 删除下线节点的客户端和哈希环节点
+func (s *Server) removePeer(addr string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.removePeerLocked(addr)
+}
+
+// removePeerLocked 和 removePeer 一样，调用方需要持有 s.mux
+func (s *Server) removePeerLocked(addr string) {
+	if client, ok := s.clients[addr]; ok {
+		delete(s.clients, addr)
+		delete(s.endpoints, addr)
+		s.placement.Destroy(addr)
+		if s.zoneRing != nil {
+			s.zoneRing.Destroy(addr)
 		}
+		client.Close() // 关闭与下线节点的长连接
+		s.closeInvalidateQueueLocked(addr)
+	}
+}
 
-	}()
+// logIncompatible 记录因为协议版本不兼容而没有加入哈希环的节点
+func logIncompatible(self, ep registry.Endpoint) {
+	lo, hi := ep.Protocols()
+	selfLo, selfHi := self.Protocols()
+	log.Printf("[geecache_svr %s] skip peer %s (version %q): protocol [%d, %d] is incompatible with [%d, %d]",
+		self.Addr, ep.Addr, ep.Version, lo, hi, selfLo, selfHi)
 }
 
-// Stop 停止server运行 如果server没有运行 这将是一个no-op
+// Stop 立即停止server运行，正在处理的请求会被中断，需要平滑下线时使用 Drain。
+// 如果server没有运行 这将是一个no-op
 func (s *Server) Stop() {
 	s.mux.Lock() //第一个进去的拿到锁，将s.status设成false，后面的直接等待然后return
 	if s.status == false {
 		s.mux.Unlock()
 		return
 	}
-	s.stopSignal <- nil // 发送停止keepalive信号
-	s.status = false    // 设置server运行状态为stop
-	s.clients = nil     // 清空一致性哈希信息 有助于垃圾回收
-	s.consHash = nil
+	s.unregister()   // 通知 discovery 注销本节点
+	s.status = false // 设置server运行状态为stop
+	grpcServer := s.grpcServer
 	s.mux.Unlock()
+
+	grpcServer.Stop()
+	s.shutdown()
 }
 
-// 从etcd获取peer地址
-func (s *Server) GetPeersFromEtcd() ([]string, error) {
-	cli, err := clientv3.New(defaultEtcdConfig)
-	if err != nil {
-		return nil, fmt.Errorf("create etcd client failed: %v", err)
+// shutdown 停止服务发现和后台协程，关闭所有客户端并清空哈希环。
+// clients 换成空的 map 而不是 nil，之后的 PickPeer 只会选不到节点
+func (s *Server) shutdown() {
+	s.cancel()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, client := range s.clients {
+		client.Close()
 	}
-	defer cli.Close()
-
-	serviceKey := fmt.Sprintf("%s/", serviceEndpointKeyPrefix)
-	getRes, err := cli.Get(s.ctx, serviceKey, clientv3.WithPrefix())
-	if err != nil {
-		return nil, fmt.Errorf("initial etcd get failed: %v", err)
+	s.clients = make(map[string]*Client) // 清空一致性哈希信息 有助于垃圾回收
+	s.endpoints = make(map[string]registry.Endpoint)
+	s.placement.Set(nil)
+	if s.zoneRing != nil {
+		s.zoneRing.Set(nil)
 	}
+}
+
+// Endpoint 返回本节点注册到 discovery 的信息
+func (s *Server) Endpoint() registry.Endpoint {
+	return s.self
+}
 
-	var endpoints []string
-	for _, v := range getRes.Kvs {
-		endpoints = append(endpoints, string(v.Value))
+// PeerEndpoint 返回节点 addr 注册的信息，picker 可以据此按可用区、版本选择节点。
+// 节点不在哈希环上时返回 false
+func (s *Server) PeerEndpoint(addr string) (registry.Endpoint, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	ep, ok := s.endpoints[addr]
+	return ep, ok
+}
+
+// PeerEndpoints 返回哈希环上所有节点注册的信息，按地址排序
+func (s *Server) PeerEndpoints() []registry.Endpoint {
+	s.mux.Lock()
+	endpoints := make([]registry.Endpoint, 0, len(s.endpoints))
+	for _, ep := range s.endpoints {
+		endpoints = append(endpoints, ep)
 	}
-	return endpoints, nil
+	s.mux.Unlock()
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Addr < endpoints[j].Addr })
+	return endpoints
+}
+
+// GetPeers 从 discovery 获取所有节点
+func (s *Server) GetPeers() ([]registry.Endpoint, error) {
+	return s.discovery.Peers(s.ctx)
 }
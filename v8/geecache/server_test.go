@@ -0,0 +1,552 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"v8/geecache/consistenthash"
+	"v8/geecache/geecachepb"
+	"v8/geecache/registry"
+)
+
+func TestServerUpdate(t *testing.T) {
+	gee := NewGroup("server-update", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}))
+	svr, err := NewServer("127.0.0.1:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := svr.Set(ctx, &geecachepb.SetRequest{Group: gee.name, Key: "Tom", Value: []byte("630")}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := svr.Get(ctx, &geecachepb.Request{Group: gee.name, Key: "Tom"})
+	if err != nil || string(resp.GetValue()) != "630" {
+		t.Fatalf("expect 630 after Set, got %s err=%v", resp.GetValue(), err)
+	}
+
+	if _, err := svr.Remove(ctx, &geecachepb.Request{Group: gee.name, Key: "Tom"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gee.mainCache.get("Tom"); ok {
+		t.Fatalf("Tom should be removed")
+	}
+
+	svr.Get(ctx, &geecachepb.Request{Group: gee.name, Key: "Jack"})
+	if _, err := svr.Purge(ctx, &geecachepb.PurgeRequest{Group: gee.name}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gee.mainCache.get("Jack"); ok {
+		t.Fatalf("Jack should be purged")
+	}
+
+	if _, err := svr.Set(ctx, &geecachepb.SetRequest{Group: "unknown", Key: "Tom"}); err == nil {
+		t.Fatalf("Set to unknown group should fail")
+	}
+}
+
+func TestNewServerEtcdConfig(t *testing.T) {
+	svr, err := NewServer("127.0.0.1:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	etcd, ok := svr.discovery.(*registry.Etcd)
+	if !ok || !reflect.DeepEqual(etcd.Config(), registry.DefaultConfig()) {
+		t.Fatalf("expect etcd discovery with default config, got %#v", svr.discovery)
+	}
+
+	svr, err = NewServer("127.0.0.1:9001", WithEtcdConfig(registry.Config{
+		Endpoints: []string{"10.0.0.1:2379", "10.0.0.2:2379"},
+		Prefix:    "cache",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := svr.discovery.(*registry.Etcd).Config()
+	if len(cfg.Endpoints) != 2 || cfg.KeyPrefix() != "cache/" {
+		t.Fatalf("etcd config not applied, got %+v", cfg)
+	}
+	if cfg.LeaseTTL != registry.DefaultConfig().LeaseTTL || cfg.DialTimeout != registry.DefaultConfig().DialTimeout {
+		t.Fatalf("unset fields should use defaults, got %+v", cfg)
+	}
+}
+
+func TestServerStaticDiscovery(t *testing.T) {
+	svr, err := NewServer("127.0.0.1:9001", WithDiscovery(registry.NewStatic("127.0.0.1:9001", "127.0.0.1:9002")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	peers, err := svr.GetPeers()
+	if err != nil || len(peers) != 2 {
+		t.Fatalf("expect 2 peers, got %v err=%v", peers, err)
+	}
+
+	svr.SetPeers("127.0.0.1:9001")
+	ServiceDiscovery(svr)
+	deadline := time.Now().Add(time.Second)
+	for {
+		svr.mux.Lock()
+		_, ok := svr.clients["127.0.0.1:9002"]
+		svr.mux.Unlock()
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("peer from static discovery should be added")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// srvResolver 模拟 kubernetes headless service：SRV 记录的 target 是域名
+type srvResolver map[string]string
+
+func (r srvResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	var srvs []*net.SRV
+	for host := range r {
+		srvs = append(srvs, &net.SRV{Target: host + ".", Port: 9001})
+	}
+	return name, srvs, nil
+}
+
+func (r srvResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return []net.IPAddr{{IP: net.ParseIP(r[host])}}, nil
+}
+
+func TestServerDNSPeers(t *testing.T) {
+	dns := registry.NewDNSWithResolver("grpc", "tcp", "geecache.default.svc.cluster.local", 0, srvResolver{
+		"geecache-0.geecache.default.svc.cluster.local": "127.0.0.1",
+		"geecache-1.geecache.default.svc.cluster.local": "127.0.0.2",
+	})
+	svr, err := NewServer("127.0.0.1:9001", WithDiscovery(dns))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+
+	peers, err := dns.Peers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr.SetPeerEndpoints(peers...)
+	// 本节点用 IP 地址注册，DNS 发现的节点也必须是 IP 地址才能认出自己
+	if nodes := svr.placement.Nodes(); !reflect.DeepEqual(nodes, []string{"127.0.0.1:9001", "127.0.0.2:9001"}) {
+		t.Fatalf("expect DNS peers by IP, got %v", nodes)
+	}
+}
+
+func TestServerWeightedPeers(t *testing.T) {
+	svr, err := NewServer("127.0.0.1:9001", WithDiscovery(registry.NewStatic()), WithWeight(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	if ep := svr.Endpoint(); ep.Addr != "127.0.0.1:9001" || ep.Weight != 2 {
+		t.Fatalf("unexpected endpoint %v", ep)
+	}
+
+	svr.SetPeerEndpoints(svr.Endpoint(), registry.Endpoint{Addr: "127.0.0.1:9002"})
+	if w := svr.placement.Weight("127.0.0.1:9001"); w != 2 {
+		t.Fatalf("expect weight 2 for self, got %d", w)
+	}
+	if w := svr.placement.Weight("127.0.0.1:9002"); w != 1 {
+		t.Fatalf("expect default weight 1, got %d", w)
+	}
+
+	// 节点权重变化时重新注册虚拟节点，客户端保持不变
+	client := svr.clients["127.0.0.1:9002"]
+	svr.addPeer(registry.Endpoint{Addr: "127.0.0.1:9002", Weight: 4})
+	if w := svr.placement.Weight("127.0.0.1:9002"); w != 4 {
+		t.Fatalf("expect weight 4 after update, got %d", w)
+	}
+	if svr.clients["127.0.0.1:9002"] != client {
+		t.Fatalf("client should be reused when only weight changes")
+	}
+	svr.removePeer("127.0.0.1:9002")
+	if w := svr.placement.Weight("127.0.0.1:9002"); w != 0 {
+		t.Fatalf("removed peer should have no weight, got %d", w)
+	}
+}
+
+func TestServerPeerMetadata(t *testing.T) {
+	svr, err := NewServer("127.0.0.1:9001", WithDiscovery(registry.NewStatic()), WithZone("az1"), WithVersion("v1.2.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	self := svr.Endpoint()
+	if self.Zone != "az1" || self.Version != "v1.2.0" || self.MaxProtocol != ProtocolVersion || self.StartTime.IsZero() {
+		t.Fatalf("unexpected endpoint %+v", self)
+	}
+
+	legacy := registry.Endpoint{Addr: "127.0.0.1:9002"} // 旧版本的节点只注册了地址
+	zoned := registry.Endpoint{Addr: "127.0.0.1:9003", Zone: "az2", Version: "v1.3.0", MinProtocol: 1, MaxProtocol: ProtocolVersion + 1}
+	future := registry.Endpoint{Addr: "127.0.0.1:9004", MinProtocol: ProtocolVersion + 1, MaxProtocol: ProtocolVersion + 1}
+	svr.SetPeerEndpoints(self, legacy, zoned, future)
+	if got := registry.Addrs(svr.PeerEndpoints()); !reflect.DeepEqual(got, []string{"127.0.0.1:9001", "127.0.0.1:9002", "127.0.0.1:9003"}) {
+		t.Fatalf("incompatible peer should be skipped, got %v", got)
+	}
+	if ep, ok := svr.PeerEndpoint(zoned.Addr); !ok || ep.Zone != "az2" || ep.Version != "v1.3.0" {
+		t.Fatalf("expect metadata of %s, got %+v", zoned.Addr, ep)
+	}
+	if w := svr.placement.Weight(future.Addr); w != 0 {
+		t.Fatalf("incompatible peer should not be on the ring, got weight %d", w)
+	}
+
+	// 节点升级成不兼容的版本后从哈希环上删除
+	svr.addPeer(registry.Endpoint{Addr: zoned.Addr, MinProtocol: ProtocolVersion + 1, MaxProtocol: ProtocolVersion + 2})
+	if _, ok := svr.PeerEndpoint(zoned.Addr); ok || svr.placement.Weight(zoned.Addr) != 0 {
+		t.Fatalf("peer upgraded to an incompatible version should be removed")
+	}
+	svr.addPeer(future)
+	if _, ok := svr.PeerEndpoint(future.Addr); ok {
+		t.Fatalf("incompatible peer should not be added")
+	}
+}
+
+func TestServerPlacement(t *testing.T) {
+	svr, err := NewServer("127.0.0.1:9001", WithDiscovery(registry.NewStatic()),
+		WithPlacement(func() consistenthash.Placement { return consistenthash.NewMaglev(0, nil) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	addrs := []string{"127.0.0.1:9001", "127.0.0.1:9002", "127.0.0.1:9003"}
+	svr.SetPeers(addrs...)
+	if _, ok := svr.placement.Snapshot().(*consistenthash.Maglev); !ok {
+		t.Fatalf("expect maglev placement, got %T", svr.placement.Snapshot())
+	}
+
+	// PickPeer 和 PickReplicas 都按 placement 的结果选择节点
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owners := svr.placement.GetN(key, 2)
+		peer, ok := svr.PickPeer(key)
+		if (owners[0] == svr.addr) == ok || (ok && peer.(*Client).addr != owners[0]) {
+			t.Fatalf("PickPeer(%s) should pick %s", key, owners[0])
+		}
+		peers, self := svr.PickReplicas(key, 2)
+		count := len(peers)
+		if self >= 0 {
+			count++
+		}
+		if count != 2 {
+			t.Fatalf("PickReplicas(%s) should return 2 replicas, got %d peers self=%d", key, len(peers), self)
+		}
+	}
+}
+
+// TestServerConcurrentMembership 在 -race 下检查服务发现修改节点的同时选择节点
+func TestServerConcurrentMembership(t *testing.T) {
+	svr, err := NewServer("127.0.0.1:9001", WithDiscovery(registry.NewStatic()), WithBoundedLoad(0.25))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	svr.SetPeers("127.0.0.1:9001", "127.0.0.1:9002")
+
+	var writers, readers sync.WaitGroup
+	stop := make(chan struct{})
+	writers.Add(1)
+	go func() {
+		defer writers.Done()
+		for i := 0; i < 50; i++ {
+			addr := fmt.Sprintf("127.0.0.1:%d", 9100+i%5)
+			svr.addPeer(registry.Endpoint{Addr: addr, Weight: 1 + i%2})
+			svr.removePeer(addr)
+		}
+	}()
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := fmt.Sprintf("key-%d", i)
+				svr.PickPeer(key)
+				svr.PickReplicas(key, 2)
+				_, _, done := svr.PickLoaded(key)
+				done()
+			}
+		}()
+	}
+	writers.Wait()
+	close(stop)
+	readers.Wait()
+
+	if nodes := svr.placement.Nodes(); len(nodes) != 2 {
+		t.Fatalf("expect the 2 initial nodes, got %v", nodes)
+	}
+	if loads := svr.Loads(); len(loads) != 0 {
+		t.Fatalf("loads should be released, got %v", loads)
+	}
+}
+
+// startTestPeer 在随机端口上启动一个 grpc 服务，返回监听地址
+func startTestPeer(t testing.TB, srv geecachepb.GroupCacheServer) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	geecachepb.RegisterGroupCacheServer(grpcServer, srv)
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+	return lis.Addr().String()
+}
+
+// invalidatePeer 记录收到的失效消息，前 failures 次调用返回错误
+type invalidatePeer struct {
+	geecachepb.UnimplementedGroupCacheServer
+	mux      sync.Mutex
+	failures int
+	keys     []string
+	done     chan struct{}
+}
+
+func (p *invalidatePeer) Invalidate(ctx context.Context, in *geecachepb.InvalidateRequest) (*geecachepb.Ack, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return nil, status.Error(codes.Unavailable, "temporary failure")
+	}
+	p.keys = append(p.keys, in.GetGroup()+"/"+in.GetKey())
+	close(p.done)
+	return &geecachepb.Ack{}, nil
+}
+
+func TestBroadcastInvalidate(t *testing.T) {
+	old := invalidateRetry
+	invalidateRetry.backoff = time.Millisecond
+	defer func() { invalidateRetry = old }()
+	gee := NewGroup("invalidate", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+
+	healthy := &invalidatePeer{done: make(chan struct{})}
+	flaky := &invalidatePeer{failures: 2, done: make(chan struct{})}
+	self := "127.0.0.1:9002"
+	svr, err := NewServer(self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr.SetPeers(self, startTestPeer(t, healthy), startTestPeer(t, flaky))
+	gee.RegisterPeers(svr)
+
+	gee.Set(context.Background(), "Tom", []byte("630"))
+	if err := Invalidate(gee.name, "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gee.mainCache.get("Tom"); ok {
+		t.Fatalf("Tom should be removed locally")
+	}
+
+	for _, p := range []*invalidatePeer{healthy, flaky} {
+		select {
+		case <-p.done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("invalidation not delivered")
+		}
+		if len(p.keys) != 1 || p.keys[0] != "invalidate/Tom" {
+			t.Fatalf("unexpected invalidations %v", p.keys)
+		}
+	}
+}
+
+// hangingPeer 不响应失效消息，直到请求超时
+type hangingPeer struct {
+	geecachepb.UnimplementedGroupCacheServer
+	calls atomic.Int64
+}
+
+func (p *hangingPeer) Invalidate(ctx context.Context, in *geecachepb.InvalidateRequest) (*geecachepb.Ack, error) {
+	p.calls.Add(1)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// countingPeer 按顺序记录收到的失效消息
+type countingPeer struct {
+	geecachepb.UnimplementedGroupCacheServer
+	keys chan string
+}
+
+func (p *countingPeer) Invalidate(ctx context.Context, in *geecachepb.InvalidateRequest) (*geecachepb.Ack, error) {
+	p.keys <- in.GetKey()
+	return &geecachepb.Ack{}, nil
+}
+
+func TestBroadcastInvalidateSlowPeer(t *testing.T) {
+	oldSize, oldRetry := invalidateQueueSize, invalidateRetry
+	invalidateQueueSize = 2
+	invalidateRetry = retryPolicy{timeout: 20 * time.Millisecond, backoff: time.Millisecond, maxBackoff: 5 * time.Millisecond}
+	defer func() { invalidateQueueSize, invalidateRetry = oldSize, oldRetry }()
+
+	hanging := &hangingPeer{}
+	healthy := &countingPeer{keys: make(chan string, 10)}
+	self := "127.0.0.1:9003"
+	svr, err := NewServer(self, WithDiscovery(registry.NewStatic()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	hangingAddr, healthyAddr := startTestPeer(t, hanging), startTestPeer(t, healthy)
+	svr.SetPeers(self, hangingAddr, healthyAddr)
+
+	// 没有响应的节点只会让自己的队列积压，其他节点按顺序收到所有消息
+	full := 0
+	for i := 0; i < 6; i++ {
+		if err := svr.BroadcastInvalidate("g", strconv.Itoa(i)); err == ErrInvalidateQueueFull {
+			full++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case key := <-healthy.keys:
+			if key != strconv.Itoa(i) {
+				t.Fatalf("expect key %d, got %s", i, key)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("healthy peer should not wait for the hanging one")
+		}
+	}
+	if full == 0 {
+		t.Fatalf("expect the queue of the hanging peer to be full")
+	}
+	// 超时之后会继续重试同一条消息
+	deadline := time.Now().Add(2 * time.Second)
+	for hanging.calls.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect retries after timeout, got %d calls", hanging.calls.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 节点离开集群后不再重试，队列也被删除
+	svr.SetPeers(self, healthyAddr)
+	svr.mux.Lock()
+	_, ok := svr.invalidateQueues[hangingAddr]
+	svr.mux.Unlock()
+	if ok {
+		t.Fatalf("queue of the removed peer should be closed")
+	}
+	time.Sleep(50 * time.Millisecond)
+	calls := hanging.calls.Load()
+	time.Sleep(100 * time.Millisecond)
+	if n := hanging.calls.Load(); n != calls {
+		t.Fatalf("removed peer should not be retried, calls %d -> %d", calls, n)
+	}
+}
+
+// recordingServer 在转发给 Server 之前记录收到的失效消息的 group
+type recordingServer struct {
+	*Server
+	mux    sync.Mutex
+	groups []string
+}
+
+func (p *recordingServer) Invalidate(ctx context.Context, in *geecachepb.InvalidateRequest) (*geecachepb.Ack, error) {
+	p.mux.Lock()
+	p.groups = append(p.groups, in.GetGroup())
+	p.mux.Unlock()
+	return p.Server.Invalidate(ctx, in)
+}
+
+func TestBroadcastInvalidatePermanentError(t *testing.T) {
+	old := invalidateRetry
+	invalidateRetry.backoff = time.Millisecond
+	defer func() { invalidateRetry = old }()
+
+	gee := NewGroup("invalidate-permanent", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	peerSvr, err := NewServer("127.0.0.1:9005", WithDiscovery(registry.NewStatic()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peerSvr.cancel()
+	peer := &recordingServer{Server: peerSvr}
+	self := "127.0.0.1:9004"
+	svr, err := NewServer(self, WithDiscovery(registry.NewStatic()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.cancel()
+	svr.SetPeers(self, startTestPeer(t, peer))
+
+	// 对方没有这个 group，重试也不会成功，后面的消息不能被它卡住
+	gee.mainCache.add("Tom", ByteView{b: []byte("630")})
+	if err := svr.BroadcastInvalidate("no-such-group", "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if err := svr.BroadcastInvalidate(gee.name, "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := gee.mainCache.get("Tom"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("invalidation after a permanent error should be delivered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	peer.mux.Lock()
+	defer peer.mux.Unlock()
+	if !reflect.DeepEqual(peer.groups, []string{"no-such-group", gee.name}) {
+		t.Fatalf("permanent error should not be retried, got %v", peer.groups)
+	}
+}
+
+func TestFetchDeadline(t *testing.T) {
+	deadlines := make(chan time.Time, 1)
+	gee := NewGroup("fetch-deadline", 2<<10, RetrieverCtxFunc(func(ctx context.Context, key string) ([]byte, error) {
+		deadline, _ := ctx.Deadline()
+		deadlines <- deadline
+		return []byte("db-" + key), nil
+	}))
+	svr, err := NewServer("127.0.0.1:9003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient("geecache/peer", startTestPeer(t, svr))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	want, _ := ctx.Deadline()
+	out := &geecachepb.Response{}
+	if err := client.Fetch(ctx, &geecachepb.Request{Group: gee.name, Key: "Tom"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if string(out.GetValue()) != "db-Tom" {
+		t.Fatalf("expect db-Tom, got %s", out.GetValue())
+	}
+	// grpc 以超时时长的形式传递 deadline，远端看到的 deadline 会有少许误差
+	if got := <-deadlines; got.IsZero() || got.Sub(want).Abs() > time.Second {
+		t.Fatalf("remote retriever should see deadline near %v, got %v", want, got)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.Fetch(cancelled, &geecachepb.Request{Group: gee.name, Key: "Jack"}, out); err == nil {
+		t.Fatalf("Fetch with cancelled context should fail")
+	}
+}
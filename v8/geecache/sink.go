@@ -0,0 +1,53 @@
+package geecache
+
+import "time"
+
+// A Sink receives data from a Retriever.
+// Retriever 通过 Sink 写入取回的数据，以及可选的过期时间和元数据
+type Sink interface {
+	// SetBytes sets the value to the contents of b.
+	// Sink 会拷贝一份 b，调用方之后可以继续修改 b
+	SetBytes(b []byte) error
+
+	// SetString sets the value to s.
+	SetString(s string) error
+
+	// SetExpire sets the time the value expires, the zero time means never expire.
+	SetExpire(expire time.Time)
+
+	// SetMetadata attaches a key/value pair to the value.
+	// 元数据只保存在本节点的缓存中，不会通过 rpc 传给其他节点
+	SetMetadata(key, value string)
+}
+
+// viewSink 是 Group 内部使用的 Sink，把写入的内容组装成 ByteView
+type viewSink struct {
+	v ByteView
+}
+
+var _ Sink = (*viewSink)(nil)
+
+func (s *viewSink) SetBytes(b []byte) error {
+	s.v.b = cloneBytes(b)
+	return nil
+}
+
+func (s *viewSink) SetString(str string) error {
+	s.v.b = []byte(str)
+	return nil
+}
+
+func (s *viewSink) SetExpire(expire time.Time) {
+	s.v.e = expire
+}
+
+func (s *viewSink) SetMetadata(key, value string) {
+	if s.v.meta == nil {
+		s.v.meta = make(map[string]string)
+	}
+	s.v.meta[key] = value
+}
+
+func (s *viewSink) view() ByteView {
+	return s.v
+}
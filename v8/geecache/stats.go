@@ -0,0 +1,164 @@
+package geecache
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// An AtomicInt is an int64 to be accessed atomically.
+type AtomicInt int64
+
+// Add atomically adds n to i.
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+// Get atomically gets the value of i.
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// Stats are per-group statistics.
+type Stats struct {
+	Gets          AtomicInt // any Get request
+	CacheHits     AtomicInt // either mainCache or hotCache
+	HotCacheHits  AtomicInt // 命中 hotCache 的次数，每一次都省掉了一次远端请求
+	HotCacheAdds  AtomicInt // 从远端获取的值被放入 hotCache 的次数
+	Loads         AtomicInt // (gets - cacheHits)，即没有命中缓存的次数
+	LoadsDeduped  AtomicInt // 经过 singleflight 合并之后真正执行的 load 次数
+	PeerLoads     AtomicInt // 从远端节点成功获取的次数
+	PeerErrors    AtomicInt // 从远端节点获取失败的次数
+	LocalLoads    AtomicInt // 从数据源成功加载的次数
+	LocalLoadErrs AtomicInt // 从数据源加载失败的次数
+}
+
+// CacheType 表示 Group 中的一个缓存
+type CacheType int
+
+const (
+	// MainCache 保存本节点负责的 key
+	MainCache CacheType = iota + 1
+	// HotCache 保存本节点不负责、但被频繁访问的远端 key
+	HotCache
+)
+
+func (t CacheType) String() string {
+	switch t {
+	case MainCache:
+		return "main"
+	case HotCache:
+		return "hot"
+	}
+	return "unknown"
+}
+
+// CacheStats 是一个缓存当前的统计
+type CacheStats struct {
+	Bytes     int64 // 条目的 key 和 value 占用的字节数
+	Items     int64 // 条目个数
+	Evictions int64 // 超出容量被淘汰的条目数
+	Expired   int64 // 过期被清理的条目数
+}
+
+// defaultLatencyBuckets 是 rpc 耗时直方图默认的桶上界，单位为秒
+var defaultLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// Histogram 按桶统计耗时的分布，并发安全
+type Histogram struct {
+	bounds []float64   // 每个桶的上界（秒），升序
+	counts []AtomicInt // counts[i] 是落在第 i 个桶的次数，最后一个桶是 +Inf
+	sum    AtomicInt   // 总耗时，纳秒
+	count  AtomicInt
+}
+
+// NewHistogram 创建桶上界为 bounds（秒，升序）的直方图，bounds 为空时使用默认的桶
+func NewHistogram(bounds []float64) *Histogram {
+	if len(bounds) == 0 {
+		bounds = defaultLatencyBuckets
+	}
+	return &Histogram{bounds: bounds, counts: make([]AtomicInt, len(bounds)+1)}
+}
+
+// Observe 记录一次耗时
+func (h *Histogram) Observe(d time.Duration) {
+	i := sort.SearchFloat64s(h.bounds, d.Seconds())
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+	h.count.Add(1)
+}
+
+// Buckets 返回每个桶的上界和耗时不超过它的累计次数，不包括 +Inf
+func (h *Histogram) Buckets() (bounds []float64, cumulative []int64) {
+	cumulative = make([]int64, len(h.bounds))
+	var n int64
+	for i := range h.bounds {
+		n += h.counts[i].Get()
+		cumulative[i] = n
+	}
+	return h.bounds, cumulative
+}
+
+// Count 返回记录的次数
+func (h *Histogram) Count() int64 {
+	return h.count.Get()
+}
+
+// Sum 返回记录的总耗时
+func (h *Histogram) Sum() time.Duration {
+	return time.Duration(h.sum.Get())
+}
+
+// MethodStats 是一个 grpc 方法的统计
+type MethodStats struct {
+	Requests AtomicInt  // 处理的请求数
+	Errors   AtomicInt  // 返回错误的请求数
+	Latency  *Histogram // 处理耗时
+}
+
+// RPCStats 按方法记录 server 处理的 grpc 请求，零值可以直接使用
+type RPCStats struct {
+	mux     sync.Mutex
+	methods map[string]*MethodStats
+}
+
+// Method 返回方法 name 的统计，第一次调用时创建
+func (s *RPCStats) Method(name string) *MethodStats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.methods == nil {
+		s.methods = make(map[string]*MethodStats)
+	}
+	m, ok := s.methods[name]
+	if !ok {
+		m = &MethodStats{Latency: NewHistogram(nil)}
+		s.methods[name] = m
+	}
+	return m
+}
+
+// Methods 返回所有处理过请求的方法的统计
+func (s *RPCStats) Methods() map[string]*MethodStats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	methods := make(map[string]*MethodStats, len(s.methods))
+	for name, m := range s.methods {
+		methods[name] = m
+	}
+	return methods
+}
+
+// observe 记录一次耗时为 d 的请求
+func (m *MethodStats) observe(d time.Duration, err error) {
+	m.Requests.Add(1)
+	if err != nil {
+		m.Errors.Add(1)
+	}
+	m.Latency.Observe(d)
+}
@@ -0,0 +1,98 @@
+package tinylfu
+
+import "hash/maphash"
+
+// cmSketch 是一个 4 行的 count-min sketch，每个计数器占 4 bit，最大为 15。
+// 它用很小的内存估算每个 key 最近的访问频次。
+type cmSketch struct {
+	rows  [4][]uint64 // 每个 uint64 存 16 个 4 bit 计数器
+	mask  uint64      // 计数器个数减一，计数器个数是 2 的幂
+	seed  maphash.Seed
+	added int // 自上次衰减以来的累加次数
+	reset int // added 达到 reset 后所有计数器减半，让旧的热点逐渐冷却
+}
+
+func newCMSketch(counters int) *cmSketch {
+	n := nextPowerOfTwo(uint64(counters))
+	s := &cmSketch{
+		mask:  n - 1,
+		seed:  maphash.MakeSeed(),
+		reset: int(n) * 10,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint64, max(n/16, 1))
+	}
+	return s
+}
+
+func nextPowerOfTwo(x uint64) uint64 {
+	n := uint64(16)
+	for n < x {
+		n <<= 1
+	}
+	return n
+}
+
+// indexes 用一个 64 位哈希通过双重哈希的方式派生出每一行的下标
+func (s *cmSketch) indexes(key string) [4]uint64 {
+	h := maphash.String(s.seed, key)
+	lo, hi := h, h>>32|h<<32
+	var idx [4]uint64
+	for i := range idx {
+		idx[i] = (lo + uint64(i)*hi) & s.mask
+	}
+	return idx
+}
+
+func (s *cmSketch) increment(key string) {
+	for i, idx := range s.indexes(key) {
+		word, shift := idx/16, (idx%16)*4
+		if (s.rows[i][word]>>shift)&0xf < 15 {
+			s.rows[i][word] += 1 << shift
+		}
+	}
+	s.added++
+	if s.added >= s.reset {
+		s.halve()
+	}
+}
+
+// estimate 返回 key 的估算频次，即所有行中最小的那个计数器
+func (s *cmSketch) estimate(key string) int {
+	freq := uint64(15)
+	for i, idx := range s.indexes(key) {
+		word, shift := idx/16, (idx%16)*4
+		freq = min(freq, (s.rows[i][word]>>shift)&0xf)
+	}
+	return int(freq)
+}
+
+// grow 把计数器个数扩大到至少 counters 个，保留已有的频次。
+// 下标是哈希值和 mask 按位与的结果，新的计数器 j 的值复制自旧的计数器 j&mask，
+// 每个 key 在新旧 sketch 中对应的计数器值相同，估算的频次不变
+func (s *cmSketch) grow(counters int) {
+	n := nextPowerOfTwo(uint64(counters))
+	if n <= s.mask+1 {
+		return
+	}
+	for i, row := range s.rows {
+		// 计数器个数至少为 16，新旧下标在同一个 uint64 中的位置相同，可以整个复制
+		next := make([]uint64, n/16)
+		for w := range next {
+			next[w] = row[w&(len(row)-1)]
+		}
+		s.rows[i] = next
+	}
+	s.mask = n - 1
+	s.reset = int(n) * 10
+}
+
+// halve 把所有计数器减半
+func (s *cmSketch) halve() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] = (s.rows[i][j] >> 1) & 0x7777777777777777
+		}
+	}
+	s.added /= 2
+}
@@ -0,0 +1,275 @@
+package tinylfu
+
+import (
+	"container/list"
+	"time"
+	"v8/geecache/policy"
+)
+
+const (
+	windowPercent    = 1  // 窗口 LRU 占总容量的百分比
+	protectedPercent = 80 // protected 段占主缓存的百分比
+	minCounters      = 1024
+)
+
+// Cache is a W-TinyLFU cache. It is not safe for concurrent access.
+//
+// 新条目先进入一个很小的窗口 LRU，从窗口淘汰出来的条目要和主缓存 probation 段
+// 最久未访问的条目比较 count-min sketch 估算的访问频次，频次更高者才能留在主缓存中。
+// 主缓存是一个分段 LRU：probation 段中再次被访问的条目晋升到 protected 段。
+// 这样一次性的扫描流量很难把真正的热点数据挤出缓存。
+type Cache struct {
+	maxBytes     int64 // 允许使用的最大内存（字节），0 表示不限制
+	windowMax    int64 // 窗口 LRU 的最大字节数
+	mainMax      int64 // 主缓存（probation + protected）的最大字节数
+	protectedMax int64 // protected 段的最大字节数
+
+	window    *segment
+	probation *segment
+	protected *segment
+	cache     map[string]*list.Element // 哈希表，值是条目所在链表的节点
+	sketch    *cmSketch                // 频次估算
+	OnEvicted policy.OnEvicted         // 当数据被移除时的回调函数
+
+	now func() time.Time // 获取当前时间，测试时可替换
+}
+
+// segment 是一个记录了总字节数的 LRU 链表，头部是最近访问的
+type segment struct {
+	ll     *list.List
+	nbytes int64
+}
+
+func newSegment() *segment {
+	return &segment{ll: list.New()}
+}
+
+type entry struct {
+	key    string
+	value  Value
+	size   int64 // key 和 value 一共占用的字节数
+	expire time.Time
+	seg    *segment // 所在的链表
+}
+
+// Value use Len to count how many bytes it takes
+type Value = policy.Value
+
+var _ policy.Policy = (*Cache)(nil)
+
+// New is the Constructor of Cache
+func New(maxBytes int64, onEvicted policy.OnEvicted) *Cache {
+	c := &Cache{
+		maxBytes:  maxBytes,
+		window:    newSegment(),
+		probation: newSegment(),
+		protected: newSegment(),
+		cache:     make(map[string]*list.Element),
+		sketch:    newCMSketch(minCounters),
+		OnEvicted: onEvicted,
+		now:       time.Now,
+	}
+	if maxBytes > 0 {
+		c.windowMax = maxBytes * windowPercent / 100
+		c.mainMax = maxBytes - c.windowMax
+		c.protectedMax = c.mainMax * protectedPercent / 100
+	}
+	return c
+}
+
+func (c *Cache) pushFront(seg *segment, kv *entry) *list.Element {
+	kv.seg = seg
+	seg.nbytes += kv.size
+	ele := seg.ll.PushFront(kv)
+	c.cache[kv.key] = ele
+	return ele
+}
+
+func (c *Cache) unlink(ele *list.Element) *entry {
+	kv := ele.Value.(*entry)
+	kv.seg.ll.Remove(ele)
+	kv.seg.nbytes -= kv.size
+	return kv
+}
+
+// record 记录一次访问，条目数超过计数器个数时扩大 sketch，已经记录的频次保留下来
+func (c *Cache) record(key string) {
+	if uint64(len(c.cache)) > c.sketch.mask+1 {
+		c.sketch.grow(len(c.cache) * 2)
+	}
+	c.sketch.increment(key)
+}
+
+// Get look ups a key's value
+func (c *Cache) Get(key string) (value Value, ok bool) {
+	c.record(key)
+	ele, ok := c.cache[key]
+	if !ok {
+		return
+	}
+	kv := ele.Value.(*entry)
+	if policy.Expired(kv.expire, c.now()) {
+		c.removeElement(ele, policy.EvictExpired)
+		return nil, false
+	}
+	c.touch(ele)
+	return kv.value, true
+}
+
+// Peek 查找 key 但不改变它的访问记录，已过期的条目视为不存在
+func (c *Cache) Peek(key string) (value Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return
+	}
+	kv := ele.Value.(*entry)
+	if policy.Expired(kv.expire, c.now()) {
+		return nil, false
+	}
+	return kv.value, true
+}
+
+// touch 处理一次命中：probation 段中的条目晋升到 protected 段，其余的移到所在链表头部
+func (c *Cache) touch(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	if kv.seg != c.probation || c.maxBytes <= 0 {
+		kv.seg.ll.MoveToFront(ele)
+		return
+	}
+	c.unlink(ele)
+	c.pushFront(c.protected, kv)
+	// protected 段满了，把最久未访问的降级回 probation 段
+	for c.protected.nbytes > c.protectedMax && c.protected.ll.Len() > 1 {
+		demoted := c.unlink(c.protected.ll.Back())
+		c.pushFront(c.probation, demoted)
+	}
+}
+
+// Remove removes the provided key from the cache.
+func (c *Cache) Remove(key string) bool {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, policy.EvictRemoved)
+		return true
+	}
+	return false
+}
+
+// Clear purges all stored items from the cache.
+func (c *Cache) Clear() {
+	for _, ele := range c.cache {
+		c.removeElement(ele, policy.EvictRemoved)
+	}
+}
+
+// RemoveExpired 扫描整个缓存，删除所有已过期的条目，返回删除的个数
+func (c *Cache) RemoveExpired() int {
+	now := c.now()
+	n := 0
+	for _, ele := range c.cache {
+		if policy.Expired(ele.Value.(*entry).expire, now) {
+			c.removeElement(ele, policy.EvictExpired)
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Cache) removeElement(ele *list.Element, reason policy.EvictReason) {
+	kv := c.unlink(ele)
+	delete(c.cache, kv.key)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value, reason)
+	}
+}
+
+// Add adds a value to the cache.
+func (c *Cache) Add(key string, value Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire adds a value that expires at the given time,
+// the zero time means never expire.
+func (c *Cache) AddWithExpire(key string, value Value, expire time.Time) {
+	size := int64(len(key)) + int64(value.Len())
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		kv.seg.nbytes += size - kv.size
+		kv.value, kv.size, kv.expire = value, size, expire
+		c.record(key)
+		c.touch(ele)
+	} else {
+		c.record(key)
+		c.pushFront(c.window, &entry{key: key, value: value, size: size, expire: expire})
+	}
+	c.evict()
+}
+
+func (c *Cache) mainBytes() int64 {
+	return c.probation.nbytes + c.protected.nbytes
+}
+
+// evict 把超出窗口容量的条目交给准入过滤器，并保证主缓存不超过容量
+func (c *Cache) evict() {
+	if c.maxBytes <= 0 {
+		return
+	}
+	for c.window.nbytes > c.windowMax {
+		c.admit(c.unlink(c.window.ll.Back()))
+	}
+	// 条目变大之后主缓存也可能超出容量
+	for c.mainBytes() > c.mainMax {
+		c.removeElement(c.mainVictim(), policy.EvictCapacity)
+	}
+}
+
+// mainVictim 返回主缓存中下一个应该被淘汰的条目
+func (c *Cache) mainVictim() *list.Element {
+	if victim := c.probation.ll.Back(); victim != nil {
+		return victim
+	}
+	return c.protected.ll.Back()
+}
+
+// admit 决定从窗口淘汰出来的 candidate 能否进入主缓存
+func (c *Cache) admit(candidate *entry) {
+	for c.mainBytes()+candidate.size > c.mainMax {
+		victim := c.mainVictim()
+		if victim == nil || c.sketch.estimate(candidate.key) <= c.sketch.estimate(victim.Value.(*entry).key) {
+			// candidate 不够热，直接淘汰
+			delete(c.cache, candidate.key)
+			if c.OnEvicted != nil {
+				c.OnEvicted(candidate.key, candidate.value, policy.EvictCapacity)
+			}
+			return
+		}
+		c.removeElement(victim, policy.EvictCapacity)
+	}
+	c.pushFront(c.probation, candidate)
+}
+
+// Range calls fn for each unexpired entry until fn returns false.
+// 依次遍历 protected、probation 和窗口
+func (c *Cache) Range(fn func(key string, value Value) bool) {
+	now := c.now()
+	for _, seg := range []*segment{c.protected, c.probation, c.window} {
+		for ele := seg.ll.Front(); ele != nil; ele = ele.Next() {
+			kv := ele.Value.(*entry)
+			if policy.Expired(kv.expire, now) {
+				continue
+			}
+			if !fn(kv.key, kv.value) {
+				return
+			}
+		}
+	}
+}
+
+// Len the number of cache entries
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+// Bytes the number of bytes taken by cache entries
+func (c *Cache) Bytes() int64 {
+	return c.window.nbytes + c.mainBytes()
+}
@@ -0,0 +1,257 @@
+package tinylfu
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+	"v8/geecache/policy"
+	"v8/geecache/policy/policytest"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+func TestGet(t *testing.T) {
+	lfu := New(int64(0), nil)
+	lfu.Add("key1", String("1234"))
+	if v, ok := lfu.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+
+	if _, ok := lfu.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	lfu := New(int64(1000), nil)
+	for i := 0; i < 5; i++ {
+		lfu.Get("hot")
+	}
+	lfu.Add("hot", String("value"))
+	lfu.Get("hot")
+
+	// 一次性扫描大量冷 key，不应该把热点挤出去
+	for i := 0; i < 1000; i++ {
+		lfu.Add("cold"+strconv.Itoa(i), String("value"))
+	}
+	if _, ok := lfu.Get("hot"); !ok {
+		t.Fatalf("hot key should survive a scan")
+	}
+	if lfu.window.nbytes+lfu.mainBytes() > 1000 {
+		t.Fatalf("cache exceeds maxBytes")
+	}
+}
+
+func TestPeek(t *testing.T) {
+	lfu := New(int64(0), nil)
+	lfu.Add("key1", String("1234"))
+	before := lfu.sketch.estimate("key1")
+	// Peek 不计入访问频次
+	for i := 0; i < 3; i++ {
+		if v, ok := lfu.Peek("key1"); !ok || string(v.(String)) != "1234" {
+			t.Fatalf("peek key1 failed")
+		}
+	}
+	if after := lfu.sketch.estimate("key1"); after != before {
+		t.Fatalf("peek should not record accesses, estimate %d -> %d", before, after)
+	}
+}
+
+func TestOnEvicted(t *testing.T) {
+	keys := make([]string, 0)
+	callback := func(key string, value Value, reason policy.EvictReason) {
+		keys = append(keys, key)
+	}
+	lfu := New(int64(10), callback)
+	lfu.Add("key1", String("123456"))
+	// k2 和 key1 一样冷，不能被准入
+	lfu.Add("k2", String("k2"))
+	// k3 被多次访问过，比 key1 更热，准入后淘汰 key1
+	lfu.Get("k3")
+	lfu.Get("k3")
+	lfu.Add("k3", String("k3"))
+
+	expect := []string{"k2", "key1"}
+
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s, got %s", expect, keys)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	lfu := New(int64(0), nil)
+	lfu.Add("key", String("1"))
+	lfu.Add("key", String("111"))
+
+	if n := lfu.Bytes(); n != int64(len("key")+len("111")) {
+		t.Fatal("expected 6 but got", n)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	now := time.Now()
+	reasons := make(map[string]policy.EvictReason)
+	lfu := New(int64(0), func(key string, value Value, reason policy.EvictReason) {
+		reasons[key] = reason
+	})
+	lfu.now = func() time.Time { return now }
+	lfu.AddWithExpire("key1", String("1234"), now.Add(time.Second))
+	lfu.Add("key2", String("5678"))
+
+	if _, ok := lfu.Get("key1"); !ok {
+		t.Fatalf("cache hit key1 before expire failed")
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, ok := lfu.Get("key1"); ok {
+		t.Fatalf("key1 should be expired")
+	}
+	if _, ok := lfu.Get("key2"); !ok {
+		t.Fatalf("key2 without ttl should never expire")
+	}
+	if lfu.Len() != 1 {
+		t.Fatalf("expired key1 should be removed, len=%d", lfu.Len())
+	}
+	if reasons["key1"] != policy.EvictExpired {
+		t.Fatalf("expect key1 evicted by %s, got %s", policy.EvictExpired, reasons["key1"])
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	now := time.Now()
+	lfu := New(int64(0), nil)
+	lfu.now = func() time.Time { return now }
+	lfu.AddWithExpire("k1", String("v1"), now.Add(time.Second))
+	lfu.AddWithExpire("k2", String("v2"), now.Add(time.Minute))
+	lfu.Add("k3", String("v3"))
+
+	now = now.Add(2 * time.Second)
+	if n := lfu.RemoveExpired(); n != 1 || lfu.Len() != 2 {
+		t.Fatalf("expect k1 swept, got n=%d len=%d", n, lfu.Len())
+	}
+	now = now.Add(time.Hour)
+	if n := lfu.RemoveExpired(); n != 1 || lfu.Len() != 1 {
+		t.Fatalf("expect k2 swept, got n=%d len=%d", n, lfu.Len())
+	}
+}
+
+func TestSketch(t *testing.T) {
+	s := newCMSketch(minCounters)
+	for i := 0; i < 20; i++ {
+		s.increment("hot")
+	}
+	s.increment("cold")
+	if f := s.estimate("hot"); f != 15 {
+		t.Fatalf("counter should saturate at 15, got %d", f)
+	}
+	if f := s.estimate("cold"); f < 1 || f >= 15 {
+		t.Fatalf("unexpected estimate of cold %d", f)
+	}
+	s.halve()
+	if f := s.estimate("hot"); f != 7 {
+		t.Fatalf("halve should reset hot to 7, got %d", f)
+	}
+}
+
+func TestSketchGrow(t *testing.T) {
+	s := newCMSketch(16)
+	for i := 0; i < 40; i++ {
+		for j := 0; j < i%8; j++ {
+			s.increment("key" + strconv.Itoa(i))
+		}
+	}
+	expect := make(map[string]int)
+	for i := 0; i < 40; i++ {
+		key := "key" + strconv.Itoa(i)
+		expect[key] = s.estimate(key)
+	}
+	s.grow(minCounters)
+	if s.mask+1 != minCounters {
+		t.Fatalf("expect %d counters after grow, got %d", minCounters, s.mask+1)
+	}
+	for key, f := range expect {
+		if got := s.estimate(key); got != f {
+			t.Fatalf("grow should keep the estimate of %s, expect %d got %d", key, f, got)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	reasons := make(map[string]policy.EvictReason)
+	lfu := New(int64(0), func(key string, value Value, reason policy.EvictReason) {
+		reasons[key] = reason
+	})
+	lfu.Add("key1", String("1234"))
+	lfu.Add("key2", String("5678"))
+
+	if !lfu.Remove("key1") || lfu.Remove("key1") {
+		t.Fatalf("Remove key1 should report existence")
+	}
+	if _, ok := lfu.Get("key1"); ok || lfu.Len() != 1 {
+		t.Fatalf("key1 should be removed")
+	}
+	if reasons["key1"] != policy.EvictRemoved {
+		t.Fatalf("expect key1 evicted by %s, got %s", policy.EvictRemoved, reasons["key1"])
+	}
+}
+
+func TestClear(t *testing.T) {
+	lfu := New(int64(0), nil)
+	lfu.Add("key1", String("1234"))
+	lfu.Add("key2", String("5678"))
+	lfu.Clear()
+
+	if lfu.Len() != 0 {
+		t.Fatalf("Clear should remove all entries, got %d", lfu.Len())
+	}
+	if _, ok := lfu.Get("key2"); ok {
+		t.Fatalf("key2 should be cleared")
+	}
+}
+
+func BenchmarkZipf(b *testing.B) {
+	policytest.BenchmarkZipf(b, func(maxBytes int64) policy.Policy {
+		return New(maxBytes, nil)
+	})
+}
+
+func BenchmarkZipfScan(b *testing.B) {
+	policytest.BenchmarkZipfScan(b, func(maxBytes int64) policy.Policy {
+		return New(maxBytes, nil)
+	})
+}
+
+func TestRange(t *testing.T) {
+	now := time.Now()
+	c := New(int64(0), nil)
+	c.now = func() time.Time { return now }
+	c.Add("k1", String("v1"))
+	c.AddWithExpire("k2", String("v2"), now.Add(time.Second))
+	c.Add("k3", String("v3"))
+	c.Get("k1")
+	c.Get("k1")
+	now = now.Add(2 * time.Second)
+
+	var keys []string
+	c.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 2 || keys[0] != "k1" {
+		t.Fatalf("expect 2 unexpired keys starting with k1, got %v", keys)
+	}
+
+	keys = keys[:0]
+	c.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return false
+	})
+	if len(keys) != 1 {
+		t.Fatalf("Range should stop when fn returns false, got %v", keys)
+	}
+}
@@ -0,0 +1,45 @@
+package trace
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// TraceparentHeader 是 W3C Trace Context 中传递 trace 上下文的 header，
+// 节点之间通过同名的 grpc metadata 传递
+const TraceparentHeader = "traceparent"
+
+// Traceparent 把 sc 编码成 traceparent：00-<trace id>-<span id>-01
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", sc.TraceID, sc.SpanID)
+}
+
+// ParseTraceparent 解析 traceparent，返回的 SpanContext 标记为 Remote
+func ParseTraceparent(s string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", s)
+	}
+	var sc SpanContext
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid trace id in traceparent %q: %v", s, err)
+	}
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid span id in traceparent %q: %v", s, err)
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q: zero id", s)
+	}
+	sc.Remote = true
+	return sc, nil
+}
+
+// decodeHex 把长度正好是 2*len(dst) 的十六进制字符串解码到 dst
+func decodeHex(dst []byte, s string) error {
+	if len(s) != 2*len(dst) {
+		return fmt.Errorf("expect %d hex digits, got %d", 2*len(dst), len(s))
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
@@ -0,0 +1,291 @@
+// Package trace 是一个精简的分布式追踪接口，概念和 OpenTelemetry 一致：
+// 一次请求是一个 trace，经过的每一步是一个 span，span 之间有父子关系，
+// 跨节点时通过 W3C traceparent 传递 trace 上下文。
+//
+// Noop 返回的 tracer 什么都不记录；NewTracer 创建的 tracer 在 span 结束时
+// 交给 Exporter，InMemoryExporter 把它们保存在内存中，用于测试和排查问题。
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TraceID 标识一次请求经过的所有 span
+type TraceID [16]byte
+
+// IsValid 全零的 TraceID 无效
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID 标识一个 span
+type SpanID [8]byte
+
+// IsValid 全零的 SpanID 无效
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext 是 span 在进程之间传递的部分
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Remote  bool // 从其他节点传过来的
+}
+
+// IsValid TraceID 和 SpanID 都有效时返回 true
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Attribute 是 span 上的一个键值对
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// String 创建一个字符串属性
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int 创建一个整数属性
+func Int(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: strconv.FormatInt(value, 10)}
+}
+
+// Bool 创建一个布尔属性
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: strconv.FormatBool(value)}
+}
+
+// Span 是 trace 中的一步操作，End 之后的调用都会被忽略
+type Span interface {
+	SpanContext() SpanContext
+	SetAttributes(attrs ...Attribute)
+	// RecordError 记录操作失败的原因，err 为 nil 时什么都不做
+	RecordError(err error)
+	End()
+}
+
+// Tracer 创建 span
+type Tracer interface {
+	// Start 创建名为 name 的 span，ctx 中有 span 时作为它的子 span，
+	// 返回的 ctx 中保存了新的 span
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+type spanKey struct{}
+
+// ContextWithSpan 返回保存了 span 的 ctx
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// ContextWithRemoteSpanContext 返回以其他节点上的 sc 为父 span 的 ctx
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	sc.Remote = true
+	return ContextWithSpan(ctx, noopSpan{sc: sc})
+}
+
+// SpanFromContext 返回 ctx 中的 span，没有时返回一个什么都不做的 span
+func SpanFromContext(ctx context.Context) Span {
+	if span, ok := ctx.Value(spanKey{}).(Span); ok {
+		return span
+	}
+	return noopSpan{}
+}
+
+// SpanContextFromContext 返回 ctx 中的 span 的 SpanContext
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	return SpanFromContext(ctx).SpanContext()
+}
+
+// Noop 返回什么都不记录的 Tracer，ctx 中已有的 trace 上下文原样保留
+func Noop() Tracer {
+	return noopTracer{}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return ctx, SpanFromContext(ctx)
+}
+
+// noopSpan 只携带 SpanContext，不记录任何信息
+type noopSpan struct {
+	sc SpanContext
+}
+
+func (s noopSpan) SpanContext() SpanContext { return s.sc }
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
+
+// SpanData 是一个结束了的 span
+type SpanData struct {
+	Name        string
+	SpanContext SpanContext
+	Parent      SpanContext // 父 span，根 span 的 Parent 无效
+	Start, End  time.Time
+	Attributes  []Attribute
+	Err         error // RecordError 记录的最后一个错误
+}
+
+// Attr 返回属性 key 最后一次设置的值
+func (d SpanData) Attr(key string) (string, bool) {
+	for i := len(d.Attributes) - 1; i >= 0; i-- {
+		if d.Attributes[i].Key == key {
+			return d.Attributes[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// Duration 返回 span 的耗时
+func (d SpanData) Duration() time.Duration {
+	return d.End.Sub(d.Start)
+}
+
+// Exporter 接收结束了的 span，需要是并发安全的
+type Exporter interface {
+	Export(span SpanData)
+}
+
+// NewTracer 创建一个记录 span 的 Tracer，span 结束时交给 exporter
+func NewTracer(exporter Exporter) Tracer {
+	return &tracer{exporter: exporter}
+}
+
+type tracer struct {
+	exporter Exporter
+}
+
+func (t *tracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{TraceID: parent.TraceID, SpanID: newSpanID()}
+	if !parent.IsValid() {
+		sc.TraceID = newTraceID()
+		parent = SpanContext{}
+	}
+	span := &span{
+		exporter: t.exporter,
+		data: SpanData{
+			Name:        name,
+			SpanContext: sc,
+			Parent:      parent,
+			Start:       time.Now(),
+			Attributes:  append([]Attribute(nil), attrs...),
+		},
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// span 是 NewTracer 创建的 span
+type span struct {
+	exporter Exporter
+	mu       sync.Mutex
+	data     SpanData
+	ended    bool
+}
+
+func (s *span) SpanContext() SpanContext {
+	return s.data.SpanContext // 创建后不再修改，不需要加锁
+}
+
+func (s *span) SetAttributes(attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Attributes = append(s.data.Attributes, attrs...)
+	}
+}
+
+func (s *span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Err = err
+	}
+}
+
+func (s *span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.exporter.Export(data)
+}
+
+func newTraceID() (id TraceID) {
+	for !id.IsValid() {
+		putUint64(id[:8], rand.Uint64())
+		putUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	for !id.IsValid() {
+		putUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+func putUint64(b []byte, v uint64) {
+	for i := range 8 {
+		b[i] = byte(v >> (56 - 8*i))
+	}
+}
+
+// InMemoryExporter 把结束了的 span 按结束顺序保存在内存中
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewInMemoryExporter creates an InMemoryExporter
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) Export(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans 返回保存的所有 span
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset 清空保存的 span
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
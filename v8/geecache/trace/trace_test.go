@@ -0,0 +1,88 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	exp := NewInMemoryExporter()
+	tracer := NewTracer(exp)
+
+	ctx, root := tracer.Start(context.Background(), "root", String("group", "scores"))
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttributes(Bool("hit", false), Int("bytes", 3))
+	child.RecordError(fmt.Errorf("boom"))
+	child.End()
+	child.End() // 重复 End 被忽略
+	root.End()
+
+	spans := exp.Spans()
+	if len(spans) != 2 || spans[0].Name != "child" || spans[1].Name != "root" {
+		t.Fatalf("expect child and root in end order, got %v", spans)
+	}
+	c, r := spans[0], spans[1]
+	if r.Parent.IsValid() || !r.SpanContext.IsValid() {
+		t.Fatalf("root should have no parent, got %+v", r)
+	}
+	if c.SpanContext.TraceID != r.SpanContext.TraceID || c.Parent.SpanID != r.SpanContext.SpanID {
+		t.Fatalf("child should be in root's trace with root as parent")
+	}
+	if v, _ := r.Attr("group"); v != "scores" {
+		t.Fatalf("unexpected root attributes %v", r.Attributes)
+	}
+	if v, _ := c.Attr("hit"); v != "false" || c.Err == nil || c.Duration() < 0 {
+		t.Fatalf("unexpected child %+v", c)
+	}
+
+	exp.Reset()
+	if len(exp.Spans()) != 0 {
+		t.Fatalf("Reset should drop all spans")
+	}
+}
+
+func TestNoop(t *testing.T) {
+	ctx := context.Background()
+	if _, span := Noop().Start(ctx, "noop"); span.SpanContext().IsValid() {
+		t.Fatalf("noop span without parent should be invalid")
+	}
+	// 没有开启追踪的节点也会把上游的 trace 上下文传下去
+	sc := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}}
+	ctx = ContextWithRemoteSpanContext(ctx, sc)
+	_, span := Noop().Start(ctx, "noop")
+	if got := span.SpanContext(); got.TraceID != sc.TraceID || got.SpanID != sc.SpanID || !got.Remote {
+		t.Fatalf("noop span should keep the remote parent, got %+v", got)
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	exp := NewInMemoryExporter()
+	_, span := NewTracer(exp).Start(context.Background(), "client")
+	sc := span.SpanContext()
+	parsed, err := ParseTraceparent(sc.Traceparent())
+	if err != nil || parsed.TraceID != sc.TraceID || parsed.SpanID != sc.SpanID || !parsed.Remote {
+		t.Fatalf("round trip %s failed, got %+v err=%v", sc.Traceparent(), parsed, err)
+	}
+
+	// 远端的 span 作为父 span
+	ctx := ContextWithRemoteSpanContext(context.Background(), parsed)
+	_, server := NewTracer(exp).Start(ctx, "server")
+	server.End()
+	if got := exp.Spans()[0]; got.SpanContext.TraceID != sc.TraceID || got.Parent.SpanID != sc.SpanID || !got.Parent.Remote {
+		t.Fatalf("server span should continue the remote trace, got %+v", got)
+	}
+
+	for _, s := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902zz-01",
+	} {
+		if _, err := ParseTraceparent(s); err == nil {
+			t.Errorf("parse %q should fail", s)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package geecache
+
+import (
+	"context"
+	"sync/atomic"
+	"v8/geecache/trace"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// globalTracer 是 Group、Server 和 Client 共用的 tracer，没有设置时不记录任何 span
+var globalTracer atomic.Pointer[trace.Tracer]
+
+// SetTracer 设置记录 span 的 tracer，例如 trace.NewTracer(trace.NewInMemoryExporter())，
+// nil 表示关闭追踪。一次 Get 会记录以下 span：
+//
+//	geecache.Get       整个请求，属性 group、key、hit
+//	geecache.lookup    在 mainCache 和 hotCache 中查找
+//	geecache.load      没有命中缓存时加载，包括在 singleflight 中等待，属性 deduped
+//	geecache.fetch     从远端节点获取，属性 peer
+//	geecache.retrieve  从数据源加载
+//
+// 远端节点处理请求时记录 geecache.Server/<方法名>，trace 上下文通过 grpc metadata 中的
+// traceparent 传递，所以远端节点上的 span 和本节点的 span 属于同一个 trace
+func SetTracer(t trace.Tracer) {
+	if t == nil {
+		t = trace.Noop()
+	}
+	globalTracer.Store(&t)
+}
+
+// startSpan 用当前的 tracer 创建 span
+func startSpan(ctx context.Context, name string, attrs ...trace.Attribute) (context.Context, trace.Span) {
+	t := trace.Noop()
+	if p := globalTracer.Load(); p != nil {
+		t = *p
+	}
+	return t.Start(ctx, name, attrs...)
+}
+
+// endSpan 记录 err 并结束 span
+func endSpan(span trace.Span, err error) {
+	span.RecordError(err)
+	span.End()
+}
+
+// injectTrace 把 ctx 中的 trace 上下文写入发往远端的 grpc metadata
+func injectTrace(ctx context.Context) context.Context {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		return metadata.AppendToOutgoingContext(ctx, trace.TraceparentHeader, sc.Traceparent())
+	}
+	return ctx
+}
+
+// extractTrace 从收到的 grpc metadata 中取出远端的 trace 上下文
+func extractTrace(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(trace.TraceparentHeader); len(values) > 0 {
+		if sc, err := trace.ParseTraceparent(values[0]); err == nil {
+			return trace.ContextWithRemoteSpanContext(ctx, sc)
+		}
+	}
+	return ctx
+}
+
+// unaryClientTrace 在 Client 发出的请求中带上 trace 上下文
+func unaryClientTrace(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(injectTrace(ctx), method, req, reply, cc, opts...)
+}
+
+func streamClientTrace(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(injectTrace(ctx), desc, cc, method, opts...)
+}
+
+// unaryTrace 以请求中的 trace 上下文为父 span，为 server 处理的请求记录 span
+func (s *Server) unaryTrace(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	ctx, span := startSpan(extractTrace(ctx), "geecache.Server/"+methodName(info.FullMethod), trace.String("server", s.addr))
+	defer func() { endSpan(span, err) }()
+	return handler(ctx, req)
+}
+
+func (s *Server) streamTrace(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	ctx, span := startSpan(extractTrace(ss.Context()), "geecache.Server/"+methodName(info.FullMethod), trace.String("server", s.addr))
+	defer func() { endSpan(span, err) }()
+	return handler(srv, &tracedStream{ServerStream: ss, ctx: ctx})
+}
+
+// tracedStream 把带有 span 的 ctx 交给流式请求的 handler
+type tracedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedStream) Context() context.Context {
+	return s.ctx
+}
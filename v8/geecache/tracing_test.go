@@ -0,0 +1,140 @@
+package geecache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+	"v8/geecache/geecachepb"
+	"v8/geecache/trace"
+)
+
+// groupFetcher 把请求发给远端节点上的另一个 Group，
+// 测试中两个节点在同一个进程里，不能共用同一个 Group
+type groupFetcher struct {
+	client *Client
+	group  string
+}
+
+func (f groupFetcher) Fetch(ctx context.Context, in *geecachepb.Request, out *geecachepb.Response) error {
+	return f.client.Fetch(ctx, &geecachepb.Request{Group: f.group, Key: in.GetKey()}, out)
+}
+
+// remotePicker 把所有 key 都交给 peer
+type remotePicker struct {
+	peer Fetcher
+}
+
+func (p remotePicker) PickPeer(key string) (Fetcher, bool) {
+	return p.peer, true
+}
+
+// useTracer 让之后的请求记录到内存中，测试结束后关闭追踪
+func useTracer(t *testing.T) *trace.InMemoryExporter {
+	exp := trace.NewInMemoryExporter()
+	SetTracer(trace.NewTracer(exp))
+	t.Cleanup(func() { SetTracer(nil) })
+	return exp
+}
+
+func TestTraceAcrossPeers(t *testing.T) {
+	NewGroup("trace-remote", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}))
+	svr := newListenServer(t)
+	serveServer(t, svr)
+	defer svr.Stop()
+	client := NewClient("geecache/peer", svr.addr)
+	defer client.Close()
+
+	local := NewGroup("trace-local", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte("local-" + key), nil
+	}))
+	local.RegisterPeers(remotePicker{peer: groupFetcher{client: client, group: "trace-remote"}})
+
+	exp := useTracer(t)
+	if v, err := local.Get(context.Background(), "Tom"); err != nil || v.String() != "db-Tom" {
+		t.Fatalf("expect db-Tom from the remote peer, got %s err=%v", v, err)
+	}
+
+	spans := exp.Spans()
+	byID := make(map[trace.SpanID]trace.SpanData)
+	for _, s := range spans {
+		byID[s.SpanContext.SpanID] = s
+		if s.SpanContext.TraceID != spans[0].SpanContext.TraceID {
+			t.Fatalf("all spans should be in one trace, got %v", spans)
+		}
+	}
+	// 从每个 span 沿着父 span 走到根，得到它的路径
+	path := func(s trace.SpanData) string {
+		p := s.Name
+		for s.Parent.IsValid() {
+			s = byID[s.Parent.SpanID]
+			p = s.Name + " > " + p
+		}
+		return p
+	}
+	got := make(map[string]trace.SpanData)
+	for _, s := range spans {
+		got[path(s)] = s
+	}
+	const fetch = "geecache.Get > geecache.load > geecache.fetch"
+	const remote = fetch + " > geecache.Server/Get > geecache.Get"
+	for _, p := range []string{
+		"geecache.Get",
+		"geecache.Get > geecache.lookup",
+		fetch,
+		remote + " > geecache.lookup",
+		remote + " > geecache.load > geecache.retrieve",
+	} {
+		if _, ok := got[p]; !ok {
+			t.Errorf("missing span %s", p)
+		}
+	}
+	if v, _ := got["geecache.Get"].Attr("hit"); v != "false" {
+		t.Errorf("root span should record a cache miss, got %v", got["geecache.Get"].Attributes)
+	}
+	if s := got[fetch+" > geecache.Server/Get"]; !s.Parent.Remote {
+		t.Errorf("server span should have a remote parent, got %+v", s)
+	}
+	if _, ok := got["geecache.Get > geecache.load > geecache.retrieve"]; ok {
+		t.Errorf("local retriever should not be called")
+	}
+}
+
+func TestTraceDeduped(t *testing.T) {
+	release := make(chan struct{})
+	gee := NewGroup("trace-dedup", 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+		<-release
+		return []byte("db-" + key), nil
+	}))
+	exp := useTracer(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gee.Get(context.Background(), "Tom")
+		}()
+	}
+	// 等两个调用都进入 singleflight 之后再放行，Loads 在进入之前计数，再多等一会
+	deadline := time.Now().Add(2 * time.Second)
+	for gee.Stats.Loads.Get() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	deduped := map[string]int{}
+	for _, s := range exp.Spans() {
+		if s.Name == "geecache.load" {
+			v, _ := s.Attr("deduped")
+			deduped[v]++
+		}
+	}
+	if deduped["true"] != 1 || deduped["false"] != 1 {
+		t.Fatalf("expect one load to wait for the other, got %v", deduped)
+	}
+}
@@ -0,0 +1,83 @@
+package geecache
+
+import (
+	"log"
+	"v8/geecache/consistenthash"
+	"v8/geecache/registry"
+)
+
+// WithZoneAware 开启按可用区路由：本节点只在和自己处于同一可用区（registry.Endpoint.Zone）
+// 的节点组成的哈希环上查找 key 的 owner，读请求不跨可用区，代价是每个可用区各缓存一份数据。
+// fallback 为 true 时，本可用区没有节点或者 owner 请求失败，会转发给全局哈希环上的 owner，
+// 否则直接由本节点从数据源加载。
+// Set、Remove 只作用于本可用区的 owner，需要让所有可用区的副本失效时使用 Invalidate。
+// 集群中所有节点的设置必须相同
+func WithZoneAware(fallback bool) ServerOption {
+	return func(o *serverOptions) {
+		o.zoneAware = true
+		o.zoneFallback = fallback
+	}
+}
+
+// route 返回查找 key 的 owner 时使用的放置快照。
+// 按可用区路由时使用本可用区的哈希环，本可用区没有节点时按 zoneFallback 决定使用全局的哈希环，
+// 还是返回空的哈希环让本节点自己加载
+func (s *Server) route(key string) consistenthash.Placement {
+	global := s.placement.Snapshot()
+	if s.zoneRing == nil {
+		return global
+	}
+	return s.routeIn(global, s.zoneRing.Snapshot(), key)
+}
+
+// routeIn 和 route 的规则相同，但在给定的全局哈希环 global 和本可用区的哈希环 local 中选择，
+// 用于预估节点列表变化之后的路由
+func (s *Server) routeIn(global, local consistenthash.Placement, key string) consistenthash.Placement {
+	if local.Get(key) != "" || !s.zoneFallback {
+		return local
+	}
+	return global
+}
+
+// PickFallback 在本可用区的 owner 请求失败后，返回全局哈希环上 key 的 owner，
+// 它通常在其他可用区。没有开启跨可用区回退、owner 是本节点或者就是 failed 时返回 false
+func (s *Server) PickFallback(key string, failed Fetcher) (peer Fetcher, ok bool) {
+	if s.zoneRing == nil || !s.zoneFallback {
+		return nil, false
+	}
+	peerAddr := s.placement.Get(key)
+	if peerAddr == "" || peerAddr == s.addr {
+		return nil, false
+	}
+	client, ok := s.client(peerAddr)
+	if !ok || Fetcher(client) == failed {
+		return nil, false
+	}
+	log.Printf("[cache %s] fall back to cross-zone peer: %s\n", s.addr, peerAddr)
+	return client, true
+}
+
+// sameZone 返回 endpoints 中和本节点处于同一可用区的节点的权重
+func (s *Server) sameZone(endpoints map[string]registry.Endpoint) map[string]int {
+	weights := make(map[string]int)
+	for addr, ep := range endpoints {
+		if ep.Zone == s.self.Zone {
+			weights[addr] = ep.GetWeight()
+		}
+	}
+	return weights
+}
+
+// updateZone 在节点 ep 加入或者更新后同步本可用区的哈希环，节点换到其他可用区时从环上删除
+func (s *Server) updateZone(ep registry.Endpoint) {
+	if s.zoneRing == nil {
+		return
+	}
+	if ep.Zone == s.self.Zone {
+		s.zoneRing.RegisterWeighted(ep.Addr, ep.GetWeight())
+	} else {
+		s.zoneRing.Destroy(ep.Addr)
+	}
+}
+
+var _ FallbackPicker = (*Server)(nil)
@@ -0,0 +1,121 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"v8/geecache/geecachepb"
+	"v8/geecache/registry"
+)
+
+// newZoneServer 创建 az1 中按可用区路由的 Server，peers 是 addr -> zone
+func newZoneServer(t *testing.T, fallback bool, withSelf bool, peers map[string]string) *Server {
+	t.Helper()
+	svr, err := NewServer("127.0.0.1:9001", WithDiscovery(registry.NewStatic()), WithZone("az1"), WithZoneAware(fallback))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(svr.cancel)
+	var endpoints []registry.Endpoint
+	if withSelf {
+		endpoints = append(endpoints, svr.Endpoint())
+	}
+	for addr, zone := range peers {
+		endpoints = append(endpoints, registry.Endpoint{Addr: addr, Zone: zone})
+	}
+	svr.SetPeerEndpoints(endpoints...)
+	return svr
+}
+
+// pickedAddrs 统计 100 个 key 由哪些节点负责，本节点记为 svr.addr
+func pickedAddrs(svr *Server) map[string]int {
+	picked := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		peer, ok := svr.PickPeer(fmt.Sprintf("key%d", i))
+		if !ok {
+			picked[svr.addr]++
+			continue
+		}
+		picked[peer.(*Client).addr]++
+	}
+	return picked
+}
+
+func TestServerZoneAware(t *testing.T) {
+	peers := map[string]string{"127.0.0.1:9002": "az1", "127.0.0.1:9003": "az2", "127.0.0.1:9004": "az3"}
+	svr := newZoneServer(t, true, true, peers)
+	picked := pickedAddrs(svr)
+	if picked["127.0.0.1:9003"] != 0 || picked["127.0.0.1:9004"] != 0 {
+		t.Fatalf("keys should stay in az1, got %v", picked)
+	}
+	if picked[svr.addr] == 0 || picked["127.0.0.1:9002"] == 0 {
+		t.Fatalf("keys should spread over az1, got %v", picked)
+	}
+
+	// 节点换到其他可用区后不再是本可用区的 owner
+	svr.addPeer(registry.Endpoint{Addr: "127.0.0.1:9002", Zone: "az2"})
+	if picked := pickedAddrs(svr); picked[svr.addr] != 100 {
+		t.Fatalf("only self is left in az1, got %v", picked)
+	}
+	// 全局的哈希环不受影响
+	if n := len(svr.placement.Nodes()); n != 4 {
+		t.Fatalf("global ring should keep all 4 nodes, got %d", n)
+	}
+}
+
+func TestServerZoneEmpty(t *testing.T) {
+	// 本节点不在环上（比如正在下线），本可用区没有节点
+	peers := map[string]string{"127.0.0.1:9003": "az2", "127.0.0.1:9004": "az3"}
+	if picked := pickedAddrs(newZoneServer(t, false, false, peers)); picked["127.0.0.1:9001"] != 100 {
+		t.Fatalf("without fallback keys should be loaded locally, got %v", picked)
+	}
+	picked := pickedAddrs(newZoneServer(t, true, false, peers))
+	if picked["127.0.0.1:9003"] == 0 || picked["127.0.0.1:9004"] == 0 {
+		t.Fatalf("with fallback keys should go to cross-zone owners, got %v", picked)
+	}
+}
+
+// zonePeer 是其他可用区的节点，返回 remote-<key>
+type zonePeer struct {
+	geecachepb.UnimplementedGroupCacheServer
+}
+
+func (zonePeer) Get(ctx context.Context, in *geecachepb.Request) (*geecachepb.Response, error) {
+	return &geecachepb.Response{Value: []byte("remote-" + in.GetKey())}, nil
+}
+
+func TestZoneFallback(t *testing.T) {
+	// 本可用区的 owner 已经不可用
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := lis.Addr().String()
+	lis.Close()
+	remote := startTestPeer(t, zonePeer{})
+
+	for _, fallback := range []bool{true, false} {
+		svr := newZoneServer(t, fallback, true, map[string]string{dead: "az1", remote: "az2"})
+		gee := NewGroup(fmt.Sprintf("zone-fallback-%v", fallback), 2<<10, RetrieverFunc(func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}))
+		gee.RegisterPeers(svr)
+
+		// 找一个本可用区 owner 是 dead、全局 owner 是 remote 的 key
+		var key string
+		for i := 0; key == ""; i++ {
+			k := fmt.Sprintf("key%d", i)
+			if svr.zoneRing.Get(k) == dead && svr.placement.Get(k) == remote {
+				key = k
+			}
+		}
+		expect := "db-" + key
+		if fallback {
+			expect = "remote-" + key
+		}
+		if v, err := gee.Get(context.Background(), key); err != nil || v.String() != expect {
+			t.Fatalf("fallback=%v: expect %s, got %s err=%v", fallback, expect, v, err)
+		}
+	}
+}
@@ -7,6 +7,7 @@ require (
 	go.etcd.io/etcd/client/v3 v3.5.21
 	google.golang.org/grpc v1.71.1
 	google.golang.org/protobuf v1.36.4
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
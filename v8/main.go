@@ -4,17 +4,26 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"google.golang.org/grpc"
-	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 	"v8/geecache"
-	"v8/geecache/geecachepb"
+	"v8/geecache/consistenthash"
+	"v8/geecache/registry"
 
 	"log"
 )
 
+// version 是构建版本，注册到 discovery 中，构建时通过 -ldflags "-X main.version=v1.2.3" 设置
+var version = "dev"
+
 var db = map[string]string{
 	//"Tom":  "630",
 	//"Jack": "589",
@@ -36,48 +45,40 @@ func CreateGroup() *geecache.Group {
 		}))
 }
 
-func startCacheServer(svr *geecache.Server, addr string, addrs []string, group *geecache.Group) {
+func startCacheServer(svr *geecache.Server, peers []registry.Endpoint, group *geecache.Group, drainTimeout time.Duration) {
 
-	svr.SetPeers(addrs...)
+	svr.SetPeerEndpoints(peers...)
 
 	// 将服务与cache绑定 因为cache和server是解耦合的
 	group.RegisterPeers(svr)
-	log.Println("geecache is running at", addr)
-	// 启动服务(注册服务至etcd/计算一致性哈希...)
+
+	// 收到 SIGTERM 或 SIGINT 后平滑下线：注销、迁移 key、等待请求处理完再退出
 	go func() {
-		// Start将不会return 除非服务stop或者抛出error
-		err := svr.Start()
-		if err != nil {
-			log.Fatal(err)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		log.Printf("received %v, draining", <-sig)
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		if err := svr.Drain(ctx); err != nil {
+			log.Printf("drain failed: %v", err)
 		}
 	}()
+	go func() {
+		<-svr.Ready()
+		log.Println("geecache is running at", svr.Addr())
+	}()
 
-	lis, err := net.Listen("tcp", addr)
-	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
-		return
-	}
-
-	defer lis.Close()
-
-	//创建服务
-	grpcServer := grpc.NewServer()
-	geecachepb.RegisterGroupCacheServer(grpcServer, svr)
-	//	RegisterSayHelloServer(grpcServer, &server{})
-
-	//启动服务
-	err = grpcServer.Serve(lis)
-	if err != nil {
-		log.Fatalf("failed to serve: %v", err)
-		return
+	// 启动服务(注册服务至etcd/计算一致性哈希...)
+	// Serve将不会return 除非服务drain/stop或者抛出error
+	if err := svr.Serve(); err != nil {
+		log.Fatal(err)
 	}
-
 }
 
 func startAPIServer(apiAddr string, gee *geecache.Group) {
 	http.Handle("/api", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		key := req.URL.Query().Get("key")
-		view, err := gee.Get(key)
+		view, err := gee.Get(req.Context(), key)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -92,14 +93,190 @@ func startAPIServer(apiAddr string, gee *geecache.Group) {
 	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
 }
 
+// envOr 返回环境变量 name 的值，没有设置时返回 def
+func envOr(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return def
+}
+
+// etcdFlags 绑定 etcd 相关的命令行参数，参数的默认值可以通过环境变量设置
+type etcdFlags struct {
+	endpoints   string
+	dialTimeout time.Duration
+	username    string
+	password    string
+	caFile      string
+	certFile    string
+	keyFile     string
+	leaseTTL    int64
+	prefix      string
+}
+
+func bindEtcdFlags(fs *flag.FlagSet) *etcdFlags {
+	def := registry.DefaultConfig()
+	f := &etcdFlags{}
+	dialTimeout, err := time.ParseDuration(envOr("GEECACHE_ETCD_DIAL_TIMEOUT", def.DialTimeout.String()))
+	if err != nil {
+		log.Fatalf("invalid GEECACHE_ETCD_DIAL_TIMEOUT: %v", err)
+	}
+	leaseTTL, err := strconv.ParseInt(envOr("GEECACHE_ETCD_LEASE_TTL", strconv.FormatInt(def.LeaseTTL, 10)), 10, 64)
+	if err != nil {
+		log.Fatalf("invalid GEECACHE_ETCD_LEASE_TTL: %v", err)
+	}
+	fs.StringVar(&f.endpoints, "etcd-endpoints", envOr("GEECACHE_ETCD_ENDPOINTS", strings.Join(def.Endpoints, ",")), "comma separated etcd endpoints [$GEECACHE_ETCD_ENDPOINTS]")
+	fs.DurationVar(&f.dialTimeout, "etcd-dial-timeout", dialTimeout, "etcd dial timeout [$GEECACHE_ETCD_DIAL_TIMEOUT]")
+	fs.StringVar(&f.username, "etcd-username", envOr("GEECACHE_ETCD_USERNAME", ""), "etcd username [$GEECACHE_ETCD_USERNAME]")
+	// 密码不作为默认值，避免出现在 -h 的输出中
+	fs.StringVar(&f.password, "etcd-password", "", "etcd password [$GEECACHE_ETCD_PASSWORD]")
+	fs.StringVar(&f.caFile, "etcd-ca", envOr("GEECACHE_ETCD_CA", ""), "CA file to verify etcd, enables TLS [$GEECACHE_ETCD_CA]")
+	fs.StringVar(&f.certFile, "etcd-cert", envOr("GEECACHE_ETCD_CERT", ""), "client certificate file for etcd, enables TLS [$GEECACHE_ETCD_CERT]")
+	fs.StringVar(&f.keyFile, "etcd-key", envOr("GEECACHE_ETCD_KEY", ""), "client key file for etcd [$GEECACHE_ETCD_KEY]")
+	fs.Int64Var(&f.leaseTTL, "etcd-lease-ttl", leaseTTL, "lease ttl in seconds of the service registration [$GEECACHE_ETCD_LEASE_TTL]")
+	fs.StringVar(&f.prefix, "etcd-prefix", envOr("GEECACHE_ETCD_PREFIX", def.Prefix), "key prefix of the service registration [$GEECACHE_ETCD_PREFIX]")
+	return f
+}
+
+// config 把命令行参数转换成 registry.Config
+func (f *etcdFlags) config() (registry.Config, error) {
+	cfg := registry.Config{
+		Endpoints:   strings.Split(f.endpoints, ","),
+		DialTimeout: f.dialTimeout,
+		Username:    f.username,
+		Password:    envOr("GEECACHE_ETCD_PASSWORD", ""),
+		LeaseTTL:    f.leaseTTL,
+		Prefix:      f.prefix,
+	}
+	if f.password != "" {
+		cfg.Password = f.password
+	}
+	if f.caFile != "" || f.certFile != "" {
+		tlsConfig, err := registry.LoadTLS(f.caFile, f.certFile, f.keyFile)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.TLS = tlsConfig
+	}
+	return cfg, nil
+}
+
+// discoveryFlags 绑定选择服务发现方式的命令行参数
+type discoveryFlags struct {
+	kind      string
+	peers     string
+	peersFile string
+	dnsSRV    string
+}
+
+func bindDiscoveryFlags(fs *flag.FlagSet) *discoveryFlags {
+	f := &discoveryFlags{}
+	fs.StringVar(&f.kind, "discovery", envOr("GEECACHE_DISCOVERY", "etcd"), "peer discovery: etcd, static, file or dns [$GEECACHE_DISCOVERY]")
+	fs.StringVar(&f.peers, "peers", envOr("GEECACHE_PEERS", ""), "comma separated peer addresses for static discovery [$GEECACHE_PEERS]")
+	fs.StringVar(&f.peersFile, "peers-file", envOr("GEECACHE_PEERS_FILE", ""), "JSON or YAML peers file for file discovery [$GEECACHE_PEERS_FILE]")
+	fs.StringVar(&f.dnsSRV, "dns-srv", envOr("GEECACHE_DNS_SRV", ""), "SRV record like _grpc._tcp.geecache.local for dns discovery [$GEECACHE_DNS_SRV]")
+	return f
+}
+
+// discovery 根据命令行参数创建 registry.Discovery
+func (f *discoveryFlags) discovery(etcd registry.Config) (registry.Discovery, error) {
+	switch f.kind {
+	case "etcd":
+		return registry.NewEtcd(etcd), nil
+	case "static":
+		if f.peers == "" {
+			return nil, fmt.Errorf("-peers is required by static discovery")
+		}
+		return registry.NewStatic(strings.Split(f.peers, ",")...), nil
+	case "file":
+		if f.peersFile == "" {
+			return nil, fmt.Errorf("-peers-file is required by file discovery")
+		}
+		return registry.NewFile(f.peersFile, 0), nil
+	case "dns":
+		if f.dnsSRV == "" {
+			return nil, fmt.Errorf("-dns-srv is required by dns discovery")
+		}
+		return registry.NewDNS("", "", f.dnsSRV, 0), nil
+	}
+	return nil, fmt.Errorf("unknown discovery %q", f.kind)
+}
+
+// placement 根据名称返回创建 consistenthash.Placement 的函数
+func placement(name string) (func() consistenthash.Placement, error) {
+	switch name {
+	case "ring":
+		return func() consistenthash.Placement { return consistenthash.New(50, nil) }, nil
+	case "rendezvous":
+		return func() consistenthash.Placement { return consistenthash.NewRendezvous(nil) }, nil
+	case "jump":
+		return func() consistenthash.Placement { return consistenthash.NewJump(nil) }, nil
+	case "maglev":
+		return func() consistenthash.Placement { return consistenthash.NewMaglev(0, nil) }, nil
+	}
+	return nil, fmt.Errorf("unknown placement %q", name)
+}
+
 func main() {
 	// 模拟MySQL数据库 用于peanutcache从数据源获取值
 	var port int
 	var api bool
+	var weight int
+	var boundedLoad float64
+	var placementName string
+	var zone string
+	var zoneAware, zoneFallback bool
+	var adminAddr string
+	var metricsAddr string
+	var drainTimeout time.Duration
 	flag.IntVar(&port, "port", 8001, "Geecache server port")
 	flag.BoolVar(&api, "api", false, "Start a api server?")
+	defaultWeight, err := strconv.Atoi(envOr("GEECACHE_WEIGHT", "1"))
+	if err != nil {
+		log.Fatalf("invalid GEECACHE_WEIGHT: %v", err)
+	}
+	flag.IntVar(&weight, "weight", defaultWeight, "weight of this node on the hash ring, e.g. memory in GB [$GEECACHE_WEIGHT]")
+	defaultBoundedLoad, err := strconv.ParseFloat(envOr("GEECACHE_BOUNDED_LOAD", "0"), 64)
+	if err != nil {
+		log.Fatalf("invalid GEECACHE_BOUNDED_LOAD: %v", err)
+	}
+	flag.Float64Var(&boundedLoad, "bounded-load", defaultBoundedLoad, "cap every node at (1+ε)× the average in-flight requests, 0 disables it [$GEECACHE_BOUNDED_LOAD]")
+	flag.StringVar(&placementName, "placement", envOr("GEECACHE_PLACEMENT", "ring"), "key placement: ring, rendezvous, jump or maglev, must be the same on every node [$GEECACHE_PLACEMENT]")
+	flag.StringVar(&zone, "zone", envOr("GEECACHE_ZONE", ""), "availability zone of this node, registered to discovery [$GEECACHE_ZONE]")
+	defaultZoneAware, err := strconv.ParseBool(envOr("GEECACHE_ZONE_AWARE", "false"))
+	if err != nil {
+		log.Fatalf("invalid GEECACHE_ZONE_AWARE: %v", err)
+	}
+	flag.BoolVar(&zoneAware, "zone-aware", defaultZoneAware, "route reads only to owners in the same zone, must be the same on every node [$GEECACHE_ZONE_AWARE]")
+	defaultZoneFallback, err := strconv.ParseBool(envOr("GEECACHE_ZONE_FALLBACK", "true"))
+	if err != nil {
+		log.Fatalf("invalid GEECACHE_ZONE_FALLBACK: %v", err)
+	}
+	flag.BoolVar(&zoneFallback, "zone-fallback", defaultZoneFallback, "with -zone-aware, fall back to cross-zone owners when the local zone has no healthy owner [$GEECACHE_ZONE_FALLBACK]")
+	flag.StringVar(&adminAddr, "admin", envOr("GEECACHE_ADMIN_ADDR", ""), "address of the admin http server, e.g. :9090, empty disables it [$GEECACHE_ADMIN_ADDR]")
+	flag.StringVar(&metricsAddr, "metrics", envOr("GEECACHE_METRICS_ADDR", ""), "address of the prometheus /metrics http server, e.g. :9100, empty disables it [$GEECACHE_METRICS_ADDR]")
+	defaultDrainTimeout, err := time.ParseDuration(envOr("GEECACHE_DRAIN_TIMEOUT", "30s"))
+	if err != nil {
+		log.Fatalf("invalid GEECACHE_DRAIN_TIMEOUT: %v", err)
+	}
+	flag.DurationVar(&drainTimeout, "drain-timeout", defaultDrainTimeout, "max time to drain on SIGTERM before exiting [$GEECACHE_DRAIN_TIMEOUT]")
+	etcd := bindEtcdFlags(flag.CommandLine)
+	disc := bindDiscoveryFlags(flag.CommandLine)
 	flag.Parse()
 
+	etcdConfig, err := etcd.config()
+	if err != nil {
+		log.Fatal(err)
+	}
+	discovery, err := disc.discovery(etcdConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	newPlacement, err := placement(placementName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	apiAddr := "http://49.123.84.136:9999"
 	addrMap := map[int]string{
 		8001: "49.123.84.136:8001",
@@ -112,21 +289,43 @@ func main() {
 	// New一个服务实例
 	//var addr string = "localhost:9999"
 	var addr string = addrMap[port]
-	svr, err := geecache.NewServer(addr)
+	opts := []geecache.ServerOption{geecache.WithDiscovery(discovery), geecache.WithWeight(weight), geecache.WithPlacement(newPlacement),
+		geecache.WithZone(zone), geecache.WithVersion(version)}
+	if boundedLoad > 0 {
+		opts = append(opts, geecache.WithBoundedLoad(boundedLoad))
+	}
+	if zoneAware {
+		opts = append(opts, geecache.WithZoneAware(zoneFallback))
+	}
+	svr, err := geecache.NewServer(addr, opts...)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if adminAddr != "" {
+		go func() {
+			log.Println("admin server is running at", adminAddr)
+			log.Fatal(http.ListenAndServe(adminAddr, svr.AdminHandler()))
+		}()
+	}
+	if metricsAddr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", svr.MetricsHandler())
+			log.Println("metrics server is running at", metricsAddr)
+			log.Fatal(http.ListenAndServe(metricsAddr, mux))
+		}()
+	}
 	// 设置同伴节点IP(包括自己)
-	// todo: 这里的peer地址从etcd获取(服务发现)
-	addrs, err := svr.GetPeersFromEtcd()
+	// 这里的peer地址从 discovery 获取(服务发现)
+	peers, err := svr.GetPeers()
 	if err != nil {
 		log.Fatal(err)
 	}
-	addrs = append(addrs, addr) //把自己注册到了哈希环
-	log.Println(addrs)
+	peers = append(peers, svr.Endpoint()) //把自己注册到了哈希环
+	log.Println(peers)
 	if api {
 		go startAPIServer(apiAddr, group)
 	}
 
-	startCacheServer(svr, addrMap[port], []string(addrs), group)
+	startCacheServer(svr, peers, group, drainTimeout)
 }